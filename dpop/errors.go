@@ -0,0 +1,40 @@
+package dpop
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/wisdom-oss/common-go/v2/types"
+)
+
+var (
+	errProofMissing        = errors.New("dpop proof missing")
+	errMultipleProofs      = errors.New("multiple dpop proofs supplied")
+	errInvalidType         = errors.New("dpop proof has invalid type")
+	errUnsupportedAlg      = errors.New("dpop proof uses an unsupported algorithm")
+	errMissingKey          = errors.New("dpop proof does not contain a public key")
+	errPrivateKey          = errors.New("dpop proof contains a private key")
+	errMissingTokenID      = errors.New("dpop proof does not contain a token id")
+	errMethodMismatch      = errors.New("dpop proof has been issued for another http method")
+	errURIMismatch         = errors.New("dpop proof has been issued for another uri")
+	errProofExpired        = errors.New("dpop proof is too old or has been issued in the future")
+	errAccessTokenMismatch = errors.New("dpop proof has been issued for another access token")
+	errProofReplayed       = errors.New("dpop proof has already been used")
+	errKeyMismatch         = errors.New("dpop proof has not been signed with the key bound to the access token")
+	errTokenNotBound       = errors.New("access token is not bound to a dpop key")
+	errBoundTokenAsBearer  = errors.New("dpop bound access token used as bearer token")
+)
+
+var ErrInvalidProof = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc9449#section-7.1",
+	Status: http.StatusUnauthorized,
+	Title:  "Invalid DPoP Proof",
+	Detail: "The DPoP proof supplied with the request is invalid. Please check the errors for more information",
+}
+
+var ErrInvalidToken = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc9449#section-7.1",
+	Status: http.StatusUnauthorized,
+	Title:  "Invalid DPoP Bound Token",
+	Detail: "The access token is either not bound to the key used for the DPoP proof or a bound token has been used without a proof",
+}
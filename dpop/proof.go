@@ -0,0 +1,209 @@
+// Package dpop implements the verification of proofs for the
+// Demonstrating Proof of Possession (DPoP) mechanism defined in RFC 9449.
+// DPoP binds tokens to a key held by the client and thereby prevents the
+// usage of leaked tokens by other parties.
+package dpop
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+// HeaderName contains the name of the header carrying the proof
+const HeaderName = "DPoP"
+
+// ProofType contains the value of the typ header required in a proof
+const ProofType = "dpop+jwt"
+
+// KeyThumbprint is the key under which the thumbprint of the key used for a
+// verified proof is stored in the gin context
+const KeyThumbprint = "dpop-thumbprint"
+
+// TrustedProxies contains the networks of the reverse proxies in front of the
+// service. The forwarded headers are only respected for requests sent by
+// one of them, as they are controlled by the sender otherwise
+var TrustedProxies []netip.Prefix
+
+// SupportedAlgorithms contains the signature algorithms accepted for proofs
+var SupportedAlgorithms = []jwa.SignatureAlgorithm{
+	jwa.ES256, jwa.ES384, jwa.ES512,
+	jwa.RS256, jwa.RS384, jwa.RS512,
+	jwa.PS256, jwa.PS384, jwa.PS512,
+	jwa.EdDSA,
+}
+
+// Proof contains the information of a verified proof
+type Proof struct {
+	// ID contains the jti claim of the proof
+	ID string
+
+	// Thumbprint contains the base64url-encoded JWK SHA-256 thumbprint of the
+	// key used to sign the proof
+	Thumbprint string
+
+	// IssuedAt contains the time the proof has been created at
+	IssuedAt time.Time
+}
+
+// parseProof verifies the signature of the proof and validates the claims
+// contained in it against the request method and uri.
+// If an access token is supplied, the proof needs to contain the hash of it.
+func parseProof(rawProof string, method string, uri string, accessToken string, maxAge time.Duration) (*Proof, error) {
+	message, err := jws.Parse([]byte(rawProof))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(message.Signatures()) != 1 {
+		return nil, errMultipleProofs
+	}
+	headers := message.Signatures()[0].ProtectedHeaders()
+
+	if headers.Type() != ProofType {
+		return nil, errInvalidType
+	}
+
+	algorithm := headers.Algorithm()
+	if !slices.Contains(SupportedAlgorithms, algorithm) {
+		return nil, errUnsupportedAlg
+	}
+
+	key := headers.JWK()
+	if key == nil {
+		return nil, errMissingKey
+	}
+
+	switch key.(type) {
+	case jwk.ECDSAPrivateKey, jwk.RSAPrivateKey, jwk.OKPPrivateKey, jwk.SymmetricKey:
+		return nil, errPrivateKey
+	}
+
+	proof, err := jwt.Parse([]byte(rawProof),
+		jwt.WithKey(algorithm, key),
+		jwt.WithValidate(true),
+		jwt.WithAcceptableSkew(5*time.Second),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if proof.JwtID() == "" {
+		return nil, errMissingTokenID
+	}
+
+	if issuedAt := proof.IssuedAt(); issuedAt.IsZero() || time.Since(issuedAt) > maxAge {
+		return nil, errProofExpired
+	}
+
+	if htm, _ := proof.Get("htm"); htm != method {
+		return nil, errMethodMismatch
+	}
+
+	htu, _ := proof.Get("htu")
+	if rawHtu, ok := htu.(string); !ok || !sameURI(rawHtu, uri) {
+		return nil, errURIMismatch
+	}
+
+	if accessToken != "" {
+		hash := sha256.Sum256([]byte(accessToken))
+		if ath, _ := proof.Get("ath"); ath != base64.RawURLEncoding.EncodeToString(hash[:]) {
+			return nil, errAccessTokenMismatch
+		}
+	}
+
+	thumbprint, err := Thumbprint(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Proof{
+		ID:         proof.JwtID(),
+		Thumbprint: thumbprint,
+		IssuedAt:   proof.IssuedAt(),
+	}, nil
+}
+
+// Thumbprint calculates the base64url-encoded JWK SHA-256 thumbprint of the
+// key which is used in the cnf.jkt claim of bound tokens
+func Thumbprint(key jwk.Key) (string, error) {
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
+// RequestURI reconstructs the uri the client sent the request to
+func RequestURI(r *http.Request) string {
+	return BaseURL(r) + r.URL.Path
+}
+
+// BaseURL reconstructs the url under which the client reaches the service.
+// It respects the X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-Prefix
+// headers set by the TrustedProxies
+func BaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if !sentByTrustedProxy(r) {
+		return fmt.Sprintf("%s://%s", scheme, host)
+	}
+
+	if proxiedScheme := r.Header.Get("X-Forwarded-Proto"); proxiedScheme != "" {
+		scheme = proxiedScheme
+	}
+	if proxiedHost := r.Header.Get("X-Forwarded-Host"); proxiedHost != "" {
+		host = proxiedHost
+	}
+
+	prefix := strings.TrimSuffix(r.Header.Get("X-Forwarded-Prefix"), "/")
+	return fmt.Sprintf("%s://%s%s", scheme, host, prefix)
+}
+
+// sentByTrustedProxy reports if the request has been sent by one of the
+// TrustedProxies
+func sentByTrustedProxy(r *http.Request) bool {
+	address, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	for _, proxy := range TrustedProxies {
+		if proxy.Contains(address.Addr().Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameURI compares the two uris while ignoring the query and fragment
+// components as well as the case of the scheme and host as defined in
+// RFC 9449 Section 4.3
+func sameURI(a string, b string) bool {
+	uriA, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	uriB, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(uriA.Scheme, uriB.Scheme) &&
+		strings.EqualFold(uriA.Host, uriB.Host) &&
+		uriA.EscapedPath() == uriB.EscapedPath()
+}
@@ -0,0 +1,268 @@
+package dpop
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+)
+
+const testURI = "https://example.com/api/auth/token"
+
+// newKey generates the private key of a client
+func newKey(t *testing.T) jwk.Key {
+	t.Helper()
+	rawKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwk.FromRaw(rawKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// proofOptions allow changing the claims and headers of a proof before it is
+// signed
+type proofOptions struct {
+	claims  map[string]any
+	headers map[string]any
+}
+
+// newProof creates a proof for a POST request to the testURI signed with the
+// key. The claims and headers are overwritten by the options, nil values
+// remove them
+func newProof(t *testing.T, key jwk.Key, options proofOptions) string {
+	t.Helper()
+	publicKey, err := key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := jwt.New()
+	claims := map[string]any{
+		jwt.JwtIDKey:    "proof-id",
+		jwt.IssuedAtKey: time.Now(),
+		"htm":           http.MethodPost,
+		"htu":           testURI,
+	}
+	for name, value := range options.claims {
+		claims[name] = value
+	}
+	for name, value := range claims {
+		if value == nil {
+			continue
+		}
+		if err := token.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	headers := jws.NewHeaders()
+	headerValues := map[string]any{
+		jws.TypeKey: ProofType,
+		jws.JWKKey:  publicKey,
+	}
+	for name, value := range options.headers {
+		headerValues[name] = value
+	}
+	for name, value := range headerValues {
+		if value == nil {
+			continue
+		}
+		if err := headers.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	proof, err := jwt.Sign(token, jwt.WithKey(jwa.ES256, key, jws.WithProtectedHeaders(headers)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(proof)
+}
+
+// accessTokenHash calculates the ath claim binding a proof to the access
+// token
+func accessTokenHash(accessToken string) string {
+	hash := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func TestParseProof(t *testing.T) {
+	key := newKey(t)
+	publicKey, err := key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedThumbprint, err := Thumbprint(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rawProof := newProof(t, key, proofOptions{claims: map[string]any{
+		"ath": accessTokenHash("access-token"),
+	}})
+
+	proof, err := parseProof(rawProof, http.MethodPost, testURI, "access-token", DefaultProofLifetime)
+	if err != nil {
+		t.Fatalf("valid proof rejected: %v", err)
+	}
+	if proof.ID != "proof-id" {
+		t.Errorf("unexpected proof id %q", proof.ID)
+	}
+	if proof.Thumbprint != expectedThumbprint {
+		t.Errorf("expected thumbprint %q, got %q", expectedThumbprint, proof.Thumbprint)
+	}
+}
+
+func TestParseProofRejectsInvalidProofs(t *testing.T) {
+	key := newKey(t)
+
+	tests := map[string]struct {
+		options     proofOptions
+		accessToken string
+		expected    error
+	}{
+		"invalidType": {
+			options:  proofOptions{headers: map[string]any{jws.TypeKey: "JWT"}},
+			expected: errInvalidType,
+		},
+		"missingKey": {
+			options:  proofOptions{headers: map[string]any{jws.JWKKey: nil}},
+			expected: errMissingKey,
+		},
+		"privateKey": {
+			options:  proofOptions{headers: map[string]any{jws.JWKKey: key}},
+			expected: errPrivateKey,
+		},
+		"missingTokenID": {
+			options:  proofOptions{claims: map[string]any{jwt.JwtIDKey: nil}},
+			expected: errMissingTokenID,
+		},
+		"missingIssuedAt": {
+			options:  proofOptions{claims: map[string]any{jwt.IssuedAtKey: nil}},
+			expected: errProofExpired,
+		},
+		"tooOld": {
+			options:  proofOptions{claims: map[string]any{jwt.IssuedAtKey: time.Now().Add(-2 * DefaultProofLifetime)}},
+			expected: errProofExpired,
+		},
+		"otherMethod": {
+			options:  proofOptions{claims: map[string]any{"htm": http.MethodGet}},
+			expected: errMethodMismatch,
+		},
+		"otherURI": {
+			options:  proofOptions{claims: map[string]any{"htu": "https://attacker.example/api/auth/token"}},
+			expected: errURIMismatch,
+		},
+		"missingAccessTokenHash": {
+			accessToken: "access-token",
+			expected:    errAccessTokenMismatch,
+		},
+		"otherAccessToken": {
+			options:     proofOptions{claims: map[string]any{"ath": "other-hash"}},
+			accessToken: "access-token",
+			expected:    errAccessTokenMismatch,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			rawProof := newProof(t, key, test.options)
+			_, err := parseProof(rawProof, http.MethodPost, testURI, test.accessToken, DefaultProofLifetime)
+			if !errors.Is(err, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestParseProofRejectsFutureProofs(t *testing.T) {
+	rawProof := newProof(t, newKey(t), proofOptions{claims: map[string]any{jwt.IssuedAtKey: time.Now().Add(time.Minute)}})
+	if _, err := parseProof(rawProof, http.MethodPost, testURI, "", DefaultProofLifetime); err == nil {
+		t.Error("proof issued in the future accepted")
+	}
+}
+
+func TestSameURI(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"https://example.com/token", "https://example.com/token", true},
+		{"HTTPS://Example.COM/token", "https://example.com/token", true},
+		{"https://example.com/token?query=1#fragment", "https://example.com/token", true},
+		{"https://example.com/token", "http://example.com/token", false},
+		{"https://example.com/token", "https://example.org/token", false},
+		{"https://example.com/token", "https://example.com/Token", false},
+		{"https://example.com:8443/token", "https://example.com/token", false},
+		{"://invalid", "https://example.com/token", false},
+	}
+	for _, test := range tests {
+		if same := sameURI(test.a, test.b); same != test.same {
+			t.Errorf("sameURI(%q, %q): expected %v, got %v", test.a, test.b, test.same, same)
+		}
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	previousProxies := TrustedProxies
+	t.Cleanup(func() { TrustedProxies = previousProxies })
+	TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	forwardedHeaders := map[string]string{
+		"X-Forwarded-Proto":  "https",
+		"X-Forwarded-Host":   "example.com",
+		"X-Forwarded-Prefix": "/api/auth/",
+	}
+
+	tests := map[string]struct {
+		remoteAddress string
+		headers       map[string]string
+		tls           bool
+		expected      string
+	}{
+		"direct":                {remoteAddress: "192.0.2.1:4711", expected: "http://service.internal"},
+		"directTLS":             {remoteAddress: "192.0.2.1:4711", tls: true, expected: "https://service.internal"},
+		"untrustedProxy":        {remoteAddress: "192.0.2.1:4711", headers: forwardedHeaders, expected: "http://service.internal"},
+		"trustedProxy":          {remoteAddress: "10.1.2.3:4711", headers: forwardedHeaders, expected: "https://example.com/api/auth"},
+		"trustedMappedProxy":    {remoteAddress: "[::ffff:10.1.2.3]:4711", headers: forwardedHeaders, expected: "https://example.com/api/auth"},
+		"trustedProxyNoHeaders": {remoteAddress: "10.1.2.3:4711", expected: "http://service.internal"},
+		"invalidRemoteAddress":  {remoteAddress: "pipe", headers: forwardedHeaders, expected: "http://service.internal"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "http://service.internal/token", nil)
+			r.RemoteAddr = test.remoteAddress
+			if !test.tls {
+				r.TLS = nil
+			} else {
+				r.TLS = &tls.ConnectionState{}
+			}
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+
+			if baseURL := BaseURL(r); baseURL != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, baseURL)
+			}
+			if requestURI := RequestURI(r); requestURI != test.expected+"/token" {
+				t.Errorf("expected %q, got %q", test.expected+"/token", requestURI)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package dpop
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultProofLifetime sets the maximal age of a proof if no lifetime has been
+// configured in the Validator
+const DefaultProofLifetime = 5 * time.Minute
+
+// replayKeyPrefix is used for the keys storing the identifiers of already
+// used proofs in redis
+const replayKeyPrefix = "dpop-jti:"
+
+// Validator verifies DPoP proofs and protects against the replay of proofs by
+// storing the identifiers of used proofs in redis until they expire.
+// To protect routes of a gin router, mount the GinHandler in front of the
+// JWT validator
+type Validator struct {
+	// Redis is used to store the identifiers of already used proofs. As redis
+	// is shared between instances, a proof can only be used once across all
+	// instances
+	Redis *redis.Client
+
+	// ProofLifetime sets the maximal age of a proof. Defaults to
+	// DefaultProofLifetime
+	ProofLifetime time.Duration
+}
+
+// Verify validates the proof contained in the request against the uri the
+// request has been sent to.
+// If accessToken is not empty, the proof needs to be bound to the access
+// token.
+// Every proof is only accepted once
+func (v Validator) Verify(r *http.Request, accessToken string) (*Proof, error) {
+	return v.VerifyFor(r, RequestURI(r), accessToken)
+}
+
+// VerifyFor validates the proof contained in the request against the
+// supplied uri. It is used by endpoints which know the uri they are
+// reachable at
+func (v Validator) VerifyFor(r *http.Request, uri string, accessToken string) (*Proof, error) {
+	proofHeaders := r.Header.Values(HeaderName)
+	if len(proofHeaders) == 0 {
+		return nil, errProofMissing
+	}
+	if len(proofHeaders) > 1 {
+		return nil, errMultipleProofs
+	}
+
+	lifetime := v.ProofLifetime
+	if lifetime == 0 {
+		lifetime = DefaultProofLifetime
+	}
+
+	proof, err := parseProof(proofHeaders[0], r.Method, uri, accessToken, lifetime)
+	if err != nil {
+		return nil, err
+	}
+
+	err = v.markUsed(r.Context(), proof, lifetime)
+	if err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// markUsed records the proof as used until it has expired
+func (v Validator) markUsed(ctx context.Context, proof *Proof, lifetime time.Duration) error {
+	key := fmt.Sprintf("%s%s:%s", replayKeyPrefix, proof.Thumbprint, proof.ID)
+	ttl := time.Until(proof.IssuedAt.Add(lifetime))
+	if ttl <= 0 {
+		return errProofExpired
+	}
+
+	firstUse, err := v.Redis.SetNX(ctx, key, true, ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !firstUse {
+		return errProofReplayed
+	}
+	return nil
+}
+
+// GinHandler verifies the DPoP proofs sent together with access tokens using
+// the DPoP authorization scheme.
+// After a successful verification, the authorization header is rewritten to
+// the Bearer scheme to allow the JWT validator to validate the token itself.
+// Access tokens bound to a key are rejected if they are sent using the Bearer
+// scheme.
+// The thumbprint of the key used for the proof is stored under KeyThumbprint
+// in the context
+func (v Validator) GinHandler(c *gin.Context) {
+	header := strings.TrimSpace(c.GetHeader("Authorization"))
+	scheme, accessToken, _ := strings.Cut(header, " ")
+	accessToken = strings.TrimSpace(accessToken)
+
+	switch {
+	case strings.EqualFold(scheme, "DPoP"):
+		proof, err := v.Verify(c.Request, accessToken)
+		if err != nil {
+			c.Abort()
+			c.Header("WWW-Authenticate", `DPoP error="invalid_dpop_proof"`)
+			res := ErrInvalidProof
+			res.Errors = []error{err}
+			res.Emit(c)
+			return
+		}
+
+		boundThumbprint, err := confirmationThumbprint(accessToken)
+		if err != nil || boundThumbprint == "" {
+			c.Abort()
+			c.Header("WWW-Authenticate", `DPoP error="invalid_token"`)
+			res := ErrInvalidToken
+			res.Errors = []error{errTokenNotBound}
+			res.Emit(c)
+			return
+		}
+
+		if boundThumbprint != proof.Thumbprint {
+			c.Abort()
+			c.Header("WWW-Authenticate", `DPoP error="invalid_token"`)
+			res := ErrInvalidToken
+			res.Errors = []error{errKeyMismatch}
+			res.Emit(c)
+			return
+		}
+
+		c.Set(KeyThumbprint, proof.Thumbprint)
+		c.Request.Header.Set("Authorization", "Bearer "+accessToken)
+	case strings.EqualFold(scheme, "Bearer"):
+		boundThumbprint, _ := confirmationThumbprint(accessToken)
+		if boundThumbprint != "" {
+			c.Abort()
+			c.Header("WWW-Authenticate", `DPoP error="invalid_token"`)
+			res := ErrInvalidToken
+			res.Errors = []error{errBoundTokenAsBearer}
+			res.Emit(c)
+			return
+		}
+	}
+
+	c.Next()
+}
+
+// confirmationThumbprint reads the cnf.jkt claim from the access token.
+// The signature of the token is not verified as this is the responsibility of
+// the JWT validator
+func confirmationThumbprint(accessToken string) (string, error) {
+	token, err := jwt.ParseInsecure([]byte(accessToken))
+	if err != nil {
+		return "", err
+	}
+	return ConfirmationThumbprint(token), nil
+}
+
+// ConfirmationThumbprint returns the thumbprint of the key the token is bound
+// to. If the token is not bound, an empty string is returned
+func ConfirmationThumbprint(token jwt.Token) string {
+	confirmation, set := token.Get("cnf")
+	if !set {
+		return ""
+	}
+
+	switch cnf := confirmation.(type) {
+	case map[string]any:
+		thumbprint, _ := cnf["jkt"].(string)
+		return thumbprint
+	case map[string]string:
+		return cnf["jkt"]
+	default:
+		return ""
+	}
+}
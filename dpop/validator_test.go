@@ -0,0 +1,179 @@
+package dpop
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+
+	"microservice/internal/redistest"
+)
+
+// proofRequest creates a POST request to the testURI containing the proofs
+func proofRequest(proofs ...string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, testURI, nil)
+	for _, proof := range proofs {
+		r.Header.Add(HeaderName, proof)
+	}
+	return r
+}
+
+func TestVerifyRejectsReplayedProofs(t *testing.T) {
+	validator := Validator{Redis: redistest.NewClient(t)}
+	key := newKey(t)
+	proof := newProof(t, key, proofOptions{})
+
+	_, err := validator.VerifyFor(proofRequest(proof), testURI, "")
+	if err != nil {
+		t.Fatalf("valid proof rejected: %v", err)
+	}
+
+	_, err = validator.VerifyFor(proofRequest(proof), testURI, "")
+	if !errors.Is(err, errProofReplayed) {
+		t.Errorf("expected %v, got %v", errProofReplayed, err)
+	}
+
+	otherProof := newProof(t, key, proofOptions{claims: map[string]any{jwt.JwtIDKey: "other-proof-id"}})
+	_, err = validator.VerifyFor(proofRequest(otherProof), testURI, "")
+	if err != nil {
+		t.Errorf("proof with another id rejected: %v", err)
+	}
+}
+
+func TestVerifyRequiresSingleProof(t *testing.T) {
+	validator := Validator{Redis: redistest.NewClient(t)}
+	key := newKey(t)
+
+	_, err := validator.VerifyFor(proofRequest(), testURI, "")
+	if !errors.Is(err, errProofMissing) {
+		t.Errorf("expected %v, got %v", errProofMissing, err)
+	}
+
+	_, err = validator.VerifyFor(proofRequest(newProof(t, key, proofOptions{}), newProof(t, key, proofOptions{})), testURI, "")
+	if !errors.Is(err, errMultipleProofs) {
+		t.Errorf("expected %v, got %v", errMultipleProofs, err)
+	}
+}
+
+func TestVerifyForChecksSuppliedURI(t *testing.T) {
+	validator := Validator{Redis: redistest.NewClient(t)}
+
+	// the request has been sent to another host than the proof names, e.g.
+	// when the host header is controlled by the client
+	r := proofRequest(newProof(t, newKey(t), proofOptions{}))
+	r.Host = "attacker.example"
+	_, err := validator.VerifyFor(r, testURI, "")
+	if err != nil {
+		t.Errorf("proof for the supplied uri rejected: %v", err)
+	}
+
+	r = proofRequest(newProof(t, newKey(t), proofOptions{claims: map[string]any{"htu": "https://attacker.example/api/auth/token"}}))
+	r.Host = "attacker.example"
+	_, err = validator.VerifyFor(r, testURI, "")
+	if !errors.Is(err, errURIMismatch) {
+		t.Errorf("expected %v, got %v", errURIMismatch, err)
+	}
+}
+
+// newAccessToken creates an unsigned access token bound to the thumbprint
+func newAccessToken(t *testing.T, thumbprint string) string {
+	t.Helper()
+	builder := jwt.NewBuilder().Subject("user").Expiration(time.Now().Add(time.Hour))
+	if thumbprint != "" {
+		builder.Claim("cnf", map[string]string{"jkt": thumbprint})
+	}
+	token, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serializedToken, err := jwt.Sign(token, jwt.WithInsecureNoSignature())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(serializedToken)
+}
+
+func TestGinHandler(t *testing.T) {
+	validator := Validator{Redis: redistest.NewClient(t)}
+	key := newKey(t)
+	publicKey, err := key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumbprint, err := Thumbprint(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	boundToken := newAccessToken(t, thumbprint)
+	tests := map[string]struct {
+		scheme      string
+		accessToken string
+		accepted    bool
+	}{
+		"boundToken":         {scheme: "DPoP", accessToken: boundToken, accepted: true},
+		"boundTokenAsBearer": {scheme: "Bearer", accessToken: boundToken},
+		"unboundToken":       {scheme: "DPoP", accessToken: newAccessToken(t, "")},
+		"otherKey":           {scheme: "DPoP", accessToken: newAccessToken(t, "other-thumbprint")},
+		"bearerToken":        {scheme: "Bearer", accessToken: newAccessToken(t, ""), accepted: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = proofRequest()
+			if test.scheme == "DPoP" {
+				c.Request.Header.Set(HeaderName, newProof(t, key, proofOptions{claims: map[string]any{
+					jwt.JwtIDKey: name,
+					"ath":        accessTokenHash(test.accessToken),
+				}}))
+			}
+			c.Request.Header.Set("Authorization", test.scheme+" "+test.accessToken)
+
+			validator.GinHandler(c)
+			if c.IsAborted() == test.accepted {
+				t.Fatalf("expected accepted to be %v, got response %q", test.accepted, recorder.Body.String())
+			}
+			if !test.accepted {
+				return
+			}
+			if authorization := c.Request.Header.Get("Authorization"); authorization != "Bearer "+test.accessToken {
+				t.Errorf("authorization header not rewritten: %q", authorization)
+			}
+			if test.scheme == "DPoP" && c.GetString(KeyThumbprint) != thumbprint {
+				t.Errorf("thumbprint not stored in the context")
+			}
+		})
+	}
+}
+
+func TestConfirmationThumbprint(t *testing.T) {
+	token := jwt.New()
+	if thumbprint := ConfirmationThumbprint(token); thumbprint != "" {
+		t.Errorf("unbound token reported as bound to %q", thumbprint)
+	}
+
+	if err := token.Set("cnf", map[string]string{"jkt": "thumbprint"}); err != nil {
+		t.Fatal(err)
+	}
+	if thumbprint := ConfirmationThumbprint(token); thumbprint != "thumbprint" {
+		t.Errorf("expected thumbprint, got %q", thumbprint)
+	}
+
+	signedToken, err := jwt.Sign(token, jwt.WithKey(jwa.HS256, []byte("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedToken, err := jwt.ParseInsecure(signedToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumbprint := ConfirmationThumbprint(parsedToken); thumbprint != "thumbprint" {
+		t.Errorf("expected thumbprint of parsed token, got %q", thumbprint)
+	}
+}
@@ -1,34 +1,47 @@
 package main
 
 import (
-	"crypto/x509"
-	"encoding/pem"
+	"context"
 	"errors"
-	"io"
+	"net/netip"
+	"net/url"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	_ "github.com/joho/godotenv/autoload"
-	"github.com/lestrrat-go/jwx/v2/jwa"
-	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/rs/zerolog/pkgerrors"
 
+	"microservice/dpop"
 	"microservice/internal/config"
 	_ "microservice/internal/db" // side effect import to connect to the database and parse the sql queries from it's embed
+	"microservice/internal/keys"
 	"microservice/oidc"
-	"microservice/resources"
+	"microservice/routes"
 	"microservice/utils"
 
 	_ "github.com/wisdom-oss/go-healthcheck/client"
 )
 
+// keyRotationInterval sets how long a key generation stays active
+var keyRotationInterval = keys.DefaultRotationInterval
+
 // init is executed at every startup of the microservice and is always executed
 // before main
 func init() {
 	configureLogger()
-	loadCertificates()
+	configureIssuer()
+	configureTrustedProxies()
+	configureSigningAlgorithms()
+	configureUpstreamAuthoritativeFields()
+	loadKeys()
+	configureKeyRotation()
 	validateOIDCEnvironment()
+	configureLogout()
+	configureAccessTokenClaims()
 }
 
 // configureLogger handles the configuration of the logger used in the
@@ -65,6 +78,27 @@ func configureLogger() {
 	zerolog.SetGlobalLevel(loggingLevel)
 }
 
+// configureIssuer reads the url under which the service is reachable by the
+// clients from the `ISSUER_URL` environment variable. The url needs to use
+// https unless the service is running on the local machine.
+// Without the variable, the urls are derived from the requests
+func configureIssuer() {
+	rawIssuer, isSet := os.LookupEnv("ISSUER_URL")
+	if !isSet {
+		log.Warn().Msg("ISSUER_URL environment variable not set. the urls of the service are derived from the requests")
+		return
+	}
+
+	issuer, err := url.Parse(rawIssuer)
+	if err != nil || !issuer.IsAbs() || issuer.Host == "" || issuer.RawQuery != "" || issuer.Fragment != "" {
+		log.Fatal().Msg("ISSUER_URL needs to be an absolute url without query and fragment")
+	}
+	if issuer.Scheme != "https" && issuer.Hostname() != "localhost" {
+		log.Fatal().Msg("ISSUER_URL needs to use https")
+	}
+	routes.IssuerURL = strings.TrimSuffix(rawIssuer, "/")
+}
+
 func validateOIDCEnvironment() {
 	clientID, isSet := os.LookupEnv("OIDC_CLIENT_ID")
 	if !isSet {
@@ -87,131 +121,152 @@ func validateOIDCEnvironment() {
 	}
 }
 
-func loadCertificates() {
-	// test if the certificates are already present
-	var tries int
-loadCerts:
-	signingCertificate, err := os.Open(config.SigningCertificateFilePath)
-	if err != nil {
-		if tries >= 3 {
-			log.Fatal().Err(err).Msg("unable to open certificate file after three tries")
-		}
-		tries++
-		if errors.Is(err, os.ErrNotExist) {
-			err = utils.GenerateCertificates()
-			if err != nil {
-				log.Fatal().Err(err).Msg("unable to generate certificates")
-			}
-			goto loadCerts
-		}
+// configureTrustedProxies reads the space-separated list of addresses and
+// networks of the reverse proxies in front of the service from the
+// `TRUSTED_PROXIES` environment variable. Only their forwarded headers are
+// respected
+func configureTrustedProxies() {
+	rawProxies, isSet := os.LookupEnv("TRUSTED_PROXIES")
+	if !isSet {
+		return
 	}
 
-	encryptionCertificate, err := os.Open(config.EncryptionCertificateFilePath)
-	if err != nil {
-		if tries >= 3 {
-			log.Fatal().Err(err).Msg("unable to open certificate file after three tries")
-		}
-		tries++
-		if errors.Is(err, os.ErrNotExist) {
-			err = utils.GenerateCertificates()
-			if err != nil {
-				log.Fatal().Err(err).Msg("unable to generate certificates")
+	var proxies []netip.Prefix
+	for _, rawProxy := range strings.Fields(rawProxies) {
+		proxy, err := netip.ParsePrefix(rawProxy)
+		if err != nil {
+			address, addressErr := netip.ParseAddr(rawProxy)
+			if addressErr != nil {
+				log.Fatal().Err(err).Str("proxy", rawProxy).Msg("unable to parse trusted proxy")
 			}
-			goto loadCerts
+			proxy = netip.PrefixFrom(address, address.BitLen())
 		}
+		proxies = append(proxies, proxy.Masked())
 	}
+	dpop.TrustedProxies = proxies
+}
 
-	defer signingCertificate.Close()
-	certificateContents, err := io.ReadAll(signingCertificate)
-	if err != nil {
-		log.Fatal().Err(err).Msg("unable to read certificate file")
-	}
-	privateKeyBlock, _ := pem.Decode(certificateContents)
-	if privateKeyBlock.Type != "EC PRIVATE KEY" {
-		log.Fatal().Msg("unsupported private key type")
+// configureLogout reads the uris a user may be redirected to after the
+// logout from the space-separated `POST_LOGOUT_REDIRECT_URIS` environment
+// variable
+func configureLogout() {
+	redirectURIs, isSet := os.LookupEnv("POST_LOGOUT_REDIRECT_URIS")
+	if !isSet {
+		return
 	}
+	routes.PostLogoutRedirectURIs = strings.Fields(redirectURIs)
+}
 
-	ecdsaPrivateKey, err := x509.ParseECPrivateKey(privateKeyBlock.Bytes)
-	if err != nil {
-		log.Fatal().Err(err).Msg("unable to parse ECDSA private key")
+// configureAccessTokenClaims reads the identity claims added to access tokens
+// from the `ACCESS_TOKEN_CLAIMS` environment variable. It contains a JSON
+// object mapping the audiences to the claims added for them
+func configureAccessTokenClaims() {
+	rawConfiguration, isSet := os.LookupEnv("ACCESS_TOKEN_CLAIMS")
+	if !isSet || strings.TrimSpace(rawConfiguration) == "" {
+		return
 	}
 
-	privateKey, err := jwk.FromRaw(ecdsaPrivateKey)
+	configuration, err := routes.ParseAccessTokenClaims(rawConfiguration)
 	if err != nil {
-		log.Fatal().Err(err).Msg("unable to create jwk private key")
+		log.Fatal().Err(err).Msg("unable to parse access token claim configuration")
 	}
+	routes.AccessTokenClaims = configuration
+}
 
-	err = jwk.AssignKeyID(privateKey)
-	if err != nil {
-		log.Fatal().Err(err).Msg("unable to set private key key id")
-	}
-	err = privateKey.Set(jwk.KeyUsageKey, "sig")
-	if err != nil {
-		log.Fatal().Err(err).Msg("unable to set private key usage type")
-	}
-	err = privateKey.Set(jwk.AlgorithmKey, jwa.ES256)
-	if err != nil {
-		log.Fatal().Err(err).Msg("unable to set private key algorithm")
+// configureSigningAlgorithms reads the space-separated list of signature
+// algorithms keys are generated for from the `SIGNING_ALGORITHMS`
+// environment variable. The first algorithm is used by default
+func configureSigningAlgorithms() {
+	rawAlgorithms, isSet := os.LookupEnv("SIGNING_ALGORITHMS")
+	if !isSet {
+		return
 	}
 
-	publicKey, err := jwk.PublicKeyOf(privateKey)
+	algorithms, err := keys.ParseSigningAlgorithms(rawAlgorithms)
 	if err != nil {
-		log.Fatal().Err(err).Msg("unable to parse public key")
+		log.Fatal().Err(err).Msg("unable to parse signing algorithms")
 	}
+	keys.SigningAlgorithms = algorithms
+}
 
-	resources.PublicSigningKey = publicKey
-	resources.PrivateSigningKey = privateKey
-
-	defer encryptionCertificate.Close()
-	certificateContents, err = io.ReadAll(encryptionCertificate)
-	if err != nil {
-		log.Fatal().Err(err).Msg("unable to read certificate file")
-	}
-	privateKeyBlock, _ = pem.Decode(certificateContents)
-	if privateKeyBlock.Type != "EC PRIVATE KEY" {
-		log.Fatal().Msg("unsupported private key type")
+// configureUpstreamAuthoritativeFields reads the space-separated list of
+// profile fields managed by the external provider from the
+// `UPSTREAM_AUTHORITATIVE_FIELDS` environment variable to narrow the default
+// of every profile field. Fields which are not listed may be changed locally
+// and are not synchronized
+func configureUpstreamAuthoritativeFields() {
+	rawFields, isSet := os.LookupEnv("UPSTREAM_AUTHORITATIVE_FIELDS")
+	if !isSet {
+		return
 	}
 
-	ecdsaPrivateKey, err = x509.ParseECPrivateKey(privateKeyBlock.Bytes)
-	if err != nil {
-		log.Fatal().Err(err).Msg("unable to parse ECDSA private key")
+	fields := strings.Fields(rawFields)
+	for _, field := range fields {
+		if !slices.Contains(utils.UpstreamProfileFields, field) {
+			log.Fatal().Str("field", field).Msg("unsupported upstream authoritative field")
+		}
 	}
+	utils.UpstreamAuthoritativeFields = fields
+}
 
-	privateKey, err = jwk.FromRaw(ecdsaPrivateKey)
+// loadKeys loads the key generations used to sign and encrypt tokens from the
+// database.
+// Keys stored locally by previous versions are imported during the first
+// startup to keep already issued tokens valid
+func loadKeys() {
+	keyEncryptionKey, err := readKeyEncryptionKey()
 	if err != nil {
-		log.Fatal().Err(err).Msg("unable to create jwk private key")
+		log.Fatal().Err(err).Msg("unable to read key encryption key")
 	}
 
-	err = jwk.AssignKeyID(privateKey)
-	if err != nil {
-		log.Fatal().Err(err).Msg("unable to set private key key id")
+	store := &keys.PostgresStore{
+		KeyEncryptionKey: keyEncryptionKey,
+		Legacy: &keys.FileStore{
+			Path:                    config.KeyGenerationsFilePath,
+			LegacySigningKeyPath:    config.SigningCertificateFilePath,
+			LegacyEncryptionKeyPath: config.EncryptionCertificateFilePath,
+		},
 	}
-	err = privateKey.Set(jwk.KeyUsageKey, "enc")
-	if err != nil {
-		log.Fatal().Err(err).Msg("unable to set private key usage type")
-	}
-	err = privateKey.Set(jwk.AlgorithmKey, jwa.ES256)
+
+	err = keys.Init(context.Background(), store)
 	if err != nil {
-		log.Fatal().Err(err).Msg("unable to set private key algorithm")
+		log.Fatal().Err(err).Msg("unable to load keys")
 	}
 
-	publicKey, err = jwk.PublicKeyOf(privateKey)
-	if err != nil {
-		log.Fatal().Err(err).Msg("unable to parse public key")
+	log.Info().Msg("loaded keys")
+}
+
+// readKeyEncryptionKey reads the key used to encrypt the keys stored in the
+// database from the `KEY_ENCRYPTION_KEY` environment variable or the file
+// set in `KEY_ENCRYPTION_KEY_FILE`
+func readKeyEncryptionKey() ([]byte, error) {
+	if rawKey, isSet := os.LookupEnv("KEY_ENCRYPTION_KEY"); isSet {
+		return keys.ParseKeyEncryptionKey(rawKey)
 	}
 
-	resources.PublicEncryptionKey = publicKey
-	resources.PrivateEncryptionKey = privateKey
+	path, isSet := os.LookupEnv("KEY_ENCRYPTION_KEY_FILE")
+	if !isSet {
+		return nil, errors.New("neither KEY_ENCRYPTION_KEY nor KEY_ENCRYPTION_KEY_FILE is set")
+	}
 
-	resources.KeySet = jwk.NewSet()
-	err = resources.KeySet.AddKey(resources.PublicEncryptionKey)
+	rawKey, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatal().Err(err).Msg("unable to add public encryption key to key set")
+		return nil, err
 	}
-	err = resources.KeySet.AddKey(resources.PublicSigningKey)
-	if err != nil {
-		log.Fatal().Err(err).Msg("unable to add public signing key to key set")
+	return keys.ParseKeyEncryptionKey(string(rawKey))
+}
+
+// configureKeyRotation reads the interval in which the keys are rotated from
+// the `KEY_ROTATION_INTERVAL` environment variable
+func configureKeyRotation() {
+	rawInterval, isSet := os.LookupEnv("KEY_ROTATION_INTERVAL")
+	if !isSet {
+		return
 	}
 
-	log.Info().Msg("loaded certificates")
+	interval, err := time.ParseDuration(rawInterval)
+	if err != nil || interval <= 0 {
+		log.Fatal().Err(err).Msg("unable to parse key rotation interval")
+	}
+	keyRotationInterval = interval
 }
@@ -1,8 +1,11 @@
 package interfaces
 
+import "context"
+
 type PermissionableObject interface {
 	GetID() string
-	Permissions() map[string][]string
+	// Permissions returns the permissions of the object grouped by service
+	Permissions(ctx context.Context) (map[string][]string, error)
 	IsAdministrator() bool
 	IsActive() bool
 }
@@ -0,0 +1,222 @@
+// Package cache stores users and their resolved permissions in Redis to
+// reduce the load on the database while issuing tokens.
+// Entries are invalidated by every instance as soon as the database
+// announces a change and expire after the TTL as a safety net for missed
+// announcements.
+// Every invalidation increments the version of the entry, which is read
+// before the value is loaded from the database. Values loaded before an
+// invalidation are not written to the cache, as they may already be outdated
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+
+	"microservice/internal/db"
+)
+
+// TTL limits how long an entry is cached
+const TTL = 5 * time.Minute
+
+// KeyPrefix is prepended to every key used by the cache
+const KeyPrefix = "ums-cache:"
+
+// VersionKeyPrefix is prepended to the keys storing the versions of the
+// cache entries. They don't use the KeyPrefix to survive flushing the cache
+const VersionKeyPrefix = "ums-cache-version:"
+
+// epochKey stores the version of the whole cache, which is incremented every
+// time the cache is flushed
+const epochKey = VersionKeyPrefix + "epoch"
+
+// versionTTL limits how long the version of an entry is kept after its last
+// invalidation. It only needs to outlast loading a value from the database
+const versionTTL = TTL
+
+// versionKey returns the key storing the version of the cache entry
+func versionKey(key string) string {
+	return VersionKeyPrefix + key
+}
+
+// UserKey returns the key under which the user with the internal id is
+// cached
+func UserKey(id string) string {
+	return KeyPrefix + "user:" + id
+}
+
+// ExternalUserKey returns the key under which the internal id of the user
+// with the external identifier is cached
+func ExternalUserKey(externalIdentifier string) string {
+	return KeyPrefix + "external-user:" + externalIdentifier
+}
+
+// PermissionsKey returns the key under which the permissions of the user are
+// cached
+func PermissionsKey(userID string) string {
+	return KeyPrefix + "permissions:" + userID
+}
+
+// AdministratorPermissionsKey is the key under which the permissions of
+// administrators are cached. They contain every scope of every service
+const AdministratorPermissionsKey = KeyPrefix + "administrator-permissions"
+
+// Get reads the cached value into the target.
+// Errors are logged and reported as cache miss to fall back to the database
+func Get(ctx context.Context, key string, target any) bool {
+	value, err := db.Redis.Get(ctx, key).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Warn().Err(err).Str("key", key).Msg("unable to read cache entry")
+		}
+		return false
+	}
+
+	err = json.Unmarshal(value, target)
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("unable to parse cache entry")
+		return false
+	}
+	return true
+}
+
+// GetMany reads the cached values of multiple keys in a single round trip.
+// The returned map only contains the keys found in the cache
+func GetMany(ctx context.Context, keys []string) map[string][]byte {
+	entries := make(map[string][]byte)
+	if len(keys) == 0 {
+		return entries
+	}
+
+	values, err := db.Redis.MGet(ctx, keys...).Result()
+	if err != nil {
+		log.Warn().Err(err).Msg("unable to read cache entries")
+		return entries
+	}
+
+	for i, value := range values {
+		if value, ok := value.(string); ok {
+			entries[keys[i]] = []byte(value)
+		}
+	}
+	return entries
+}
+
+// Version identifies the state of a cache entry before its value has been
+// loaded from the database
+type Version struct {
+	key     string
+	epoch   string
+	counter string
+	valid   bool
+}
+
+// Versions reads the versions of the entries. They need to be read before
+// loading the values from the database to detect invalidations happening
+// while the values are loaded.
+// Errors are logged and result in versions which never allow writing the
+// entry
+func Versions(ctx context.Context, keys ...string) []Version {
+	versions := make([]Version, len(keys))
+	if len(keys) == 0 {
+		return versions
+	}
+
+	versionKeys := []string{epochKey}
+	for _, key := range keys {
+		versionKeys = append(versionKeys, versionKey(key))
+	}
+
+	values, err := db.Redis.MGet(ctx, versionKeys...).Result()
+	if err != nil {
+		log.Warn().Err(err).Msg("unable to read cache entry versions")
+		return versions
+	}
+
+	epoch, _ := values[0].(string)
+	for i, key := range keys {
+		counter, _ := values[i+1].(string)
+		versions[i] = Version{key: key, epoch: epoch, counter: counter, valid: true}
+	}
+	return versions
+}
+
+// ReadVersion reads the version of a single entry
+func ReadVersion(ctx context.Context, key string) Version {
+	return Versions(ctx, key)[0]
+}
+
+// setIfUnchanged writes the entry only if neither the entry nor the whole
+// cache has been invalidated since the versions have been read
+var setIfUnchanged = redis.NewScript(`
+if (redis.call("GET", KEYS[2]) or "") ~= ARGV[1] or (redis.call("GET", KEYS[3]) or "") ~= ARGV[2] then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[3], "PX", ARGV[4])
+return 1
+`)
+
+// Set caches the value for the TTL if the entry has not been invalidated
+// since its version has been read.
+// Errors are logged as the value can still be read from the database
+func Set(ctx context.Context, version Version, value any) {
+	if !version.valid {
+		return
+	}
+
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		log.Warn().Err(err).Str("key", version.key).Msg("unable to encode cache entry")
+		return
+	}
+
+	keys := []string{version.key, epochKey, versionKey(version.key)}
+	err = setIfUnchanged.Run(ctx, db.Redis, keys, version.epoch, version.counter, encodedValue, TTL.Milliseconds()).Err()
+	if err != nil {
+		log.Warn().Err(err).Str("key", version.key).Msg("unable to write cache entry")
+	}
+}
+
+// Delete removes the entries from the cache and increments their versions to
+// prevent values loaded before the removal from being written afterwards
+func Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err := db.Redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Incr(ctx, versionKey(key))
+			pipe.Expire(ctx, versionKey(key), versionTTL)
+		}
+		pipe.Del(ctx, keys...)
+		return nil
+	})
+	return err
+}
+
+// Flush removes every entry of the cache and increments the version of the
+// whole cache to prevent values loaded before from being written afterwards
+func Flush(ctx context.Context) error {
+	err := db.Redis.Incr(ctx, epochKey).Err()
+	if err != nil {
+		return err
+	}
+
+	iterator := db.Redis.Scan(ctx, 0, KeyPrefix+"*", 100).Iterator()
+	var keys []string
+	for iterator.Next(ctx) {
+		keys = append(keys, iterator.Val())
+	}
+	if err := iterator.Err(); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return db.Redis.Del(ctx, keys...).Err()
+}
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+
+	"microservice/internal/db"
+)
+
+// Channel is the channel on which the database announces changes to users,
+// permissions and services
+const Channel = "user_management_cache"
+
+// reconnectDelay sets how long the listener waits before reconnecting after
+// losing the connection to the database
+const reconnectDelay = 5 * time.Second
+
+// invalidation is the payload of a change announced by the database
+type invalidation struct {
+	Table               string   `json:"table"`
+	ID                  string   `json:"id"`
+	ExternalIdentifiers []string `json:"externalIdentifiers"`
+}
+
+// keys returns the cache entries affected by the change
+func (i invalidation) keys() []string {
+	switch i.Table {
+	case "users":
+		keys := []string{UserKey(i.ID), PermissionsKey(i.ID)}
+		for _, externalIdentifier := range i.ExternalIdentifiers {
+			keys = append(keys, ExternalUserKey(externalIdentifier))
+		}
+		return keys
+	case "permission_assignments":
+		return []string{PermissionsKey(i.ID)}
+	case "services":
+		return []string{AdministratorPermissionsKey}
+	default:
+		return nil
+	}
+}
+
+// Listen removes cache entries as soon as the database announces a change.
+// As changes may have been missed while the connection was lost, the cache
+// is flushed after every (re)connect.
+// It blocks until the context is canceled
+func Listen(ctx context.Context) {
+	for {
+		err := listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warn().Err(err).Msg("lost connection while listening for cache invalidations")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+func listen(ctx context.Context) error {
+	// a dedicated connection is used to not withhold a connection from the
+	// pool for the lifetime of the service
+	connection, err := pgx.ConnectConfig(ctx, db.Pool.Config().ConnConfig)
+	if err != nil {
+		return err
+	}
+	defer connection.Close(context.Background())
+
+	_, err = connection.Exec(ctx, "LISTEN "+Channel)
+	if err != nil {
+		return err
+	}
+
+	err = Flush(ctx)
+	if err != nil {
+		return err
+	}
+
+	for {
+		notification, err := connection.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var change invalidation
+		err = json.Unmarshal([]byte(notification.Payload), &change)
+		if err != nil {
+			log.Warn().Err(err).Msg("unable to parse cache invalidation")
+			continue
+		}
+
+		err = Delete(ctx, change.keys()...)
+		if err != nil {
+			log.Warn().Err(err).Str("table", change.Table).Msg("unable to invalidate cache entries")
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestInvalidationKeys(t *testing.T) {
+	tests := map[string]struct {
+		payload string
+		keys    []string
+	}{
+		"user": {
+			payload: `{"table":"users","id":"user","externalIdentifiers":["old","new"]}`,
+			keys:    []string{UserKey("user"), PermissionsKey("user"), ExternalUserKey("old"), ExternalUserKey("new")},
+		},
+		"user without external identifiers": {
+			payload: `{"table":"users","id":"user","externalIdentifiers":null}`,
+			keys:    []string{UserKey("user"), PermissionsKey("user")},
+		},
+		"permission assignment": {
+			payload: `{"table":"permission_assignments","id":"user"}`,
+			keys:    []string{PermissionsKey("user")},
+		},
+		"service": {
+			payload: `{"table":"services","id":"service"}`,
+			keys:    []string{AdministratorPermissionsKey},
+		},
+		"unknown table": {
+			payload: `{"table":"clients","id":"client"}`,
+			keys:    nil,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var change invalidation
+			err := json.Unmarshal([]byte(test.payload), &change)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keys := change.keys(); !slices.Equal(keys, test.keys) {
+				t.Errorf("expected %v, got %v", test.keys, keys)
+			}
+		})
+	}
+}
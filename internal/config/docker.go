@@ -16,6 +16,7 @@ import (
 	"github.com/wisdom-oss/common-go/v2/middleware"
 
 	apiErrors "microservice/internal/errors"
+	"microservice/types"
 
 	"github.com/gin-contrib/logger"
 	"github.com/gin-contrib/requestid"
@@ -31,6 +32,7 @@ func init() {
 // Middlewares configures and outputs the middlewares used in the configuration.
 // The contained middlewares are the following:
 //   - gin.Logger
+//   - types.PermissionLoaderMiddleware
 func Middlewares() []gin.HandlerFunc {
 	var middlewares []gin.HandlerFunc
 
@@ -44,6 +46,7 @@ func Middlewares() []gin.HandlerFunc {
 	middlewares = append(middlewares, requestid.New())
 	middlewares = append(middlewares, middleware.ErrorHandler{}.Gin)
 	middlewares = append(middlewares, gin.CustomRecovery(middleware.RecoveryHandler))
+	middlewares = append(middlewares, types.PermissionLoaderMiddleware)
 
 	return middlewares
 }
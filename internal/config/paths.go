@@ -2,3 +2,4 @@ package config
 
 const SigningCertificateFilePath = `./.certs/signing.pem`
 const EncryptionCertificateFilePath = `./.certs/encryption.pem`
+const KeyGenerationsFilePath = `./.certs/keys.json`
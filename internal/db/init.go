@@ -4,6 +4,7 @@ import (
 	"context"
 	"io/fs"
 	"os"
+	"testing"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/qustavo/dotsql"
@@ -15,19 +16,6 @@ import (
 
 func init() {
 	l := log.With().Str("package", "internal/db").Logger()
-	l.Debug().Msg("connecting to the database")
-
-	var err error
-	Pool, err = pgxpool.New(context.Background(), "")
-	if err != nil {
-		l.Fatal().Err(err).Msg("could not connect to database")
-	}
-	err = Pool.Ping(context.Background())
-	if err != nil {
-		l.Fatal().Err(err).Msg("could not ping database")
-	}
-	l.Debug().Msg("connected to the database")
-
 	l.Debug().Msg("loading prepared sql queries")
 	files, err := fs.ReadDir(resources.QueryFiles, ".")
 	if err != nil {
@@ -50,6 +38,29 @@ func init() {
 		log.Fatal().Err(err).Msg("failed to load prepared queries")
 	}
 
+	// tests only use the prepared queries and don't require a database
+	if testing.Testing() {
+		return
+	}
+
+	l.Debug().Msg("connecting to the database")
+
+	Pool, err = pgxpool.New(context.Background(), "")
+	if err != nil {
+		l.Fatal().Err(err).Msg("could not connect to database")
+	}
+	err = Pool.Ping(context.Background())
+	if err != nil {
+		l.Fatal().Err(err).Msg("could not ping database")
+	}
+	l.Debug().Msg("connected to the database")
+
+	l.Debug().Msg("applying database migrations")
+	err = migrate(context.Background())
+	if err != nil {
+		l.Fatal().Err(err).Msg("could not apply database migrations")
+	}
+
 	redisUri, isSet := os.LookupEnv("REDIS_URI")
 	if !isSet {
 		l.Fatal().Msg("REDIS_URI is not set")
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+
+	"microservice/resources"
+)
+
+// migrationLockKey is used as the key for the advisory lock which prevents
+// multiple instances from applying the migrations at the same time
+const migrationLockKey = "user-management-migrations"
+
+// migrate applies the schema changes contained in the migrations folder of
+// the resources in a single transaction
+func migrate(ctx context.Context) error {
+	files, err := fs.Glob(resources.MigrationFiles, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+
+	tx, err := Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", migrationLockKey)
+	if err != nil {
+		return err
+	}
+
+	// fs.Glob returns the files in lexical order
+	for _, file := range files {
+		migration, err := fs.ReadFile(resources.MigrationFiles, file)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, string(migration))
+		if err != nil {
+			return fmt.Errorf("unable to apply migration %s: %w", path.Base(file), err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
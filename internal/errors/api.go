@@ -78,3 +78,213 @@ var ErrInvalidClientID = types.ServiceError{
 	Title:  "Invalid Client ID Format",
 	Detail: "Invalid Client ID provided. Please ensure you used an UUIDv4",
 }
+
+var ErrUnsupportedTokenType = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc9110#section-15.5.1",
+	Status: 400,
+	Title:  "Unsupported Token Type",
+	Detail: "The token type supplied or requested in the token exchange is not supported",
+}
+
+var ErrInvalidSubjectToken = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc9110#section-15.5.1",
+	Status: 400,
+	Title:  "Invalid Subject Token",
+	Detail: "The subject token supplied in the token exchange is invalid, expired or has not been issued by this service",
+}
+
+var ErrInvalidTarget = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc9110#section-15.5.1",
+	Status: 400,
+	Title:  "Invalid Target",
+	Detail: "At least one of the requested audiences is unknown",
+}
+
+var ErrTokenNotIssuedToClient = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc7009#section-2.1",
+	Status: 400,
+	Title:  "Token Not Issued To Client",
+	Detail: "The token has not been issued to the client requesting the revocation",
+}
+
+var ErrAuthorizationPending = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc8628#section-3.5",
+	Status: 400,
+	Title:  "Authorization Pending",
+	Detail: "The device authorization request has not been approved yet. Please continue polling",
+}
+
+var ErrSlowDown = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc8628#section-3.5",
+	Status: 400,
+	Title:  "Slow Down",
+	Detail: "The device is polling too fast. The polling interval has been increased by five seconds",
+}
+
+var ErrExpiredDeviceCode = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc8628#section-3.5",
+	Status: 400,
+	Title:  "Expired Device Code",
+	Detail: "The device code is unknown or has expired. Please start a new device authorization request",
+}
+
+var ErrDeviceAccessDenied = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc8628#section-3.5",
+	Status: 400,
+	Title:  "Access Denied",
+	Detail: "The user denied the device authorization request",
+}
+
+var ErrInvalidDPoPProof = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc9449#section-5",
+	Status: 400,
+	Title:  "Invalid DPoP Proof",
+	Detail: "The DPoP proof supplied with the request is invalid",
+}
+
+var ErrDPoPKeyMismatch = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc9449#section-5",
+	Status: 400,
+	Title:  "DPoP Key Mismatch",
+	Detail: "The refresh token is bound to a DPoP key. Please supply a DPoP proof signed with the bound key",
+}
+
+var ErrInvalidClientKeys = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc9110#section-15.5.1",
+	Status: 400,
+	Title:  "Invalid Client Keys",
+	Detail: "The supplied key set is not valid. Only public keys may be registered and key set urls must use https",
+}
+
+var ErrUnsupportedSigningAlgorithm = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc9110#section-15.5.1",
+	Status: 400,
+	Title:  "Unsupported Signing Algorithm",
+	Detail: "The requested signing algorithm is not enabled. Please check the discovery document for the supported algorithms",
+}
+
+var ErrMultipleClientAuthMethods = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc6749#section-2.3",
+	Status: 400,
+	Title:  "Multiple Client Authentication Methods",
+	Detail: "The client used more than one authentication method in the request. Only one method may be used at once",
+}
+
+var ErrUnsupportedGrantType = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc6749#section-5.2",
+	Status: 400,
+	Title:  "Unsupported Grant Type",
+	Detail: "The requested grant type is not supported by this service",
+}
+
+var ErrInvalidGrant = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc6749#section-5.2",
+	Status: 400,
+	Title:  "Invalid Grant",
+	Detail: "The supplied authorization grant is invalid, expired or has been revoked",
+}
+
+var ErrInvalidUserPatch = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc7396",
+	Status: 400,
+	Title:  "Invalid User Patch",
+	Detail: "The patch may only contain the name, username, email, disabled and administrator fields, none of which may be null",
+}
+
+var ErrUpstreamManagedField = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc9110#section-15.5.10",
+	Status: 409,
+	Title:  "Field Managed Upstream",
+	Detail: "The field is managed by the OpenID Connect provider and is overwritten on the next login. Change it at the provider instead",
+}
+
+var ErrLastAdministrator = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc9110#section-15.5.10",
+	Status: 409,
+	Title:  "Last Administrator",
+	Detail: "The last active administrator can neither be demoted nor disabled",
+}
+
+var ErrUserConflict = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc9110#section-15.5.10",
+	Status: 409,
+	Title:  "User Conflict",
+	Detail: "Another user already uses the supplied username or email",
+}
+
+var ErrInvalidListParameters = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc9110#section-15.5.1",
+	Status: 400,
+	Title:  "Invalid List Parameters",
+	Detail: "The filters, sort order or cursor supplied for the list are not valid",
+}
+
+var ErrUnknownSession = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc9110#section-15.5.5",
+	Status: 404,
+	Title:  "Unknown Session",
+	Detail: "The session does not exist or has already been ended",
+}
+
+var ErrInvalidPostLogoutRedirectURI = types.ServiceError{
+	Type:   "https://openid.net/specs/openid-connect-rpinitiated-1_0.html#RPLogout",
+	Status: 400,
+	Title:  "Invalid Post Logout Redirect URI",
+	Detail: "The supplied post logout redirect uri has not been registered",
+}
+
+var ErrInvalidIDTokenHint = types.ServiceError{
+	Type:   "https://openid.net/specs/openid-connect-rpinitiated-1_0.html#RPLogout",
+	Status: 400,
+	Title:  "Invalid ID Token Hint",
+	Detail: "The supplied ID token hint has not been issued by this service",
+}
+
+var ErrInvalidLogoutToken = types.ServiceError{
+	Type:   "https://openid.net/specs/openid-connect-backchannel-1_0.html#BCResponse",
+	Status: 400,
+	Title:  "Invalid Logout Token",
+	Detail: "The supplied logout token is invalid or has already been used",
+}
+
+var ErrInvalidInvitation = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc9110#section-15.5.1",
+	Status: 400,
+	Title:  "Invalid Invitation",
+	Detail: "The invitation needs an email address or external identifier, at least one permission and an expiry date in the future",
+}
+
+var ErrUserExists = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc9110#section-15.5.10",
+	Status: 409,
+	Title:  "User Already Exists",
+	Detail: "A user with the email address or external identifier already exists. Assign the permissions to the user instead",
+}
+
+var ErrUnknownInvitation = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc9110#section-15.5.5",
+	Status: 404,
+	Title:  "Unknown Invitation",
+	Detail: "The invitation does not exist, has already been accepted or has expired",
+}
+
+var ErrUnknownClient = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc6749#section-4.1.2.1",
+	Status: 400,
+	Title:  "Unknown Client",
+	Detail: "The login has been initiated by a client which has not been registered",
+}
+
+var ErrInvalidRedirectURI = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc6749#section-4.1.2.1",
+	Status: 400,
+	Title:  "Invalid Redirect URI",
+	Detail: "The supplied redirect uri has not been registered for the client",
+}
+
+var ErrInvalidClientRedirectURIs = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc9110#section-15.5.1",
+	Status: 400,
+	Title:  "Invalid Redirect URIs",
+	Detail: "The redirect uris need to be absolute uris without a fragment",
+}
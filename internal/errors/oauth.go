@@ -0,0 +1,93 @@
+package errors
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wisdom-oss/common-go/v2/types"
+)
+
+// OAuthError is the error response sent by the OAuth 2.0 endpoints as
+// defined in RFC 6749, Section 5.2.
+// The management API keeps using the problem details sent by ServiceError
+type OAuthError struct {
+	Status      int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// oauthErrorCodes maps the service errors used by the OAuth 2.0 endpoints to
+// the error codes defined by the OAuth 2.0 specifications
+var oauthErrorCodes = []struct {
+	serviceError types.ServiceError
+	code         string
+}{
+	{ErrMissingParameter, "invalid_request"},
+	{ErrMultipleClientAuthMethods, "invalid_request"},
+	{ErrUnsupportedTokenType, "invalid_request"},
+	{ErrInvalidSubjectToken, "invalid_request"},
+	{ErrInvalidLogoutToken, "invalid_request"},
+	{ErrInvalidClientCredentials, "invalid_client"},
+	{ErrInvalidScope, "invalid_scope"},
+	{ErrInvalidTarget, "invalid_target"},
+	{ErrUnsupportedGrantType, "unsupported_grant_type"},
+	{ErrInvalidGrant, "invalid_grant"},
+	{ErrTokenNotIssuedToClient, "unauthorized_client"},
+	{ErrRefreshTokenInvalid, "invalid_grant"},
+	{ErrUserDisabled, "invalid_grant"},
+	{ErrUnknownUser, "invalid_grant"},
+	{ErrAuthorizationPending, "authorization_pending"},
+	{ErrSlowDown, "slow_down"},
+	{ErrExpiredDeviceCode, "expired_token"},
+	{ErrDeviceAccessDenied, "access_denied"},
+	{ErrInvalidDPoPProof, "invalid_dpop_proof"},
+	{ErrDPoPKeyMismatch, "invalid_dpop_proof"},
+}
+
+// OAuthErrorFrom converts the service error into the matching OAuth 2.0
+// error. Service errors without a matching error code are sent as invalid
+// request
+func OAuthErrorFrom(serviceError types.ServiceError) OAuthError {
+	oauthError := OAuthError{
+		Status:      http.StatusBadRequest,
+		Code:        "invalid_request",
+		Description: serviceError.Detail,
+	}
+
+	for _, mapping := range oauthErrorCodes {
+		if serviceError.Equals(mapping.serviceError) {
+			oauthError.Code = mapping.code
+			break
+		}
+	}
+
+	if oauthError.Code == "invalid_client" {
+		oauthError.Status = http.StatusUnauthorized
+	}
+
+	if len(serviceError.Errors) > 0 {
+		errorMessages := make([]string, 0, len(serviceError.Errors))
+		for _, err := range serviceError.Errors {
+			errorMessages = append(errorMessages, err.Error())
+		}
+		oauthError.Description += ": " + strings.Join(errorMessages, "; ")
+	}
+	return oauthError
+}
+
+// EmitOAuth sends the service error as OAuth 2.0 error response
+func EmitOAuth(c *gin.Context, serviceError types.ServiceError) {
+	OAuthErrorFrom(serviceError).Emit(c)
+}
+
+// Emit sends the error response. Failed client authentications are
+// answered with a WWW-Authenticate header as required by RFC 6749
+func (e OAuthError) Emit(c *gin.Context) {
+	if e.Code == "invalid_client" {
+		c.Header("WWW-Authenticate", `Basic realm="user-management"`)
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	c.JSON(e.Status, e)
+}
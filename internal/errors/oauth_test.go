@@ -0,0 +1,52 @@
+package errors
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/wisdom-oss/common-go/v2/types"
+)
+
+func TestOAuthErrorFrom(t *testing.T) {
+	tests := map[string]struct {
+		serviceError types.ServiceError
+		status       int
+		code         string
+	}{
+		"missing parameter":     {ErrMissingParameter, http.StatusBadRequest, "invalid_request"},
+		"client credentials":    {ErrInvalidClientCredentials, http.StatusUnauthorized, "invalid_client"},
+		"invalid scope":         {ErrInvalidScope, http.StatusBadRequest, "invalid_scope"},
+		"refresh token":         {ErrRefreshTokenInvalid, http.StatusBadRequest, "invalid_grant"},
+		"foreign token":         {ErrTokenNotIssuedToClient, http.StatusBadRequest, "unauthorized_client"},
+		"authorization pending": {ErrAuthorizationPending, http.StatusBadRequest, "authorization_pending"},
+		"dpop key mismatch":     {ErrDPoPKeyMismatch, http.StatusBadRequest, "invalid_dpop_proof"},
+		"unmapped error":        {ErrUnknownClient, http.StatusBadRequest, "invalid_request"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			oauthError := OAuthErrorFrom(test.serviceError)
+			if oauthError.Status != test.status {
+				t.Errorf("expected status %d, got %d", test.status, oauthError.Status)
+			}
+			if oauthError.Code != test.code {
+				t.Errorf("expected code %q, got %q", test.code, oauthError.Code)
+			}
+			if oauthError.Description != test.serviceError.Detail {
+				t.Errorf("expected description %q, got %q", test.serviceError.Detail, oauthError.Description)
+			}
+		})
+	}
+}
+
+func TestOAuthErrorFromAppendsErrors(t *testing.T) {
+	serviceError := ErrInvalidGrant
+	serviceError.Errors = []error{errors.New("first"), errors.New("second")}
+
+	oauthError := OAuthErrorFrom(serviceError)
+	expected := ErrInvalidGrant.Detail + ": first; second"
+	if oauthError.Description != expected {
+		t.Errorf("expected description %q, got %q", expected, oauthError.Description)
+	}
+}
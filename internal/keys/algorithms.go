@@ -0,0 +1,61 @@
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+)
+
+// rsaKeySize is the size of the generated RSA keys in bits
+const rsaKeySize = 3072
+
+var errUnsupportedSigningAlgorithm = errors.New("unsupported signing algorithm")
+var errNoSigningAlgorithm = errors.New("no signing algorithm configured")
+
+// SupportedSigningAlgorithms contains the signature algorithms keys can be
+// generated for
+var SupportedSigningAlgorithms = []jwa.SignatureAlgorithm{
+	jwa.ES256, jwa.RS256, jwa.PS256, jwa.EdDSA,
+}
+
+// SigningAlgorithms contains the signature algorithms every generation
+// contains a signing key for.
+// The first algorithm is used for tokens if no other algorithm has been
+// requested
+var SigningAlgorithms = []jwa.SignatureAlgorithm{jwa.ES256}
+
+// ParseSigningAlgorithms reads a space-separated list of signature
+// algorithms
+func ParseSigningAlgorithms(rawAlgorithms string) ([]jwa.SignatureAlgorithm, error) {
+	var algorithms []jwa.SignatureAlgorithm
+	for _, rawAlgorithm := range strings.Fields(rawAlgorithms) {
+		algorithm, err := ParseSigningAlgorithm(rawAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(algorithms, algorithm) {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+	if len(algorithms) == 0 {
+		return nil, errNoSigningAlgorithm
+	}
+	return algorithms, nil
+}
+
+// ParseSigningAlgorithm checks if the algorithm is supported
+func ParseSigningAlgorithm(rawAlgorithm string) (jwa.SignatureAlgorithm, error) {
+	algorithm := jwa.SignatureAlgorithm(rawAlgorithm)
+	if !slices.Contains(SupportedSigningAlgorithms, algorithm) {
+		return "", fmt.Errorf("%w: %s", errUnsupportedSigningAlgorithm, rawAlgorithm)
+	}
+	return algorithm, nil
+}
+
+// IsSigningAlgorithmEnabled checks if the algorithm has been configured
+func IsSigningAlgorithmEnabled(algorithm jwa.SignatureAlgorithm) bool {
+	return slices.Contains(SigningAlgorithms, algorithm)
+}
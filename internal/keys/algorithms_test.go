@@ -0,0 +1,89 @@
+package keys
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+)
+
+func TestParseSigningAlgorithms(t *testing.T) {
+	tests := map[string]struct {
+		rawAlgorithms string
+		algorithms    []jwa.SignatureAlgorithm
+	}{
+		"single algorithm":     {"ES256", []jwa.SignatureAlgorithm{jwa.ES256}},
+		"multiple algorithms":  {"RS256 ES256 EdDSA", []jwa.SignatureAlgorithm{jwa.RS256, jwa.ES256, jwa.EdDSA}},
+		"duplicate algorithms": {"PS256 ES256 PS256", []jwa.SignatureAlgorithm{jwa.PS256, jwa.ES256}},
+		"surrounding spaces":   {"  ES256\tRS256 \n", []jwa.SignatureAlgorithm{jwa.ES256, jwa.RS256}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			algorithms, err := ParseSigningAlgorithms(test.rawAlgorithms)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(algorithms, test.algorithms) {
+				t.Errorf("expected %v, got %v", test.algorithms, algorithms)
+			}
+		})
+	}
+}
+
+func TestParseSigningAlgorithmsRejectsInvalidAlgorithms(t *testing.T) {
+	tests := map[string]struct {
+		rawAlgorithms string
+		err           error
+	}{
+		"empty list":            {"", errNoSigningAlgorithm},
+		"only spaces":           {"   ", errNoSigningAlgorithm},
+		"unsupported algorithm": {"ES256 HS256", errUnsupportedSigningAlgorithm},
+		"none algorithm":        {"none", errUnsupportedSigningAlgorithm},
+		"lowercase algorithm":   {"es256", errUnsupportedSigningAlgorithm},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseSigningAlgorithms(test.rawAlgorithms)
+			if !errors.Is(err, test.err) {
+				t.Errorf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestAddMissingSigningKeys(t *testing.T) {
+	previousAlgorithms := SigningAlgorithms
+	t.Cleanup(func() { SigningAlgorithms = previousAlgorithms })
+
+	SigningAlgorithms = []jwa.SignatureAlgorithm{jwa.ES256}
+	generation, err := NewGeneration(StatusNext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existingKey := generation.SigningKey(jwa.ES256)
+	if existingKey == nil {
+		t.Fatal("generation is missing the signing key")
+	}
+	if generation.SigningKey(jwa.EdDSA) != nil {
+		t.Fatal("generation contains a signing key for a disabled algorithm")
+	}
+
+	SigningAlgorithms = []jwa.SignatureAlgorithm{jwa.ES256, jwa.EdDSA}
+	err = generation.addMissingSigningKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(generation.SigningKeys) != 2 {
+		t.Fatalf("expected 2 signing keys, got %d", len(generation.SigningKeys))
+	}
+	if generation.SigningKey(jwa.ES256) != existingKey {
+		t.Error("existing signing key has been replaced")
+	}
+	if generation.SigningKey(jwa.EdDSA) == nil {
+		t.Error("missing signing key has not been generated")
+	}
+}
@@ -0,0 +1,249 @@
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// Status describes the state of a key generation
+type Status string
+
+const (
+	// StatusNext marks the generation which is activated by the next
+	// rotation. Its public keys are already published to allow other services
+	// to learn them before they are used
+	StatusNext Status = "next"
+
+	// StatusActive marks the generation used to sign and encrypt new tokens
+	StatusActive Status = "active"
+
+	// StatusRetired marks generations which have been replaced. They are still
+	// used to verify and decrypt tokens issued before the rotation
+	StatusRetired Status = "retired"
+)
+
+// Generation contains the signing keys and an encryption key which are
+// rotated together.
+// A signing key is created for every configured signing algorithm
+type Generation struct {
+	ID          string
+	Status      Status
+	CreatedAt   time.Time
+	ActivatedAt time.Time
+	RetiredAt   time.Time
+
+	// SigningKeys and EncryptionKey contain the private keys of the
+	// generation
+	SigningKeys   []jwk.Key
+	EncryptionKey jwk.Key
+}
+
+// NewGeneration creates a generation with newly generated keys for the
+// configured signing algorithms
+func NewGeneration(status Status) (*Generation, error) {
+	var signingKeys []jwk.Key
+	for _, algorithm := range SigningAlgorithms {
+		signingKey, err := generateSigningKey(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		signingKeys = append(signingKeys, signingKey)
+	}
+
+	rawEncryptionKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptionKey, err := prepareKey(rawEncryptionKey, jwk.ForEncryption, jwa.ECDH_ES)
+	if err != nil {
+		return nil, err
+	}
+
+	return newGeneration(status, signingKeys, encryptionKey), nil
+}
+
+// newGeneration creates a generation containing the supplied keys
+func newGeneration(status Status, signingKeys []jwk.Key, encryptionKey jwk.Key) *Generation {
+	generation := &Generation{
+		ID:            uuid.NewString(),
+		Status:        status,
+		CreatedAt:     time.Now(),
+		SigningKeys:   signingKeys,
+		EncryptionKey: encryptionKey,
+	}
+	if status == StatusActive {
+		generation.ActivatedAt = generation.CreatedAt
+	}
+	return generation
+}
+
+// SigningKey returns the signing key of the generation used for the
+// algorithm. If the generation contains no key for the algorithm, nil is
+// returned
+func (g *Generation) SigningKey(algorithm jwa.SignatureAlgorithm) jwk.Key {
+	for _, key := range g.SigningKeys {
+		if key.Algorithm() == algorithm {
+			return key
+		}
+	}
+	return nil
+}
+
+// addMissingSigningKeys creates signing keys for the configured algorithms
+// the generation has no key for
+func (g *Generation) addMissingSigningKeys() error {
+	for _, algorithm := range SigningAlgorithms {
+		if g.SigningKey(algorithm) != nil {
+			continue
+		}
+
+		signingKey, err := generateSigningKey(algorithm)
+		if err != nil {
+			return err
+		}
+		g.SigningKeys = append(g.SigningKeys, signingKey)
+	}
+	return nil
+}
+
+// generateSigningKey generates a new private key for the signature algorithm
+func generateSigningKey(algorithm jwa.SignatureAlgorithm) (jwk.Key, error) {
+	var rawKey any
+	var err error
+	switch algorithm {
+	case jwa.ES256:
+		rawKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwa.RS256, jwa.PS256:
+		rawKey, err = rsa.GenerateKey(rand.Reader, rsaKeySize)
+	case jwa.EdDSA:
+		_, rawKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedSigningAlgorithm, algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return prepareKey(rawKey, jwk.ForSignature, algorithm)
+}
+
+// prepareKey converts the raw private key into a JSON Web Key and sets the
+// key id, usage and algorithm
+func prepareKey(rawKey any, usage jwk.KeyUsageType, algorithm jwa.KeyAlgorithm) (jwk.Key, error) {
+	key, err := jwk.FromRaw(rawKey)
+	if err != nil {
+		return nil, err
+	}
+
+	err = jwk.AssignKeyID(key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = key.Set(jwk.KeyUsageKey, usage)
+	if err != nil {
+		return nil, err
+	}
+
+	err = key.Set(jwk.AlgorithmKey, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// generationJSON is the serialized representation of a generation
+type generationJSON struct {
+	ID            string          `json:"id"`
+	Status        Status          `json:"status"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	ActivatedAt   time.Time       `json:"activatedAt,omitempty"`
+	RetiredAt     time.Time       `json:"retiredAt,omitempty"`
+	SigningKeys   json.RawMessage `json:"signingKeys"`
+	EncryptionKey json.RawMessage `json:"encryptionKey"`
+}
+
+func (g Generation) MarshalJSON() ([]byte, error) {
+	signingKeys, err := json.Marshal(newKeySet(g.SigningKeys))
+	if err != nil {
+		return nil, err
+	}
+
+	encryptionKey, err := json.Marshal(g.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(generationJSON{
+		ID:            g.ID,
+		Status:        g.Status,
+		CreatedAt:     g.CreatedAt,
+		ActivatedAt:   g.ActivatedAt,
+		RetiredAt:     g.RetiredAt,
+		SigningKeys:   signingKeys,
+		EncryptionKey: encryptionKey,
+	})
+}
+
+func (g *Generation) UnmarshalJSON(src []byte) error {
+	var input generationJSON
+	err := json.Unmarshal(src, &input)
+	if err != nil {
+		return err
+	}
+
+	signingKeys, err := parseKeys(input.SigningKeys)
+	if err != nil {
+		return err
+	}
+
+	encryptionKey, err := jwk.ParseKey(input.EncryptionKey)
+	if err != nil {
+		return err
+	}
+
+	*g = Generation{
+		ID:            input.ID,
+		Status:        input.Status,
+		CreatedAt:     input.CreatedAt,
+		ActivatedAt:   input.ActivatedAt,
+		RetiredAt:     input.RetiredAt,
+		SigningKeys:   signingKeys,
+		EncryptionKey: encryptionKey,
+	}
+	return nil
+}
+
+// newKeySet creates a key set containing the keys
+func newKeySet(keys []jwk.Key) jwk.Set {
+	set := jwk.NewSet()
+	for _, key := range keys {
+		_ = set.AddKey(key)
+	}
+	return set
+}
+
+// parseKeys reads the keys from a key set. A single key is accepted as well
+func parseKeys(src []byte) ([]jwk.Key, error) {
+	set, err := jwk.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []jwk.Key
+	for i := 0; i < set.Len(); i++ {
+		key, _ := set.Key(i)
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
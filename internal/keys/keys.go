@@ -0,0 +1,326 @@
+// Package keys manages the generations of keys used to sign and encrypt the
+// tokens issued by the service.
+// Every generation contains a signing key and an encryption key. New tokens
+// are always issued using the active generation, while the next generation
+// is published ahead of its activation and retired generations are kept to
+// verify and decrypt tokens issued before a rotation
+package keys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwe"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultRotationInterval sets how long a generation stays active if no
+// other interval has been configured
+const DefaultRotationInterval = 30 * 24 * time.Hour
+
+// RetentionPeriod sets how long the public keys of a retired generation are
+// published. It covers the lifetime of the longest living token signed with
+// the keys (refresh tokens)
+const RetentionPeriod = 12*time.Hour + 15*time.Minute
+
+// pruneInterval sets how often retired generations are checked for
+// generations which are not needed anymore
+const pruneInterval = time.Hour
+
+// reloadInterval sets how often the generations are reloaded from the store
+// to pick up rotations executed by other instances
+const reloadInterval = time.Minute
+
+var errNoActiveGeneration = errors.New("no active key generation available")
+var errNotInitialized = errors.New("key generations have not been initialized")
+
+var (
+	store       Store
+	lock        sync.RWMutex
+	generations []*Generation
+	active      *Generation
+)
+
+// Init loads the key generations from the store. If the store does not
+// contain an active or next generation, they are created
+func Init(ctx context.Context, s Store) error {
+	store = s
+	err := store.Update(ctx, ensureGenerations)
+	if err != nil {
+		return err
+	}
+	return Reload(ctx)
+}
+
+// Reload reads the key generations from the store
+func Reload(ctx context.Context) error {
+	if store == nil {
+		return errNotInitialized
+	}
+
+	loadedGenerations, err := store.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	var activeGeneration *Generation
+	for _, generation := range loadedGenerations {
+		if generation.Status == StatusActive {
+			activeGeneration = generation
+		}
+	}
+	if activeGeneration == nil {
+		return errNoActiveGeneration
+	}
+
+	// newer generations are preferred while decrypting tokens
+	slices.SortFunc(loadedGenerations, func(a, b *Generation) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	lock.Lock()
+	defer lock.Unlock()
+	generations = loadedGenerations
+	active = activeGeneration
+	return nil
+}
+
+// Rotate retires the active generation, activates the next generation and
+// creates a new next generation
+func Rotate(ctx context.Context) error {
+	if store == nil {
+		return errNotInitialized
+	}
+
+	err := store.Update(ctx, rotate)
+	if err != nil {
+		return err
+	}
+	return Reload(ctx)
+}
+
+// Run rotates the keys once the active generation has been active for the
+// rotation interval, periodically reloads the generations and prunes retired
+// generations which are not needed anymore.
+// It blocks until the context is canceled
+func Run(ctx context.Context, rotationInterval time.Duration) {
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	var lastPrune time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if time.Since(lastPrune) >= pruneInterval {
+				lastPrune = time.Now()
+				err := Prune(ctx)
+				if err != nil {
+					log.Warn().Err(err).Msg("unable to prune retired keys")
+				}
+			}
+
+			lock.RLock()
+			rotationDue := time.Since(active.ActivatedAt) >= rotationInterval
+			lock.RUnlock()
+			if !rotationDue {
+				err := Reload(ctx)
+				if err != nil {
+					log.Warn().Err(err).Msg("unable to reload keys")
+				}
+				continue
+			}
+
+			// the check is repeated while updating the store as another
+			// instance may have rotated the keys in the meantime
+			err := store.Update(ctx, func(generations []*Generation) ([]*Generation, error) {
+				for _, generation := range generations {
+					if generation.Status == StatusActive && time.Since(generation.ActivatedAt) < rotationInterval {
+						return generations, nil
+					}
+				}
+				log.Info().Msg("rotating signing and encryption keys")
+				return rotate(generations)
+			})
+			if err != nil {
+				log.Warn().Err(err).Msg("unable to rotate keys")
+			}
+
+			err = Reload(ctx)
+			if err != nil {
+				log.Warn().Err(err).Msg("unable to reload keys")
+			}
+		}
+	}
+}
+
+// ensureGenerations creates the active and next generation if they are
+// missing
+func ensureGenerations(generations []*Generation) ([]*Generation, error) {
+	var hasActive, hasNext bool
+	for _, generation := range generations {
+		hasActive = hasActive || generation.Status == StatusActive
+		hasNext = hasNext || generation.Status == StatusNext
+
+		// algorithms may have been enabled after the generation has been
+		// created. Retired generations are not used for signing anymore
+		if generation.Status != StatusRetired {
+			err := generation.addMissingSigningKeys()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !hasActive {
+		generation, err := NewGeneration(StatusActive)
+		if err != nil {
+			return nil, err
+		}
+		generations = append(generations, generation)
+	}
+
+	if !hasNext {
+		generation, err := NewGeneration(StatusNext)
+		if err != nil {
+			return nil, err
+		}
+		generations = append(generations, generation)
+	}
+	return generations, nil
+}
+
+func rotate(generations []*Generation) ([]*Generation, error) {
+	generations, err := ensureGenerations(generations)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, generation := range generations {
+		switch generation.Status {
+		case StatusActive:
+			generation.Status = StatusRetired
+			generation.RetiredAt = now
+		case StatusNext:
+			generation.Status = StatusActive
+			generation.ActivatedAt = now
+		}
+	}
+	return ensureGenerations(generations)
+}
+
+// Generations returns the currently loaded key generations
+func Generations() []*Generation {
+	lock.RLock()
+	defer lock.RUnlock()
+	return slices.Clone(generations)
+}
+
+// SigningKey returns the private signing key of the active generation for
+// the default signing algorithm
+func SigningKey() jwk.Key {
+	key, _ := SigningKeyFor(SigningAlgorithms[0])
+	return key
+}
+
+// SigningKeyFor returns the private signing key of the active generation for
+// the algorithm
+func SigningKeyFor(algorithm jwa.SignatureAlgorithm) (jwk.Key, error) {
+	if !IsSigningAlgorithmEnabled(algorithm) {
+		return nil, fmt.Errorf("%w: %s", errUnsupportedSigningAlgorithm, algorithm)
+	}
+
+	lock.RLock()
+	defer lock.RUnlock()
+	key := active.SigningKey(algorithm)
+	if key == nil {
+		return nil, fmt.Errorf("no signing key available for %s", algorithm)
+	}
+	return key, nil
+}
+
+// ActiveKeys returns the id of the active generation together with its
+// signing key for the default algorithm and its public encryption key.
+// They are read at once to not mix the keys of two generations during a
+// rotation
+func ActiveKeys() (generationID string, signingKey jwk.Key, encryptionKey jwk.Key) {
+	lock.RLock()
+	defer lock.RUnlock()
+	publicKey, _ := jwk.PublicKeyOf(active.EncryptionKey)
+	return active.ID, active.SigningKey(SigningAlgorithms[0]), publicKey
+}
+
+// EncryptionKey returns the public encryption key of the active generation
+func EncryptionKey() jwk.Key {
+	lock.RLock()
+	defer lock.RUnlock()
+	publicKey, _ := jwk.PublicKeyOf(active.EncryptionKey)
+	return publicKey
+}
+
+// VerificationKeys returns the public signing keys of every generation
+func VerificationKeys() jwk.Set {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	set := jwk.NewSet()
+	for _, generation := range generations {
+		for _, key := range generation.SigningKeys {
+			publicKey, err := jwk.PublicKeyOf(key)
+			if err != nil {
+				continue
+			}
+			_ = set.AddKey(publicKey)
+		}
+	}
+	return set
+}
+
+// PublicKeySet returns the public keys which are published by the service.
+// Retired generations are only published during the retention period
+func PublicKeySet() jwk.Set {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	set := jwk.NewSet()
+	for _, generation := range generations {
+		if generation.Status == StatusRetired && time.Since(generation.RetiredAt) > RetentionPeriod {
+			continue
+		}
+		for _, key := range append(slices.Clone(generation.SigningKeys), generation.EncryptionKey) {
+			publicKey, err := jwk.PublicKeyOf(key)
+			if err != nil {
+				continue
+			}
+			_ = set.AddKey(publicKey)
+		}
+	}
+	return set
+}
+
+// Decrypt decrypts the payload using the encryption keys of all generations
+func Decrypt(payload []byte) ([]byte, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	var err error
+	for _, generation := range generations {
+		var decryptedPayload []byte
+		decryptedPayload, err = jwe.Decrypt(payload, jwe.WithKey(jwa.ECDH_ES, generation.EncryptionKey))
+		if err == nil {
+			return decryptedPayload, nil
+		}
+	}
+	if err == nil {
+		err = errNoActiveGeneration
+	}
+	return nil, err
+}
@@ -0,0 +1,138 @@
+package keys
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwe"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+// initGenerations creates the generations in a temporary file store
+func initGenerations(t *testing.T) {
+	t.Helper()
+	err := Init(context.Background(), &FileStore{Path: filepath.Join(t.TempDir(), "keys.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// generationsByStatus returns the loaded generations with the status
+func generationsByStatus(status Status) []*Generation {
+	var matchingGenerations []*Generation
+	for _, generation := range Generations() {
+		if generation.Status == status {
+			matchingGenerations = append(matchingGenerations, generation)
+		}
+	}
+	return matchingGenerations
+}
+
+func TestInit(t *testing.T) {
+	initGenerations(t)
+
+	if count := len(generationsByStatus(StatusActive)); count != 1 {
+		t.Fatalf("expected a single active generation, got %d", count)
+	}
+	if count := len(generationsByStatus(StatusNext)); count != 1 {
+		t.Fatalf("expected a single next generation, got %d", count)
+	}
+
+	activeID, signingKey, encryptionKey := ActiveKeys()
+	if activeID != generationsByStatus(StatusActive)[0].ID {
+		t.Error("active keys do not belong to the active generation")
+	}
+	if signingKey == nil || encryptionKey == nil {
+		t.Fatal("active generation is missing keys")
+	}
+	if _, isPrivate := encryptionKey.(jwk.ECDSAPrivateKey); isPrivate {
+		t.Error("private encryption key returned")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	initGenerations(t)
+	previousActive := generationsByStatus(StatusActive)[0]
+	previousNext := generationsByStatus(StatusNext)[0]
+
+	encryptedPayload, err := jwe.Encrypt([]byte("payload"), jwe.WithKey(jwa.ECDH_ES, EncryptionKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Rotate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	active := generationsByStatus(StatusActive)
+	if len(active) != 1 || active[0].ID != previousNext.ID {
+		t.Fatal("next generation has not been activated")
+	}
+	if active[0].ActivatedAt.IsZero() {
+		t.Error("activation time not set")
+	}
+
+	retired := generationsByStatus(StatusRetired)
+	if len(retired) != 1 || retired[0].ID != previousActive.ID {
+		t.Fatal("previously active generation has not been retired")
+	}
+	if retired[0].RetiredAt.IsZero() {
+		t.Error("retirement time not set")
+	}
+
+	next := generationsByStatus(StatusNext)
+	if len(next) != 1 || next[0].ID == previousNext.ID {
+		t.Fatal("no new next generation created")
+	}
+
+	// tokens encrypted before the rotation stay readable
+	payload, err := Decrypt(encryptedPayload)
+	if err != nil {
+		t.Fatalf("payload encrypted by the retired generation not decrypted: %v", err)
+	}
+	if string(payload) != "payload" {
+		t.Errorf("unexpected payload %q", payload)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	tests := map[string]struct {
+		generation Generation
+		expired    bool
+	}{
+		"active":          {Generation{Status: StatusActive, RetiredAt: time.Now().Add(-2 * RetentionPeriod)}, false},
+		"next":            {Generation{Status: StatusNext}, false},
+		"recentlyRetired": {Generation{Status: StatusRetired, RetiredAt: time.Now().Add(-time.Minute)}, false},
+		"retired":         {Generation{Status: StatusRetired, RetiredAt: time.Now().Add(-RetentionPeriod - time.Minute)}, true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if expired := expired(&test.generation); expired != test.expired {
+				t.Errorf("expected %v, got %v", test.expired, expired)
+			}
+		})
+	}
+}
+
+// Clients registered by previous versions use the oldest generation, which
+// contains the imported legacy keys
+func TestOldestGeneration(t *testing.T) {
+	initGenerations(t)
+	first := generationsByStatus(StatusActive)[0]
+
+	for range 2 {
+		err := Rotate(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldest := oldestGeneration()
+	if oldest == nil || *oldest != first.ID {
+		t.Errorf("expected the first generation %s to be the oldest", first.ID)
+	}
+}
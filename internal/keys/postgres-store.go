@@ -0,0 +1,271 @@
+package keys
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+
+	"microservice/internal/db"
+)
+
+// KeyEncryptionKeySize is the size of the key encryption key in bytes
+const KeyEncryptionKeySize = 32
+
+// storeLockKey is used as the key for the advisory lock which prevents
+// multiple instances from changing the generations at the same time
+const storeLockKey = "user-management-keys"
+
+var errInvalidKeyEncryptionKey = fmt.Errorf("key encryption key needs to contain %d base64 encoded bytes", KeyEncryptionKeySize)
+var errInvalidCiphertext = errors.New("encrypted key is too short")
+
+// PostgresStore stores the key generations in the database to share them
+// between all instances of the service.
+// The private keys are encrypted using AES-GCM with the key encryption key
+type PostgresStore struct {
+	KeyEncryptionKey []byte
+
+	// Legacy is used to import the generations during the first startup if
+	// the database does not contain any generation
+	Legacy Store
+}
+
+// storedGeneration is the representation of a generation in the database
+type storedGeneration struct {
+	ID            string     `db:"id"`
+	Status        Status     `db:"status"`
+	CreatedAt     time.Time  `db:"created_at"`
+	ActivatedAt   *time.Time `db:"activated_at"`
+	RetiredAt     *time.Time `db:"retired_at"`
+	SigningKeys   []byte     `db:"signing_keys"`
+	EncryptionKey []byte     `db:"encryption_key"`
+}
+
+// ParseKeyEncryptionKey decodes the base64 encoded key encryption key
+func ParseKeyEncryptionKey(rawKey string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(rawKey))
+	if err != nil || len(key) != KeyEncryptionKeySize {
+		return nil, errInvalidKeyEncryptionKey
+	}
+	return key, nil
+}
+
+func (s *PostgresStore) Load(ctx context.Context) ([]*Generation, error) {
+	query, err := db.Queries.Raw("get-key-generations")
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []storedGeneration
+	err = pgxscan.Select(ctx, db.Pool, &rows, query)
+	if err != nil {
+		return nil, err
+	}
+	return s.decodeGenerations(rows)
+}
+
+// Update changes the generations in a transaction holding an advisory lock.
+// Generations missing in the result of the update function are deleted.
+// This ensures that only a single instance creates or rotates the keys while
+// all other instances wait and pick up the result
+func (s *PostgresStore) Update(ctx context.Context, update func(generations []*Generation) ([]*Generation, error)) error {
+	loadQuery, err := db.Queries.Raw("get-key-generations")
+	if err != nil {
+		return err
+	}
+
+	storeQuery, err := db.Queries.Raw("store-key-generation")
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", storeLockKey)
+	if err != nil {
+		return err
+	}
+
+	var rows []storedGeneration
+	err = pgxscan.Select(ctx, tx, &rows, loadQuery)
+	if err != nil {
+		return err
+	}
+
+	generations, err := s.decodeGenerations(rows)
+	if err != nil {
+		return err
+	}
+
+	if len(generations) == 0 && s.Legacy != nil {
+		generations, err = s.Legacy.Load(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	generations, err = update(generations)
+	if err != nil {
+		return err
+	}
+
+	deleteQuery, err := db.Queries.Raw("delete-other-key-generations")
+	if err != nil {
+		return err
+	}
+
+	batch := &pgx.Batch{}
+	ids := make([]string, 0, len(generations))
+	for _, generation := range generations {
+		row, err := s.encodeGeneration(generation)
+		if err != nil {
+			return err
+		}
+		batch.Queue(storeQuery, row.ID, row.Status, row.CreatedAt, row.ActivatedAt, row.RetiredAt, row.SigningKeys, row.EncryptionKey)
+		ids = append(ids, row.ID)
+	}
+
+	// generations which have not been returned by the update function have
+	// been pruned
+	batch.Queue(deleteQuery, ids)
+
+	err = tx.SendBatch(ctx, batch).Close()
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStore) decodeGenerations(rows []storedGeneration) ([]*Generation, error) {
+	var generations []*Generation
+	for _, row := range rows {
+		rawSigningKeys, err := s.decrypt(row.SigningKeys)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt signing keys of generation %s: %w", row.ID, err)
+		}
+
+		signingKeys, err := parseKeys(rawSigningKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		rawEncryptionKey, err := s.decrypt(row.EncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt encryption key of generation %s: %w", row.ID, err)
+		}
+
+		encryptionKey, err := jwk.ParseKey(rawEncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+
+		generation := &Generation{
+			ID:            row.ID,
+			Status:        row.Status,
+			CreatedAt:     row.CreatedAt,
+			SigningKeys:   signingKeys,
+			EncryptionKey: encryptionKey,
+		}
+		if row.ActivatedAt != nil {
+			generation.ActivatedAt = *row.ActivatedAt
+		}
+		if row.RetiredAt != nil {
+			generation.RetiredAt = *row.RetiredAt
+		}
+		generations = append(generations, generation)
+	}
+	return generations, nil
+}
+
+func (s *PostgresStore) encodeGeneration(generation *Generation) (*storedGeneration, error) {
+	rawSigningKeys, err := json.Marshal(newKeySet(generation.SigningKeys))
+	if err != nil {
+		return nil, err
+	}
+
+	signingKeys, err := s.encrypt(rawSigningKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	rawEncryptionKey, err := json.Marshal(generation.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptionKey, err := s.encrypt(rawEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	row := &storedGeneration{
+		ID:            generation.ID,
+		Status:        generation.Status,
+		CreatedAt:     generation.CreatedAt,
+		SigningKeys:   signingKeys,
+		EncryptionKey: encryptionKey,
+	}
+	if !generation.ActivatedAt.IsZero() {
+		row.ActivatedAt = &generation.ActivatedAt
+	}
+	if !generation.RetiredAt.IsZero() {
+		row.RetiredAt = &generation.RetiredAt
+	}
+	return row, nil
+}
+
+// encrypt encrypts the JSON representation of a key. The nonce is prepended
+// to the ciphertext
+func (s *PostgresStore) encrypt(plaintext []byte) ([]byte, error) {
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (s *PostgresStore) decrypt(ciphertext []byte) ([]byte, error) {
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errInvalidCiphertext
+	}
+
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func (s *PostgresStore) aead() (cipher.AEAD, error) {
+	if len(s.KeyEncryptionKey) != KeyEncryptionKeySize {
+		return nil, errInvalidKeyEncryptionKey
+	}
+
+	block, err := aes.NewCipher(s.KeyEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,150 @@
+package keys
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newPostgresStore creates a store using a key encryption key filled with
+// the byte
+func newPostgresStore(fill byte) *PostgresStore {
+	return &PostgresStore{KeyEncryptionKey: bytes.Repeat([]byte{fill}, KeyEncryptionKeySize)}
+}
+
+func TestParseKeyEncryptionKey(t *testing.T) {
+	rawKey := bytes.Repeat([]byte{1}, KeyEncryptionKeySize)
+	key, err := ParseKeyEncryptionKey(" " + base64.StdEncoding.EncodeToString(rawKey) + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, rawKey) {
+		t.Error("decoded key does not match")
+	}
+
+	tests := map[string]string{
+		"empty key":     "",
+		"invalid input": "not base64!",
+		"short key":     base64.StdEncoding.EncodeToString(rawKey[:16]),
+		"long key":      base64.StdEncoding.EncodeToString(append(rawKey, 1)),
+	}
+
+	for name, rawKey := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseKeyEncryptionKey(rawKey)
+			if !errors.Is(err, errInvalidKeyEncryptionKey) {
+				t.Errorf("expected %v, got %v", errInvalidKeyEncryptionKey, err)
+			}
+		})
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	store := newPostgresStore(1)
+	plaintext := []byte(`{"kty":"EC"}`)
+
+	ciphertext, err := store.encrypt(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(ciphertext, plaintext) {
+		t.Error("ciphertext contains the plaintext")
+	}
+
+	secondCiphertext, err := store.encrypt(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(ciphertext, secondCiphertext) {
+		t.Error("nonce reused for multiple encryptions")
+	}
+
+	decrypted, err := store.decrypt(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("expected %q, got %q", plaintext, decrypted)
+	}
+}
+
+func TestDecryptRejectsInvalidCiphertexts(t *testing.T) {
+	store := newPostgresStore(1)
+	ciphertext, err := store.encrypt([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.decrypt(ciphertext[:4])
+	if !errors.Is(err, errInvalidCiphertext) {
+		t.Errorf("expected %v, got %v", errInvalidCiphertext, err)
+	}
+
+	tamperedCiphertext := bytes.Clone(ciphertext)
+	tamperedCiphertext[len(tamperedCiphertext)-1] ^= 1
+	_, err = store.decrypt(tamperedCiphertext)
+	if err == nil {
+		t.Error("tampered ciphertext decrypted")
+	}
+
+	_, err = newPostgresStore(2).decrypt(ciphertext)
+	if err == nil {
+		t.Error("ciphertext decrypted with another key encryption key")
+	}
+
+	_, err = (&PostgresStore{}).decrypt(ciphertext)
+	if !errors.Is(err, errInvalidKeyEncryptionKey) {
+		t.Errorf("expected %v, got %v", errInvalidKeyEncryptionKey, err)
+	}
+}
+
+func TestEncodeGeneration(t *testing.T) {
+	store := newPostgresStore(1)
+	generation, err := NewGeneration(StatusActive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generation.ActivatedAt = time.Now().Truncate(time.Second)
+
+	row, err := store.encodeGeneration(generation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.RetiredAt != nil {
+		t.Error("retirement time set for an active generation")
+	}
+
+	generations, err := store.decodeGenerations([]storedGeneration{*row})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(generations) != 1 {
+		t.Fatalf("expected a single generation, got %d", len(generations))
+	}
+
+	decoded := generations[0]
+	if decoded.ID != generation.ID || decoded.Status != generation.Status {
+		t.Error("generation metadata has not been restored")
+	}
+	if !decoded.ActivatedAt.Equal(generation.ActivatedAt) || !decoded.RetiredAt.IsZero() {
+		t.Error("generation timestamps have not been restored")
+	}
+	if len(decoded.SigningKeys) != len(generation.SigningKeys) {
+		t.Fatalf("expected %d signing keys, got %d", len(generation.SigningKeys), len(decoded.SigningKeys))
+	}
+	for _, algorithm := range SigningAlgorithms {
+		if decoded.SigningKey(algorithm) == nil {
+			t.Errorf("signing key for %s has not been restored", algorithm)
+		}
+	}
+	if decoded.EncryptionKey.KeyID() != generation.EncryptionKey.KeyID() {
+		t.Error("encryption key has not been restored")
+	}
+
+	_, err = newPostgresStore(2).decodeGenerations([]storedGeneration{*row})
+	if err == nil {
+		t.Error("generation decoded with another key encryption key")
+	}
+}
@@ -0,0 +1,88 @@
+package keys
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+
+	"microservice/internal/db"
+)
+
+// Prune deletes retired generations once every token signed or encrypted
+// with their keys has expired.
+// As client secrets do not expire, generations used to issue a client secret
+// are kept as long as the client exists. Clients registered before the
+// generation has been stored alongside the client keep the oldest generation,
+// which contains the keys used before the introduction of key generations
+func Prune(ctx context.Context) error {
+	if store == nil {
+		return errNotInitialized
+	}
+
+	if !slices.ContainsFunc(Generations(), expired) {
+		return nil
+	}
+
+	usedGenerations, err := clientGenerations(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = store.Update(ctx, func(generations []*Generation) ([]*Generation, error) {
+		return slices.DeleteFunc(generations, func(generation *Generation) bool {
+			return expired(generation) && !slices.Contains(usedGenerations, generation.ID)
+		}), nil
+	})
+	if err != nil {
+		return err
+	}
+	return Reload(ctx)
+}
+
+// expired reports if the generation has been retired for longer than the
+// lifetime of the longest living token
+func expired(generation *Generation) bool {
+	return generation.Status == StatusRetired && time.Since(generation.RetiredAt) > RetentionPeriod
+}
+
+// clientGenerations returns the generations used to issue the secrets of the
+// registered clients. Clients registered before the generation has been
+// stored use the oldest generation
+func clientGenerations(ctx context.Context) ([]string, error) {
+	query, err := db.Queries.Raw("get-client-key-generations")
+	if err != nil {
+		return nil, err
+	}
+
+	var clientGenerations []*string
+	err = pgxscan.Select(ctx, db.Pool, &clientGenerations, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var usedGenerations []string
+	for _, generation := range clientGenerations {
+		if generation == nil {
+			generation = oldestGeneration()
+		}
+		if generation != nil {
+			usedGenerations = append(usedGenerations, *generation)
+		}
+	}
+	return usedGenerations, nil
+}
+
+// oldestGeneration returns the id of the first generation created
+func oldestGeneration() *string {
+	generations := Generations()
+	if len(generations) == 0 {
+		return nil
+	}
+
+	oldest := slices.MinFunc(generations, func(a, b *Generation) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return &oldest.ID
+}
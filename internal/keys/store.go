@@ -0,0 +1,154 @@
+package keys
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+var errUnsupportedLegacyKey = errors.New("unsupported legacy private key type")
+
+// Store persists the key generations
+type Store interface {
+	// Load returns every stored key generation
+	Load(ctx context.Context) ([]*Generation, error)
+
+	// Update replaces the stored key generations with the generations
+	// returned by the update function. The store ensures that no other update
+	// is executed at the same time
+	Update(ctx context.Context, update func(generations []*Generation) ([]*Generation, error)) error
+}
+
+// FileStore stores the key generations in a single JSON file.
+// If the file does not exist yet, the keys stored in the legacy PEM files
+// are imported as active generation to keep already issued tokens valid
+type FileStore struct {
+	Path string
+
+	LegacySigningKeyPath    string
+	LegacyEncryptionKeyPath string
+
+	lock sync.Mutex
+}
+
+func (s *FileStore) Load(_ context.Context) ([]*Generation, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.load()
+}
+
+func (s *FileStore) Update(_ context.Context, update func(generations []*Generation) ([]*Generation, error)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	generations, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	generations, err = update(generations)
+	if err != nil {
+		return err
+	}
+
+	contents, err := json.MarshalIndent(generations, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(s.Path), 0700)
+	if err != nil {
+		return err
+	}
+
+	// the file is replaced atomically to not leave a partially written file
+	// behind
+	temporaryPath := s.Path + ".tmp"
+	err = os.WriteFile(temporaryPath, contents, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporaryPath, s.Path)
+}
+
+func (s *FileStore) load() ([]*Generation, error) {
+	contents, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s.importLegacyKeys()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var generations []*Generation
+	err = json.Unmarshal(contents, &generations)
+	if err != nil {
+		return nil, err
+	}
+	return generations, nil
+}
+
+// importLegacyKeys reads the keys from the legacy PEM files. If the files do
+// not exist, no generation is returned
+func (s *FileStore) importLegacyKeys() ([]*Generation, error) {
+	if s.LegacySigningKeyPath == "" || s.LegacyEncryptionKeyPath == "" {
+		return nil, nil
+	}
+
+	generation, err := ImportLegacyKeys(s.LegacySigningKeyPath, s.LegacyEncryptionKeyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []*Generation{generation}, nil
+}
+
+// ImportLegacyKeys creates an active generation from the PEM encoded private
+// keys used before the introduction of key generations
+func ImportLegacyKeys(signingKeyPath, encryptionKeyPath string) (*Generation, error) {
+	rawSigningKey, err := readLegacyKey(signingKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	rawEncryptionKey, err := readLegacyKey(encryptionKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	signingKey, err := prepareKey(rawSigningKey, jwk.ForSignature, jwa.ES256)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptionKey, err := prepareKey(rawEncryptionKey, jwk.ForEncryption, jwa.ECDH_ES)
+	if err != nil {
+		return nil, err
+	}
+
+	return newGeneration(StatusActive, []jwk.Key{signingKey}, encryptionKey), nil
+}
+
+func readLegacyKey(path string) (*ecdsa.PrivateKey, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(contents)
+	if block == nil || block.Type != "EC PRIVATE KEY" {
+		return nil, errUnsupportedLegacyKey
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
@@ -0,0 +1,191 @@
+// Package redistest provides an in-memory redis server for tests.
+// It only supports the commands used by the service to prevent the replay of
+// proofs and client assertions
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// entry is a value stored in the server
+type entry struct {
+	value      string
+	expiration time.Time
+}
+
+// server stores the values of the keys in memory
+type server struct {
+	lock    sync.Mutex
+	entries map[string]entry
+}
+
+// NewClient starts a server which is stopped at the end of the test and
+// returns a client connected to it
+func NewClient(t testing.TB) *redis.Client {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &server{entries: make(map[string]entry)}
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(connection)
+		}
+	}()
+
+	client := redis.NewClient(&redis.Options{
+		Addr:             listener.Addr().String(),
+		Protocol:         2,
+		DisableIndentity: true,
+	})
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = listener.Close()
+	})
+	return client
+}
+
+// serve answers the commands sent using the connection
+func (s *server) serve(connection net.Conn) {
+	defer connection.Close()
+	reader := bufio.NewReader(connection)
+	for {
+		command, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		_, err = io.WriteString(connection, s.execute(command))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads a command sent as array of bulk strings
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(header, "*") {
+		return nil, fmt.Errorf("unexpected command %q", header)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(header[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	command := make([]string, count)
+	for i := range command {
+		lengthHeader, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(lengthHeader, "$")))
+		if err != nil {
+			return nil, err
+		}
+		argument := make([]byte, length+2)
+		_, err = io.ReadFull(reader, argument)
+		if err != nil {
+			return nil, err
+		}
+		command[i] = string(argument[:length])
+	}
+	return command, nil
+}
+
+// execute runs the command and returns the encoded reply
+func (s *server) execute(command []string) string {
+	if len(command) == 0 {
+		return "-ERR empty command\r\n"
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch strings.ToUpper(command[0]) {
+	case "SET":
+		return s.set(command[1:])
+	case "GET":
+		if len(command) != 2 {
+			return "-ERR wrong number of arguments\r\n"
+		}
+		value, found := s.get(command[1])
+		if !found {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	default:
+		return fmt.Sprintf("-ERR unknown command '%s'\r\n", command[0])
+	}
+}
+
+// set stores the value. The EX, PX and NX options are supported
+func (s *server) set(arguments []string) string {
+	if len(arguments) < 2 {
+		return "-ERR wrong number of arguments\r\n"
+	}
+	key, value := arguments[0], arguments[1]
+
+	var stored entry
+	var onlyIfMissing bool
+	for i := 2; i < len(arguments); i++ {
+		switch option := strings.ToUpper(arguments[i]); option {
+		case "NX":
+			onlyIfMissing = true
+		case "EX", "PX":
+			if i+1 >= len(arguments) {
+				return "-ERR syntax error\r\n"
+			}
+			i++
+			ttl, err := strconv.Atoi(arguments[i])
+			if err != nil || ttl <= 0 {
+				return "-ERR invalid expire time\r\n"
+			}
+			unit := time.Second
+			if option == "PX" {
+				unit = time.Millisecond
+			}
+			stored.expiration = time.Now().Add(time.Duration(ttl) * unit)
+		default:
+			return "-ERR syntax error\r\n"
+		}
+	}
+
+	if _, found := s.get(key); found && onlyIfMissing {
+		return "$-1\r\n"
+	}
+	stored.value = value
+	s.entries[key] = stored
+	return "+OK\r\n"
+}
+
+// get returns the value of the key if it has not expired
+func (s *server) get(key string) (string, bool) {
+	stored, found := s.entries[key]
+	if !found {
+		return "", false
+	}
+	if !stored.expiration.IsZero() && time.Now().After(stored.expiration) {
+		delete(s.entries, key)
+		return "", false
+	}
+	return stored.value, true
+}
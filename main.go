@@ -16,12 +16,18 @@ import (
 	healthcheckServer "github.com/wisdom-oss/go-healthcheck/server"
 	"golang.org/x/sync/errgroup"
 
+	"microservice/dpop"
 	"microservice/internal"
+	"microservice/internal/cache"
 	"microservice/internal/config"
 	"microservice/internal/db"
+	"microservice/internal/keys"
+	"microservice/revocation"
 	"microservice/routes"
 	"microservice/routes/clients"
+	"microservice/routes/invitations"
 	"microservice/routes/permissions"
+	"microservice/routes/scim"
 	"microservice/routes/users"
 )
 
@@ -46,6 +52,11 @@ func main() {
 
 	// create jwt validator using localhost to get data
 	jwtValidator := middleware.JWTValidator{}
+	// the dpop validator is mounted in front of the jwt validator to allow
+	// the usage of access tokens bound to a DPoP key
+	proofValidator := dpop.Validator{Redis: db.Redis}
+	// revoked access tokens are rejected before validating them
+	denyList := revocation.DenyList{Redis: db.Redis}
 	protect := middleware.RequireScope{}
 	requireRead := protect.Gin("user-management", types.ScopeRead)
 	requireWrite := protect.Gin("user-management", types.ScopeWrite)
@@ -56,7 +67,15 @@ func main() {
 	service.GET("/login", routes.InitiateLogin)
 	service.GET("/callback", routes.Callback)
 	service.POST("/token", routes.Token)
-	service.POST("/revoke", jwtValidator.GinHandler, routes.RevokeToken)
+	service.POST("/revoke", routes.RevokeToken)
+	service.POST("/introspect", routes.IntrospectToken)
+	service.GET("/logout", routes.EndSession)
+	service.POST("/logout", routes.EndSession)
+	service.POST("/backchannel-logout", routes.BackChannelLogout)
+	service.POST("/device_authorization", routes.DeviceAuthorization)
+	service.GET("/device", routes.DeviceVerification)
+	service.POST("/device", routes.DeviceConfirmation)
+	service.GET("/device/callback", routes.DeviceCallback)
 
 	wellKnown := service.Group("/.well-known")
 	{
@@ -64,26 +83,73 @@ func main() {
 		wellKnown.GET("/openid-configuration", routes.OpenIDConfiguration)
 	}
 
-	userManagement := service.Group("/users", jwtValidator.GinHandler)
+	userManagement := service.Group("/users", proofValidator.GinHandler, denyList.GinHandler, jwtValidator.GinHandler)
 	{
 		userManagement.GET("/:userID", users.Information)
+		userManagement.GET("/:userID/sessions", users.Sessions)
+		userManagement.DELETE("/:userID/sessions", users.DeleteSessions)
+		userManagement.DELETE("/:userID/sessions/:sessionID", users.DeleteSession)
 		userManagement.GET("/", requireRead, users.List)
-		// userManagement.PATCH("/:userID", protect.Gin("user-management", types.ScopeWrite))   // todo: update user
+		userManagement.PATCH("/:userID", requireWrite, users.Update)
 		userManagement.DELETE("/:userID", requireDelete, users.Delete) // todo: delete user
 	}
 
-	permissionManagement := service.Group("/permissions", jwtValidator.GinHandler)
+	permissionManagement := service.Group("/permissions", proofValidator.GinHandler, denyList.GinHandler, jwtValidator.GinHandler)
 	{
 		permissionManagement.PATCH("/assign", requireWrite, permissions.Assign)
 		permissionManagement.PATCH("/delete", requireDelete, permissions.Delete)
 	}
 
-	clientManagement := service.Group("/clients", jwtValidator.GinHandler)
+	// invitations grant arbitrary permissions to whoever logs in with the
+	// invited email address, so they are managed by administrators only
+	requireAdmin := protect.Gin("user-management", types.ScopeAdmin)
+	invitationManagement := service.Group("/invitations", proofValidator.GinHandler, denyList.GinHandler, jwtValidator.GinHandler)
+	{
+		invitationManagement.GET("/", requireAdmin, invitations.List)
+		invitationManagement.POST("/", requireAdmin, invitations.Create)
+		invitationManagement.DELETE("/:invitationID", requireAdmin, invitations.Revoke)
+	}
+
+	keyManagement := service.Group("/keys", proofValidator.GinHandler, denyList.GinHandler, jwtValidator.GinHandler)
+	{
+		keyManagement.POST("/rotate", protect.Gin("user-management", types.ScopeAdmin), routes.RotateKeys)
+	}
+
+	clientManagement := service.Group("/clients", proofValidator.GinHandler, denyList.GinHandler, jwtValidator.GinHandler)
 	{
 		clientManagement.POST("/", requireWrite, clients.Create)
 		clientManagement.DELETE("/:clientID", requireDelete, clients.Delete)
 	}
 
+	// the SCIM endpoint is used by identity management systems which
+	// authenticate using the client credentials grant
+	scimProvisioning := service.Group(scim.BasePath, proofValidator.GinHandler, denyList.GinHandler, jwtValidator.GinHandler)
+	{
+		requireSCIMRead := protect.Gin("scim", types.ScopeRead)
+		requireSCIMWrite := protect.Gin("scim", types.ScopeWrite)
+		requireSCIMDelete := protect.Gin("scim", types.ScopeDelete)
+
+		scimProvisioning.GET("/ServiceProviderConfig", requireSCIMRead, scim.ServiceProviderConfig)
+		scimProvisioning.GET("/ResourceTypes", requireSCIMRead, scim.ResourceTypes)
+		scimProvisioning.GET("/ResourceTypes/:resourceTypeID", requireSCIMRead, scim.ResourceType)
+		scimProvisioning.GET("/Schemas", requireSCIMRead, scim.Schemas)
+		scimProvisioning.GET("/Schemas/:schemaID", requireSCIMRead, scim.Schema)
+
+		scimProvisioning.GET("/Users", requireSCIMRead, scim.ListUsers)
+		scimProvisioning.POST("/Users", requireSCIMWrite, scim.CreateUser)
+		scimProvisioning.GET("/Users/:userID", requireSCIMRead, scim.GetUser)
+		scimProvisioning.PUT("/Users/:userID", requireSCIMWrite, scim.ReplaceUser)
+		scimProvisioning.PATCH("/Users/:userID", requireSCIMWrite, scim.PatchUser)
+		scimProvisioning.DELETE("/Users/:userID", requireSCIMDelete, scim.DeleteUser)
+
+		scimProvisioning.GET("/Groups", requireSCIMRead, scim.ListGroups)
+		scimProvisioning.POST("/Groups", requireSCIMWrite, scim.CreateGroup)
+		scimProvisioning.GET("/Groups/:groupID", requireSCIMRead, scim.GetGroup)
+		scimProvisioning.PUT("/Groups/:groupID", requireSCIMWrite, scim.ReplaceGroup)
+		scimProvisioning.PATCH("/Groups/:groupID", requireSCIMWrite, scim.PatchGroup)
+		scimProvisioning.DELETE("/Groups/:groupID", requireSCIMDelete, scim.DeleteGroup)
+	}
+
 	externalServer := &http.Server{
 		Addr:    config.ListenAddress,
 		Handler: service,
@@ -109,6 +175,12 @@ func main() {
 	// start the refresh token cleanup
 	go cleanupRefreshTokens(cleanupSignal)
 
+	// remove cached users and permissions once they change
+	go cache.Listen(context.Background())
+
+	// start the scheduled key rotation
+	go keys.Run(context.Background(), keyRotationInterval)
+
 	// Block further code execution until the shutdown signal was received
 	l.Info().Msg("server ready to accept connections")
 
@@ -14,6 +14,11 @@ var OidcProvider *oidc.Provider
 var TokenVerifier *oidc.IDTokenVerifier
 var ExternalIssuer string
 
+// EndSessionEndpoint contains the endpoint of the external provider used to
+// end the session of a user. It is empty if the provider does not support
+// RP-initiated logout
+var EndSessionEndpoint string
+
 type externalProvider struct {
 	oauth2.Config
 }
@@ -44,6 +49,13 @@ func (p *externalProvider) Configure(issuer string, clientID string, clientSecre
 
 	TokenVerifier = OidcProvider.Verifier(&oidc.Config{ClientID: clientID})
 
+	var providerClaims struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	if err := OidcProvider.Claims(&providerClaims); err == nil {
+		EndSessionEndpoint = providerClaims.EndSessionEndpoint
+	}
+
 	p.Endpoint = OidcProvider.Endpoint()
 	return nil
 }
@@ -6,3 +6,11 @@ import (
 
 //go:embed *.sql
 var QueryFiles embed.FS
+
+// MigrationFiles contains the schema changes applied to the database during
+// the startup of the service.
+// The files are applied in lexical order and need to be idempotent as they
+// are executed on every startup
+//
+//go:embed migrations/*.sql
+var MigrationFiles embed.FS
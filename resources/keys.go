@@ -1,11 +0,0 @@
-package resources
-
-import "github.com/lestrrat-go/jwx/v2/jwk"
-
-var PublicSigningKey jwk.Key
-var PrivateSigningKey jwk.Key
-
-var PublicEncryptionKey jwk.Key
-var PrivateEncryptionKey jwk.Key
-
-var KeySet jwk.Set
@@ -0,0 +1,7 @@
+-- every refresh token belongs to a family containing all refresh tokens that
+-- have been issued by rotating the initially issued refresh token
+ALTER TABLE auth.refresh_tokens
+    ADD COLUMN IF NOT EXISTS family_id uuid;
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx
+    ON auth.refresh_tokens (family_id);
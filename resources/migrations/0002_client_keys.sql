@@ -0,0 +1,13 @@
+-- the scopes of a client are stored to allow clients to authenticate without
+-- the client secret which previously was the only place holding the scopes
+ALTER TABLE auth.clients
+    ADD COLUMN IF NOT EXISTS scopes text[];
+
+-- clients may register public keys to authenticate using signed assertions
+-- either by supplying the key set directly or by supplying a url pointing to
+-- it
+ALTER TABLE auth.clients
+    ADD COLUMN IF NOT EXISTS jwks jsonb;
+
+ALTER TABLE auth.clients
+    ADD COLUMN IF NOT EXISTS jwks_uri text;
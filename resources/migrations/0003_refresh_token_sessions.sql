@@ -0,0 +1,22 @@
+-- refresh tokens store the information required to list and end the
+-- sessions of a user. A session consists of all refresh tokens of a family
+ALTER TABLE auth.refresh_tokens
+    ADD COLUMN IF NOT EXISTS user_id uuid;
+
+ALTER TABLE auth.refresh_tokens
+    ADD COLUMN IF NOT EXISTS client_id text;
+
+ALTER TABLE auth.refresh_tokens
+    ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT NOW();
+
+ALTER TABLE auth.refresh_tokens
+    ADD COLUMN IF NOT EXISTS last_used_at timestamptz;
+
+ALTER TABLE auth.refresh_tokens
+    ADD COLUMN IF NOT EXISTS ip inet;
+
+ALTER TABLE auth.refresh_tokens
+    ADD COLUMN IF NOT EXISTS user_agent text;
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx
+    ON auth.refresh_tokens (user_id);
@@ -0,0 +1,12 @@
+-- the keys used to sign and encrypt tokens are shared by all instances of the
+-- service. The private keys are encrypted using the key encryption key
+-- before being stored
+CREATE TABLE IF NOT EXISTS auth.key_generations (
+    id             uuid        PRIMARY KEY,
+    status         text        NOT NULL,
+    created_at     timestamptz NOT NULL,
+    activated_at   timestamptz,
+    retired_at     timestamptz,
+    signing_key    bytea       NOT NULL,
+    encryption_key bytea       NOT NULL
+);
@@ -0,0 +1,23 @@
+-- generations contain a signing key for every configured signing algorithm.
+-- The column holds the encrypted key set of the signing keys
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1
+        FROM information_schema.columns
+        WHERE table_schema = 'auth'
+          AND table_name = 'key_generations'
+          AND column_name = 'signing_key'
+    ) THEN
+        ALTER TABLE auth.key_generations
+            RENAME COLUMN signing_key TO signing_keys;
+    END IF;
+END
+$$;
+
+-- clients may select the algorithms used to sign the tokens issued for them
+ALTER TABLE auth.clients
+    ADD COLUMN IF NOT EXISTS id_token_signed_response_alg text;
+
+ALTER TABLE auth.clients
+    ADD COLUMN IF NOT EXISTS access_token_signed_response_alg text;
@@ -0,0 +1,63 @@
+-- changes to users, permissions and services are announced on the
+-- user_management_cache channel to invalidate the cached entries of every
+-- instance. The payload contains the changed table and the identifiers of
+-- the affected rows
+CREATE OR REPLACE FUNCTION auth.notify_cache_invalidation() RETURNS trigger AS $$
+DECLARE
+    affected record;
+BEGIN
+    IF TG_LEVEL = 'STATEMENT' THEN
+        PERFORM pg_notify('user_management_cache', json_build_object(
+            'table', TG_TABLE_NAME
+        )::text);
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP = 'DELETE' THEN
+        affected := OLD;
+    ELSE
+        affected := NEW;
+    END IF;
+
+    IF TG_TABLE_NAME = 'users' THEN
+        PERFORM pg_notify('user_management_cache', json_build_object(
+            'table', TG_TABLE_NAME,
+            'id', affected.id,
+            'externalIdentifiers', CASE
+                WHEN TG_OP = 'UPDATE' THEN json_build_array(OLD.external_identifier, NEW.external_identifier)
+                ELSE json_build_array(affected.external_identifier)
+            END
+        )::text);
+    ELSE
+        PERFORM pg_notify('user_management_cache', json_build_object(
+            'table', TG_TABLE_NAME,
+            'id', affected.user_id
+        )::text);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- the triggers are only created once as recreating them on every startup
+-- would lock the tables
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'notify_cache_invalidation' AND tgrelid = 'auth.users'::regclass) THEN
+        CREATE TRIGGER notify_cache_invalidation
+            AFTER INSERT OR UPDATE OR DELETE ON auth.users
+            FOR EACH ROW EXECUTE FUNCTION auth.notify_cache_invalidation();
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'notify_cache_invalidation' AND tgrelid = 'auth.permission_assignments'::regclass) THEN
+        CREATE TRIGGER notify_cache_invalidation
+            AFTER INSERT OR UPDATE OR DELETE ON auth.permission_assignments
+            FOR EACH ROW EXECUTE FUNCTION auth.notify_cache_invalidation();
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'notify_cache_invalidation' AND tgrelid = 'auth.services'::regclass) THEN
+        CREATE TRIGGER notify_cache_invalidation
+            AFTER INSERT OR UPDATE OR DELETE ON auth.services
+            FOR EACH STATEMENT EXECUTE FUNCTION auth.notify_cache_invalidation();
+    END IF;
+END
+$$;
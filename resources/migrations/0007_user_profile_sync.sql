@@ -0,0 +1,4 @@
+-- users record when their profile has last been synchronized with the
+-- external provider
+ALTER TABLE auth.users
+    ADD COLUMN IF NOT EXISTS last_synced_at timestamptz;
@@ -0,0 +1,14 @@
+-- the SCIM provisioning endpoint is protected by dedicated scopes which are
+-- offered by their own service to allow granting them to clients
+INSERT INTO auth.services (id, name, description, supported_scope_levels)
+SELECT
+    gen_random_uuid(),
+    'scim',
+    'SCIM 2.0 provisioning of users and groups',
+    '{read,write,delete}'
+WHERE
+    NOT EXISTS (
+        SELECT 1
+        FROM auth.services
+        WHERE name = 'scim'
+    );
@@ -0,0 +1,21 @@
+-- invitations grant permissions to users before their first login. They are
+-- matched by the subject at the external provider or the verified email
+-- address reported during the first login
+CREATE TABLE IF NOT EXISTS auth.invitations (
+    id                  uuid        PRIMARY KEY DEFAULT gen_random_uuid(),
+    email               text,
+    external_identifier text,
+    expires_at          timestamptz NOT NULL,
+    created_at          timestamptz NOT NULL DEFAULT NOW(),
+    created_by          uuid        REFERENCES auth.users (id) ON DELETE SET NULL,
+    accepted_at         timestamptz,
+    accepted_by         uuid        REFERENCES auth.users (id) ON DELETE SET NULL,
+    CHECK (email IS NOT NULL OR external_identifier IS NOT NULL)
+);
+
+CREATE TABLE IF NOT EXISTS auth.invitation_permissions (
+    invitation_id uuid              NOT NULL REFERENCES auth.invitations (id) ON DELETE CASCADE,
+    service       uuid              NOT NULL REFERENCES auth.services (id) ON DELETE CASCADE,
+    level         auth.scope_level  NOT NULL,
+    PRIMARY KEY (invitation_id, service, level)
+);
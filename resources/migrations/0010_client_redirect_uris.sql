@@ -0,0 +1,4 @@
+-- clients using the authorization code flow register the uris the users are
+-- sent back to after logging in
+ALTER TABLE auth.clients
+    ADD COLUMN IF NOT EXISTS redirect_uris text[];
@@ -0,0 +1,6 @@
+-- client secrets are signed and encrypted with the keys of the generation
+-- active during the registration of the client. The generation is kept as
+-- long as it is referenced by a client. Clients registered before the column
+-- has been added keep every generation
+ALTER TABLE auth.clients
+    ADD COLUMN IF NOT EXISTS key_generation uuid REFERENCES auth.key_generations (id);
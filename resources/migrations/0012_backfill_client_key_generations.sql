@@ -0,0 +1,19 @@
+-- clients registered before the key generation has been stored alongside the
+-- client received secrets issued with the keys used before the introduction
+-- of key generations. These keys are imported as the first generation, so
+-- the clients are assigned to the oldest generation. As the keys are
+-- imported after the migrations ran, the backfill is repeated on the next
+-- start if no generation exists yet
+UPDATE auth.clients
+SET
+    key_generation = (
+        SELECT
+            id
+        FROM
+            auth.key_generations
+        ORDER BY
+            created_at
+        LIMIT 1
+    )
+WHERE
+    key_generation IS NULL;
@@ -0,0 +1,5 @@
+-- users provisioned or changed by an identity management system using SCIM
+-- are managed by it. Their profile is not synchronized with the external
+-- provider during the login
+ALTER TABLE auth.users
+    ADD COLUMN IF NOT EXISTS scim_managed boolean NOT NULL DEFAULT false;
@@ -21,11 +21,146 @@ SELECT
 FROM
     auth.users;
 
+-- name: get-users-page-ascending
+SELECT
+    u.*
+FROM
+    auth.users u
+WHERE
+    ($1::text IS NULL OR u.name ILIKE $1 OR u.username ILIKE $1 OR u.email ILIKE $1)
+    AND ($2::boolean IS NULL OR u.disabled = $2)
+    AND ($3::boolean IS NULL OR u.is_admin = $3)
+    AND (
+        $4::text IS NULL
+        OR u.is_admin IS TRUE
+        OR EXISTS (
+            SELECT
+                1
+            FROM
+                auth.permission_assignments pa
+                JOIN auth.services s ON s.id = pa.service
+            WHERE
+                pa.user_id = u.id
+                AND s.name = $4
+                AND ($5::text IS NULL OR pa.level = $5)
+        )
+    )
+    AND (
+        $7::text IS NULL
+        OR (CASE $6::text WHEN 'username' THEN u.username WHEN 'email' THEN u.email ELSE u.name END, u.id) > ($7, $8::uuid)
+    )
+ORDER BY
+    CASE $6::text WHEN 'username' THEN u.username WHEN 'email' THEN u.email ELSE u.name END ASC,
+    u.id ASC
+LIMIT
+    $9;
+
+-- name: get-users-page-descending
+SELECT
+    u.*
+FROM
+    auth.users u
+WHERE
+    ($1::text IS NULL OR u.name ILIKE $1 OR u.username ILIKE $1 OR u.email ILIKE $1)
+    AND ($2::boolean IS NULL OR u.disabled = $2)
+    AND ($3::boolean IS NULL OR u.is_admin = $3)
+    AND (
+        $4::text IS NULL
+        OR u.is_admin IS TRUE
+        OR EXISTS (
+            SELECT
+                1
+            FROM
+                auth.permission_assignments pa
+                JOIN auth.services s ON s.id = pa.service
+            WHERE
+                pa.user_id = u.id
+                AND s.name = $4
+                AND ($5::text IS NULL OR pa.level = $5)
+        )
+    )
+    AND (
+        $7::text IS NULL
+        OR (CASE $6::text WHEN 'username' THEN u.username WHEN 'email' THEN u.email ELSE u.name END, u.id) < ($7, $8::uuid)
+    )
+ORDER BY
+    CASE $6::text WHEN 'username' THEN u.username WHEN 'email' THEN u.email ELSE u.name END DESC,
+    u.id DESC
+LIMIT
+    $9;
+
+-- name: get-users-permissions
+SELECT
+    pa.user_id,
+    s.name,
+    pa.level
+FROM
+    auth.permission_assignments pa
+    JOIN auth.services s ON s.id = pa.service
+WHERE
+    pa.user_id = ANY($1::uuid[]);
+
 -- name: create-user
 INSERT INTO
-    auth.users (external_identifier, name, username, email)
+    auth.users (external_identifier, name, username, email, last_synced_at)
 VALUES
-    ($1, $2, $3, $4);
+    ($1, $2, $3, $4, NOW())
+RETURNING
+    *;
+
+-- name: sync-user-profile
+UPDATE auth.users
+SET
+    name = $2,
+    username = $3,
+    email = $4,
+    last_synced_at = NOW()
+WHERE
+    id = $1::uuid
+RETURNING
+    *;
+
+-- name: lock-user
+SELECT
+    *
+FROM
+    auth.users
+WHERE
+    id = $1::uuid
+FOR UPDATE;
+
+-- name: lock-active-administrators
+SELECT
+    id
+FROM
+    auth.users
+WHERE
+    is_admin IS TRUE
+    AND disabled IS FALSE
+FOR UPDATE;
+
+-- name: update-user
+UPDATE auth.users
+SET
+    name = $2,
+    username = $3,
+    email = $4,
+    disabled = $5,
+    is_admin = $6,
+    external_identifier = $7,
+    scim_managed = $8
+WHERE
+    id = $1::uuid
+RETURNING
+    *;
+
+-- name: create-provisioned-user
+INSERT INTO
+    auth.users (external_identifier, name, username, email, disabled, scim_managed)
+VALUES
+    ($1, $2, $3, $4, $5, true)
+RETURNING
+    *;
 
 -- name: delete-user
 DELETE FROM auth.users
@@ -49,9 +184,9 @@ WHERE
 
 -- name: create-client
 INSERT INTO
-    auth.clients (name, contact_name, contact_email)
+    auth.clients (name, contact_name, contact_email, scopes, jwks, jwks_uri, id_token_signed_response_alg, access_token_signed_response_alg, redirect_uris, key_generation)
 VALUES
-    ($1, $2, $3)
+    ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''), $9, $10::uuid)
 RETURNING
     id;
 
@@ -74,11 +209,34 @@ SELECT
             AND expires_at > NOW()
     );
 
+-- name: get-refresh-token
+SELECT
+    id,
+    active,
+    expires_at,
+    family_id
+FROM
+    auth.refresh_tokens
+WHERE
+    id = $1;
+
 -- name: register-refresh-token
 INSERT INTO
-    auth.refresh_tokens (id, active, expires_at)
+    auth.refresh_tokens (id, active, expires_at, family_id, user_id, client_id, ip, user_agent)
 VALUES
-    ($1, TRUE, $2);
+    ($1, TRUE, $2, $3::uuid, $4::uuid, NULLIF($5, ''), NULLIF($6, '')::inet, NULLIF($7, ''));
+
+-- name: consume-refresh-token
+UPDATE auth.refresh_tokens
+SET
+    active = FALSE,
+    last_used_at = NOW()
+WHERE
+    id = $1
+    AND active IS TRUE
+    AND expires_at > NOW()
+RETURNING
+    family_id;
 
 -- name: revoke-refresh-token
 UPDATE auth.refresh_tokens
@@ -87,11 +245,55 @@ SET
 WHERE
     id = $1;
 
+-- name: revoke-refresh-token-family
+UPDATE auth.refresh_tokens
+SET
+    active = FALSE
+WHERE
+    family_id = $1::uuid;
+
+-- name: get-user-sessions
+SELECT
+    family_id AS id,
+    (ARRAY_AGG(client_id ORDER BY created_at DESC))[1] AS client_id,
+    MIN(created_at) AS created_at,
+    MAX(COALESCE(last_used_at, created_at)) AS last_used_at,
+    (ARRAY_AGG(HOST(ip) ORDER BY created_at DESC))[1] AS ip,
+    (ARRAY_AGG(user_agent ORDER BY created_at DESC))[1] AS user_agent,
+    MAX(expires_at) AS expires_at
+FROM
+    auth.refresh_tokens
+WHERE
+    user_id = $1::uuid
+    AND family_id IS NOT NULL
+GROUP BY
+    family_id
+HAVING
+    BOOL_OR(active IS TRUE AND expires_at > NOW())
+ORDER BY
+    last_used_at DESC;
+
+-- name: revoke-user-session
+UPDATE auth.refresh_tokens
+SET
+    active = FALSE
+WHERE
+    user_id = $1::uuid
+    AND family_id = $2::uuid
+    AND active IS TRUE;
+
+-- name: revoke-user-refresh-tokens
+UPDATE auth.refresh_tokens
+SET
+    active = FALSE
+WHERE
+    user_id = $1::uuid
+    AND active IS TRUE;
+
 -- name: cleanup-expired-tokens
 DELETE FROM auth.refresh_tokens
 WHERE
-    expires_at < NOW()
-    OR active IS NOT TRUE;
+    expires_at < NOW();
 
 -- SERVICE RELATED QUERIES --
 -- name: get-services
@@ -121,16 +323,6 @@ LIMIT
     1;
 
 -- PERMISSION RELATED QUERIES --
--- name: get-user-permissions
-SELECT
-    s.name,
-    level
-FROM
-    auth.permission_assignments
-    JOIN auth.services s ON s.id = permission_assignments.service
-WHERE
-    user_id = $1;
-
 -- name: assign-permission
 INSERT INTO
     auth.permission_assignments (user_id, service, level)
@@ -143,4 +335,154 @@ DELETE FROM auth.permission_assignments
 WHERE
     user_id = $1::uuid
     AND service = $2::uuid
-    AND level = $3::auth.scope_level;
\ No newline at end of file
+    AND level = $3::auth.scope_level;
+
+-- name: get-permission-members
+SELECT
+    pa.service,
+    pa.level,
+    u.id,
+    u.username
+FROM
+    auth.permission_assignments pa
+    JOIN auth.users u ON u.id = pa.user_id
+ORDER BY
+    u.username,
+    u.id;
+
+-- name: remove-permission-members
+DELETE FROM auth.permission_assignments
+WHERE
+    service = $1::uuid
+    AND level = $2::auth.scope_level;
+
+-- INVITATION-RELATED QUERIES --
+-- name: get-pending-invitations
+SELECT
+    i.id,
+    i.email,
+    i.external_identifier,
+    i.expires_at,
+    i.created_at,
+    i.created_by,
+    COALESCE(
+        (
+            SELECT
+                jsonb_object_agg(p.name, p.levels)
+            FROM
+                (
+                    SELECT
+                        s.name,
+                        jsonb_agg(ip.level ORDER BY ip.level) AS levels
+                    FROM
+                        auth.invitation_permissions ip
+                        JOIN auth.services s ON s.id = ip.service
+                    WHERE
+                        ip.invitation_id = i.id
+                    GROUP BY
+                        s.name
+                ) p
+        ),
+        '{}'::jsonb
+    ) AS permissions
+FROM
+    auth.invitations i
+WHERE
+    i.accepted_at IS NULL
+    AND i.expires_at > NOW()
+    AND ($1::uuid IS NULL OR i.id = $1::uuid)
+ORDER BY
+    i.created_at,
+    i.id;
+
+-- name: user-exists
+SELECT
+    EXISTS (
+        SELECT
+            1
+        FROM
+            auth.users
+        WHERE
+            external_identifier = $1
+            OR lower(email) = lower($2)
+    );
+
+-- name: create-invitation
+INSERT INTO
+    auth.invitations (email, external_identifier, expires_at, created_by)
+VALUES
+    ($1, $2, $3, $4::uuid)
+RETURNING
+    id;
+
+-- name: add-invitation-permission
+INSERT INTO
+    auth.invitation_permissions (invitation_id, service, level)
+VALUES
+    ($1::uuid, $2::uuid, $3::auth.scope_level) ON CONFLICT
+DO NOTHING;
+
+-- name: revoke-invitation
+DELETE FROM auth.invitations
+WHERE
+    id = $1::uuid
+    AND accepted_at IS NULL;
+
+-- name: accept-invitations
+WITH
+    accepted AS (
+        UPDATE auth.invitations
+        SET
+            accepted_at = NOW(),
+            accepted_by = $1::uuid
+        WHERE
+            accepted_at IS NULL
+            AND expires_at > NOW()
+            AND (
+                external_identifier = $2
+                OR (
+                    $4::boolean
+                    AND lower(email) = lower($3)
+                )
+            )
+        RETURNING
+            id
+    )
+INSERT INTO
+    auth.permission_assignments (user_id, service, level)
+SELECT DISTINCT
+    $1::uuid,
+    ip.service,
+    ip.level
+FROM
+    auth.invitation_permissions ip
+    JOIN accepted a ON a.id = ip.invitation_id ON CONFLICT
+DO NOTHING;
+
+-- KEY-RELATED QUERIES --
+-- name: get-key-generations
+SELECT
+    *
+FROM
+    auth.key_generations;
+
+-- name: store-key-generation
+INSERT INTO
+    auth.key_generations (id, status, created_at, activated_at, retired_at, signing_keys, encryption_key)
+VALUES
+    ($1::uuid, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (id) DO UPDATE SET
+    status = EXCLUDED.status,
+    activated_at = EXCLUDED.activated_at,
+    retired_at = EXCLUDED.retired_at;
+
+-- name: delete-other-key-generations
+DELETE FROM auth.key_generations
+WHERE
+    id <> ALL ($1::uuid[]);
+
+-- name: get-client-key-generations
+SELECT
+    key_generation
+FROM
+    auth.clients;
@@ -0,0 +1,94 @@
+package revocation
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/redis/go-redis/v9"
+)
+
+// KeyPrefix is used for the keys storing the identifiers of revoked access
+// tokens in redis. The keys expire together with the access token
+const KeyPrefix = "revoked-access-token:"
+
+// Channel is the redis channel the identifiers of revoked access tokens are
+// published on. Services may subscribe to the channel to keep a local copy of
+// the deny list
+const Channel = "revoked-access-tokens"
+
+// DenyList stores the identifiers of revoked access tokens in redis until the
+// access tokens expire.
+// To protect routes of a gin router, mount the GinHandler in front of the
+// JWT validator
+type DenyList struct {
+	Redis *redis.Client
+}
+
+// Revoke adds the access token to the deny list and publishes its identifier
+// on the Channel. Already expired access tokens are ignored
+func (l DenyList) Revoke(ctx context.Context, token jwt.Token) error {
+	if token.JwtID() == "" {
+		return errMissingTokenID
+	}
+
+	ttl := time.Until(token.Expiration())
+	if ttl <= 0 {
+		return nil
+	}
+
+	_, err := l.Redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, KeyPrefix+token.JwtID(), true, ttl)
+		pipe.Publish(ctx, Channel, token.JwtID())
+		return nil
+	})
+	return err
+}
+
+// IsRevoked checks if the access token with the supplied identifier has been
+// revoked
+func (l DenyList) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	count, err := l.Redis.Exists(ctx, KeyPrefix+tokenID).Result()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// GinHandler rejects requests using a revoked access token.
+// The signature of the token is not verified as this is the responsibility of
+// the JWT validator
+func (l DenyList) GinHandler(c *gin.Context) {
+	header := strings.TrimSpace(c.GetHeader("Authorization"))
+	scheme, accessToken, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		c.Next()
+		return
+	}
+
+	token, err := jwt.ParseInsecure([]byte(strings.TrimSpace(accessToken)))
+	if err != nil || token.JwtID() == "" {
+		c.Next()
+		return
+	}
+
+	revoked, err := l.IsRevoked(c, token.JwtID())
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	if revoked {
+		c.Abort()
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		res := ErrTokenRevoked
+		res.Errors = []error{errTokenRevoked}
+		res.Emit(c)
+		return
+	}
+
+	c.Next()
+}
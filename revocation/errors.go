@@ -0,0 +1,20 @@
+package revocation
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/wisdom-oss/common-go/v2/types"
+)
+
+var (
+	errTokenRevoked   = errors.New("access token has been revoked")
+	errMissingTokenID = errors.New("access token does not contain a token id")
+)
+
+var ErrTokenRevoked = types.ServiceError{
+	Type:   "https://www.rfc-editor.org/rfc/rfc6750#section-3.1",
+	Status: http.StatusUnauthorized,
+	Title:  "Access Token Revoked",
+	Detail: "The access token has been revoked. Please request a new access token",
+}
@@ -0,0 +1,122 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/v2/jwt"
+
+	"microservice/types"
+)
+
+// UpstreamClaimPrefix marks claims which are copied from the ID token issued
+// by the external provider into the access token
+const UpstreamClaimPrefix = "upstream:"
+
+// AccessTokenClaims configures which identity claims are added to access
+// tokens issued for an audience.
+// The claims of all audiences of an access token are added to it
+var AccessTokenClaims = map[string][]string{}
+
+// userClaims contains the claims which may be read from the user
+var userClaims = map[string]func(user *types.User) any{
+	"name":               func(user *types.User) any { return user.Name },
+	"preferred_username": func(user *types.User) any { return user.Username },
+	"email":              func(user *types.User) any { return user.Email },
+	"administrator":      func(user *types.User) any { return user.Administrator },
+}
+
+// reservedClaims may not be configured as they are set by the service itself
+var reservedClaims = []string{
+	"iss", "sub", "aud", "exp", "nbf", "iat", "jti", "typ", "scopes", "client_id", "azp", "act", "cnf",
+	"auth_time", "openid_scopes", "upstream_claims",
+}
+
+// ParseAccessTokenClaims reads the claim configuration from a JSON object
+// mapping the audiences to the list of claims added for them.
+// As the claims of all audiences are added to an access token, a claim name
+// may only be used by either a user claim or an upstream claim
+func ParseAccessTokenClaims(rawConfiguration string) (map[string][]string, error) {
+	var configuration map[string][]string
+	err := json.Unmarshal([]byte(rawConfiguration), &configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make(map[string]string)
+	for audience, claims := range configuration {
+		for _, claim := range claims {
+			name, isUpstreamClaim := strings.CutPrefix(claim, UpstreamClaimPrefix)
+			if slices.Contains(reservedClaims, name) {
+				return nil, fmt.Errorf("claim %s configured for %s is reserved", name, audience)
+			}
+			if source, configured := sources[name]; configured && source != claim {
+				return nil, fmt.Errorf("claim %s configured for %s is already configured as %s", claim, audience, source)
+			}
+			sources[name] = claim
+			if isUpstreamClaim {
+				if name == "" {
+					return nil, fmt.Errorf("empty upstream claim configured for %s", audience)
+				}
+				continue
+			}
+			if _, supported := userClaims[claim]; !supported {
+				return nil, fmt.Errorf("unsupported claim %s configured for %s", claim, audience)
+			}
+		}
+	}
+	return configuration, nil
+}
+
+// configuredClaims returns the claims configured for the audiences
+func configuredClaims(audiences []string) []string {
+	var claims []string
+	for _, audience := range audiences {
+		claims = append(claims, AccessTokenClaims[audience]...)
+	}
+	slices.Sort(claims)
+	return slices.Compact(claims)
+}
+
+// extractUpstreamClaims reads the claims configured to be copied into access
+// tokens from the ID token issued by the external provider.
+// The ID token has already been verified during the login
+func extractUpstreamClaims(rawIDToken string) (map[string]any, error) {
+	idToken, err := jwt.ParseInsecure([]byte(rawIDToken))
+	if err != nil {
+		return nil, err
+	}
+
+	var audiences []string
+	for audience := range AccessTokenClaims {
+		audiences = append(audiences, audience)
+	}
+
+	upstreamClaims := make(map[string]any)
+	for _, claim := range configuredClaims(audiences) {
+		name, isUpstreamClaim := strings.CutPrefix(claim, UpstreamClaimPrefix)
+		if !isUpstreamClaim {
+			continue
+		}
+		if value, set := idToken.Get(name); set {
+			upstreamClaims[name] = value
+		}
+	}
+	return upstreamClaims, nil
+}
+
+// addIdentityClaims adds the identity claims configured for the audiences to
+// the access token
+func addIdentityClaims(builder *jwt.Builder, user *types.User, audiences []string, upstreamClaims map[string]any) {
+	for _, claim := range configuredClaims(audiences) {
+		if name, isUpstreamClaim := strings.CutPrefix(claim, UpstreamClaimPrefix); isUpstreamClaim {
+			if value, set := upstreamClaims[name]; set {
+				builder.Claim(name, value)
+			}
+			continue
+		}
+		builder.Claim(claim, userClaims[claim](user))
+	}
+}
@@ -0,0 +1,100 @@
+package routes
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+
+	"microservice/types"
+)
+
+func TestParseAccessTokenClaims(t *testing.T) {
+	configuration, err := ParseAccessTokenClaims(`{
+		"example-service": ["name", "email", "upstream:groups"],
+		"other-service": ["email", "upstream:groups", "administrator"]
+	}`)
+	if err != nil {
+		t.Fatalf("valid configuration rejected: %v", err)
+	}
+
+	expected := map[string][]string{
+		"example-service": {"name", "email", "upstream:groups"},
+		"other-service":   {"email", "upstream:groups", "administrator"},
+	}
+	if !maps.EqualFunc(configuration, expected, slices.Equal) {
+		t.Errorf("expected %v, got %v", expected, configuration)
+	}
+}
+
+func TestParseAccessTokenClaimsRejectsInvalidConfigurations(t *testing.T) {
+	tests := map[string]string{
+		"invalidJSON":        `["name"]`,
+		"reservedClaim":      `{"example-service": ["sub"]}`,
+		"reservedUpstream":   `{"example-service": ["upstream:scopes"]}`,
+		"unsupportedClaim":   `{"example-service": ["phone_number"]}`,
+		"emptyUpstreamClaim": `{"example-service": ["upstream:"]}`,
+		"conflictingSources": `{"example-service": ["email"], "other-service": ["upstream:email"]}`,
+	}
+	for name, rawConfiguration := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseAccessTokenClaims(rawConfiguration); err == nil {
+				t.Error("invalid configuration accepted")
+			}
+		})
+	}
+}
+
+func TestAddIdentityClaims(t *testing.T) {
+	previousClaims := AccessTokenClaims
+	t.Cleanup(func() { AccessTokenClaims = previousClaims })
+	AccessTokenClaims = map[string][]string{
+		"example-service": {"name", "upstream:groups", "upstream:department"},
+		"other-service":   {"name", "administrator"},
+		"unused-service":  {"email", "upstream:locale"},
+	}
+
+	idTokenBuilder := jwt.NewBuilder().
+		Subject("upstream-subject").
+		Claim("groups", []string{"staff"}).
+		Claim("locale", "de").
+		Claim("phone_number", "+49 123")
+	idToken, err := idTokenBuilder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawIDToken, err := jwt.Sign(idToken, jwt.WithKey(jwa.HS256, []byte("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upstreamClaims, err := extractUpstreamClaims(string(rawIDToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := slices.Sorted(maps.Keys(upstreamClaims)); !slices.Equal(keys, []string{"groups", "locale"}) {
+		t.Errorf("unexpected upstream claims %v", keys)
+	}
+
+	user := &types.User{Name: "Jane Doe", Email: "jane@example.com", Administrator: true}
+	builder := jwt.NewBuilder()
+	addIdentityClaims(builder, user, []string{"example-service", "other-service"}, upstreamClaims)
+	token, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := token.AsMap(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := slices.Sorted(maps.Keys(claims)); !slices.Equal(keys, []string{"administrator", "groups", "name"}) {
+		t.Errorf("unexpected claims %v", keys)
+	}
+	if claims["name"] != "Jane Doe" || claims["administrator"] != true {
+		t.Errorf("unexpected user claims %v", claims)
+	}
+}
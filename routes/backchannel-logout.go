@@ -0,0 +1,203 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jws"
+
+	"microservice/internal/db"
+	apiErrors "microservice/internal/errors"
+	"microservice/oidc"
+	"microservice/types"
+	"microservice/utils"
+)
+
+// BackChannelLogoutEvent is the event contained in every logout token
+const BackChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// LogoutTokenType is the type logout tokens need to declare in their header.
+// It prevents other tokens issued by the external provider, e.g. ID tokens,
+// from being accepted as logout tokens
+const LogoutTokenType = "logout+jwt"
+
+// logoutTokenKeyPrefix is used for the keys storing the identifiers of
+// already processed logout tokens in redis
+const logoutTokenKeyPrefix = "backchannel-logout-jti:"
+
+// logoutTokenReplayWindow sets how long the identifiers of processed logout
+// tokens are kept
+const logoutTokenReplayWindow = time.Hour
+
+var (
+	errLogoutTokenType     = errors.New("logout token is not typed as logout+jwt")
+	errMissingLogoutEvent  = errors.New("logout token does not contain the back-channel logout event")
+	errLogoutTokenNonce    = errors.New("logout token must not contain a nonce")
+	errLogoutTokenSubject  = errors.New("logout token contains neither sub nor sid")
+	errLogoutTokenID       = errors.New("logout token does not contain a token id")
+	errLogoutTokenReplayed = errors.New("logout token has already been used")
+)
+
+// BackChannelLogout implements the receiving side of the OpenID Connect
+// Back-Channel Logout.
+// The external provider sends a signed logout token if a session of a user
+// ends at the provider. If the token names a user, every refresh token of the
+// user is revoked. Otherwise, the sessions started using the session named in
+// the token are ended
+func BackChannelLogout(c *gin.Context) {
+	var parameters struct {
+		LogoutToken string `form:"logout_token" binding:"required"`
+	}
+
+	if err := c.ShouldBind(&parameters); err != nil {
+		c.Abort()
+		res := apiErrors.ErrMissingParameter
+		res.Errors = []error{err}
+		apiErrors.EmitOAuth(c, res)
+		return
+	}
+
+	logoutToken, err := oidc.TokenVerifier.Verify(c, parameters.LogoutToken)
+	if err != nil {
+		c.Abort()
+		res := apiErrors.ErrInvalidLogoutToken
+		res.Errors = []error{err}
+		apiErrors.EmitOAuth(c, res)
+		return
+	}
+
+	err = validateLogoutTokenType(parameters.LogoutToken)
+	if err != nil {
+		c.Abort()
+		res := apiErrors.ErrInvalidLogoutToken
+		res.Errors = []error{err}
+		apiErrors.EmitOAuth(c, res)
+		return
+	}
+
+	var claims struct {
+		SessionID string         `json:"sid"`
+		TokenID   string         `json:"jti"`
+		Nonce     *string        `json:"nonce"`
+		Events    map[string]any `json:"events"`
+	}
+	err = logoutToken.Claims(&claims)
+	if err == nil {
+		err = validateLogoutToken(logoutToken.Subject, claims.SessionID, claims.TokenID, claims.Nonce, claims.Events)
+	}
+	if err != nil {
+		c.Abort()
+		res := apiErrors.ErrInvalidLogoutToken
+		res.Errors = []error{err}
+		apiErrors.EmitOAuth(c, res)
+		return
+	}
+
+	firstUse, err := db.Redis.SetNX(c, logoutTokenKeyPrefix+claims.TokenID, true, logoutTokenReplayWindow).Result()
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+	if !firstUse {
+		c.Abort()
+		res := apiErrors.ErrInvalidLogoutToken
+		res.Errors = []error{errLogoutTokenReplayed}
+		apiErrors.EmitOAuth(c, res)
+		return
+	}
+
+	if logoutToken.Subject != "" {
+		err = revokeUpstreamUser(c, logoutToken.Subject)
+	} else {
+		err = revokeUpstreamSession(c, claims.SessionID)
+	}
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+}
+
+// validateLogoutTokenType checks that the logout token declares its type in
+// the typ header. The media type may be sent with or without the
+// application prefix (RFC 7515, Section 4.1.9)
+func validateLogoutTokenType(rawLogoutToken string) error {
+	message, err := jws.Parse([]byte(rawLogoutToken))
+	if err != nil {
+		return err
+	}
+	for _, signature := range message.Signatures() {
+		tokenType := strings.TrimPrefix(strings.ToLower(signature.ProtectedHeaders().Type()), "application/")
+		if tokenType != LogoutTokenType {
+			return errLogoutTokenType
+		}
+	}
+	return nil
+}
+
+// validateLogoutToken checks the claims required for logout tokens
+func validateLogoutToken(subject, sessionID, tokenID string, nonce *string, events map[string]any) error {
+	if _, set := events[BackChannelLogoutEvent]; !set {
+		return errMissingLogoutEvent
+	}
+	if nonce != nil {
+		return errLogoutTokenNonce
+	}
+	if subject == "" && sessionID == "" {
+		return errLogoutTokenSubject
+	}
+	if tokenID == "" {
+		return errLogoutTokenID
+	}
+	return nil
+}
+
+// revokeUpstreamUser revokes every refresh token of the user identified by
+// the external identifier. Unknown users are ignored
+func revokeUpstreamUser(c *gin.Context, externalIdentifier string) error {
+	user, err := utils.GetUser(types.ExternalIdentifier(externalIdentifier))
+	if err != nil {
+		if errors.Is(err, utils.ErrNoUser) {
+			return nil
+		}
+		return err
+	}
+
+	query, err := db.Queries.Raw("revoke-user-refresh-tokens")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Pool.Exec(c, query, user.GetID())
+	return err
+}
+
+// revokeUpstreamSession ends every session which has been started using the
+// session of the external provider
+func revokeUpstreamSession(c *gin.Context, upstreamSessionID string) error {
+	sessions, err := db.Redis.SMembers(c, upstreamSessionKeyPrefix+upstreamSessionID).Result()
+	if err != nil {
+		return err
+	}
+
+	query, err := db.Queries.Raw("revoke-refresh-token-family")
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		_, err = db.Pool.Exec(c, query, session)
+		if err != nil {
+			return err
+		}
+	}
+
+	return db.Redis.Del(c, upstreamSessionKeyPrefix+upstreamSessionID).Err()
+}
@@ -0,0 +1,87 @@
+package routes
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+
+	"microservice/internal/keys"
+)
+
+// signLogoutToken signs the token with the type set in the typ header.
+// An empty type omits the header
+func signLogoutToken(t *testing.T, tokenType string) string {
+	t.Helper()
+	headers := jws.NewHeaders()
+	if tokenType != "" {
+		if err := headers.Set(jws.TypeKey, tokenType); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token := buildToken(t, "https://idp.example.com", time.Now().Add(time.Minute))
+	signingKey := keys.SigningKey()
+	serializedToken, err := jwt.Sign(token, jwt.WithKey(signingKey.Algorithm(), signingKey, jws.WithProtectedHeaders(headers)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(serializedToken)
+}
+
+func TestValidateLogoutTokenType(t *testing.T) {
+	tests := map[string]struct {
+		tokenType string
+		err       error
+	}{
+		"logout type":        {"logout+jwt", nil},
+		"application prefix": {"application/logout+jwt", nil},
+		"mixed case":         {"Logout+JWT", nil},
+		"missing type":       {"", errLogoutTokenType},
+		"access token type":  {"at+jwt", errLogoutTokenType},
+		"generic jwt type":   {"JWT", errLogoutTokenType},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validateLogoutTokenType(signLogoutToken(t, test.tokenType))
+			if !errors.Is(err, test.err) {
+				t.Errorf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestValidateLogoutToken(t *testing.T) {
+	nonce := "nonce"
+	events := map[string]any{BackChannelLogoutEvent: map[string]any{}}
+
+	tests := map[string]struct {
+		subject   string
+		sessionID string
+		tokenID   string
+		nonce     *string
+		events    map[string]any
+		err       error
+	}{
+		"subject and session": {"subject", "session", "token", nil, events, nil},
+		"only subject":        {"subject", "", "token", nil, events, nil},
+		"only session":        {"", "session", "token", nil, events, nil},
+		"missing event":       {"subject", "session", "token", nil, map[string]any{"other": map[string]any{}}, errMissingLogoutEvent},
+		"missing events":      {"subject", "session", "token", nil, nil, errMissingLogoutEvent},
+		"nonce":               {"subject", "session", "token", &nonce, events, errLogoutTokenNonce},
+		"neither sub nor sid": {"", "", "token", nil, events, errLogoutTokenSubject},
+		"missing token id":    {"subject", "session", "", nil, events, errLogoutTokenID},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validateLogoutToken(test.subject, test.sessionID, test.tokenID, test.nonce, test.events)
+			if !errors.Is(err, test.err) {
+				t.Errorf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
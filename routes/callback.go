@@ -1,21 +1,118 @@
 package routes
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/thanhpk/randstr"
+
+	"microservice/internal/db"
+	"microservice/types"
 )
 
+// AuthorizationCodeLifetime sets how long a client may take to exchange an
+// authorization code for a token set
+const AuthorizationCodeLifetime = time.Minute
+
+// authorizationCodeKeyPrefix is used for the keys storing the issued
+// authorization codes in redis
+const authorizationCodeKeyPrefix = "authorization-code:"
+
+// Callback receives the authorization code from the external provider after
+// the user logged in.
+// The user is authenticated and sent back to the client together with an
+// authorization code issued by this service and the state supplied by the
+// client
 func Callback(c *gin.Context) {
 	var query struct {
-		Code  string `form:"code"`
-		State string `form:"state"`
+		Code             string `form:"code"`
+		State            string `form:"state"`
+		Error            string `form:"error"`
+		ErrorDescription string `form:"error_description"`
+	}
+	_ = c.ShouldBindQuery(&query)
+
+	if query.State == "" {
+		c.String(http.StatusBadRequest, "The login could not be associated with a client. Please restart the login")
+		return
 	}
 
-	if err := c.ShouldBindQuery(&query); err != nil {
+	params, err := db.Redis.GetDel(c, query.State).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			c.String(http.StatusBadRequest, "The login has expired. Please restart the login")
+			return
+		}
 		c.Abort()
 		_ = c.Error(err)
 		return
 	}
-	c.String(http.StatusSeeOther, `Please send a POST request to '/token?grant_type=authorization_code&code=%s&state=%s' to generate a token set`, query.Code, query.State)
+
+	var loginParams types.LoginParameters
+	_ = json.Unmarshal(params, &loginParams)
+	if loginParams.ClientRedirectUri == "" {
+		c.String(http.StatusBadRequest, "The login has not been started by a client")
+		return
+	}
+
+	response := url.Values{"state": {loginParams.State}}
+
+	// errors reported by the external provider, e.g. if the user denied the
+	// login, are passed on to the client
+	if query.Error != "" {
+		response.Set("error", query.Error)
+		response.Set("error_description", query.ErrorDescription)
+		redirectToClient(c, loginParams.ClientRedirectUri, response)
+		return
+	}
+	if query.Code == "" {
+		response.Set("error", "invalid_request")
+		response.Set("error_description", "the external provider did not issue an authorization code")
+		redirectToClient(c, loginParams.ClientRedirectUri, response)
+		return
+	}
+
+	login, err := authenticateUpstream(c, query.Code, query.State, loginParams)
+	if err != nil {
+		log.Error().Err(err).Msg("unable to authenticate user at the external provider")
+		response.Set("error", "server_error")
+		redirectToClient(c, loginParams.ClientRedirectUri, response)
+		return
+	}
+
+	if !login.user.IsActive() {
+		response.Set("error", "access_denied")
+		response.Set("error_description", "the user has been disabled")
+		redirectToClient(c, loginParams.ClientRedirectUri, response)
+		return
+	}
+
+	authorizationCode := types.AuthorizationCode{
+		ClientID:        loginParams.ClientID,
+		RedirectUri:     loginParams.ClientRedirectUri,
+		Scope:           loginParams.Scope,
+		Nonce:           loginParams.Nonce,
+		CodeChallenge:   loginParams.CodeChallenge,
+		UserID:          login.user.GetID(),
+		AuthTime:        upstreamAuthTime(login.idToken),
+		UpstreamIDToken: login.rawIDToken,
+	}
+
+	code := randstr.Base62(64)
+	encodedAuthorizationCode, _ := json.Marshal(authorizationCode)
+	err = db.Redis.Set(c, authorizationCodeKeyPrefix+code, encodedAuthorizationCode, AuthorizationCodeLifetime).Err()
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	response.Set("code", code)
+	redirectToClient(c, loginParams.ClientRedirectUri, response)
 }
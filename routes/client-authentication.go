@@ -0,0 +1,347 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+
+	"microservice/internal/db"
+	apiErrors "microservice/internal/errors"
+	"microservice/types"
+)
+
+// ClientAssertionTypeJWTBearer is the only supported client assertion type as
+// defined in RFC 7523
+const ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// ClientAuthenticationMethods contains the methods a client may use to
+// authenticate itself
+var ClientAuthenticationMethods = []string{"client_secret_basic", "client_secret_post", "private_key_jwt"}
+
+// MaxClientAssertionLifetime limits how far in the future a client assertion
+// may expire. This bounds the time the identifiers of used assertions need to
+// be stored
+const MaxClientAssertionLifetime = time.Hour
+
+// clientAssertionKeyPrefix is used for the keys storing the identifiers of
+// already used client assertions in redis
+const clientAssertionKeyPrefix = "client-assertion-jti:"
+
+var (
+	errUnsupportedAssertionType = errors.New("unsupported client assertion type")
+	errClientIDMismatch         = errors.New("client assertion not issued by the supplied client")
+	errNoClientKeys             = errors.New("client has not registered any public keys")
+	errInvalidAssertionAudience = errors.New("client assertion not intended for this service")
+	errAssertionLifetime        = errors.New("client assertion expires too far in the future")
+	errAssertionReplayed        = errors.New("client assertion has already been used")
+	errBasicClientIDMismatch    = errors.New("client id in body differs from authorization header")
+)
+
+// clientKeyCache caches the key sets of clients which registered the url of
+// their key set
+var clientKeyCache = jwk.NewCache(context.Background())
+
+// ClientCredentials contains the parameters a client may use to authenticate
+// itself. A client either uses its client secret or a signed assertion
+type ClientCredentials struct {
+	ClientID            string `json:"client_id" form:"client_id"`
+	ClientSecret        string `json:"client_secret" form:"client_secret"`
+	ClientAssertionType string `json:"client_assertion_type" form:"client_assertion_type"`
+	ClientAssertion     string `json:"client_assertion" form:"client_assertion"`
+}
+
+// readClientCredentials merges the client credentials sent in the
+// Authorization header using the Basic scheme into the credentials sent in the
+// request body.
+// As the credentials are form-urlencoded before being added to the header
+// (RFC 6749, Section 2.3.1), they are decoded before being used.
+// If the client used more than one authentication method, the matching error
+// is emitted, the context is aborted and false is returned
+func readClientCredentials(c *gin.Context, credentials *ClientCredentials) bool {
+	bodySecret := credentials.ClientSecret != ""
+	bodyAssertion := credentials.ClientAssertion != "" || credentials.ClientAssertionType != ""
+
+	encodedClientID, encodedClientSecret, basicAuthentication := c.Request.BasicAuth()
+	if !basicAuthentication {
+		if bodySecret && bodyAssertion {
+			c.Abort()
+			apiErrors.EmitOAuth(c, apiErrors.ErrMultipleClientAuthMethods)
+			return false
+		}
+		return true
+	}
+
+	if bodySecret || bodyAssertion {
+		c.Abort()
+		apiErrors.EmitOAuth(c, apiErrors.ErrMultipleClientAuthMethods)
+		return false
+	}
+
+	clientID, err := url.QueryUnescape(encodedClientID)
+	if err != nil {
+		c.Abort()
+		res := apiErrors.ErrInvalidClientCredentials
+		res.Errors = []error{err}
+		apiErrors.EmitOAuth(c, res)
+		return false
+	}
+
+	clientSecret, err := url.QueryUnescape(encodedClientSecret)
+	if err != nil {
+		c.Abort()
+		res := apiErrors.ErrInvalidClientCredentials
+		res.Errors = []error{err}
+		apiErrors.EmitOAuth(c, res)
+		return false
+	}
+
+	if credentials.ClientID != "" && credentials.ClientID != clientID {
+		c.Abort()
+		res := apiErrors.ErrInvalidClientCredentials
+		res.Errors = []error{errBasicClientIDMismatch}
+		apiErrors.EmitOAuth(c, res)
+		return false
+	}
+
+	credentials.ClientID = clientID
+	credentials.ClientSecret = clientSecret
+	return true
+}
+
+// hasClientCredentials reports if the client sent a secret or an assertion
+// to authenticate itself
+func hasClientCredentials(credentials ClientCredentials) bool {
+	return credentials.ClientSecret != "" || credentials.ClientAssertion != "" || credentials.ClientAssertionType != ""
+}
+
+// authenticateClient checks the supplied client credentials and returns the
+// authenticated client.
+// If the client could not be authenticated, the matching error is emitted,
+// the context is aborted and nil is returned
+func authenticateClient(c *gin.Context, credentials ClientCredentials) *types.Client {
+	if credentials.ClientAssertionType != "" || credentials.ClientAssertion != "" {
+		return authenticateClientAssertion(c, credentials)
+	}
+
+	clientID := strings.TrimSpace(credentials.ClientID)
+	clientSecret := strings.TrimSpace(credentials.ClientSecret)
+
+	if clientID == "" || clientSecret == "" {
+		c.Abort()
+		apiErrors.EmitOAuth(c, apiErrors.ErrMissingParameter)
+		return nil
+	}
+
+	client := getClient(c, clientID)
+	if client == nil {
+		return nil
+	}
+
+	err := client.ReadPermissions(clientID, clientSecret)
+	if err != nil {
+		c.Abort()
+		res := apiErrors.ErrInvalidClientCredentials
+		res.Errors = []error{err}
+		apiErrors.EmitOAuth(c, res)
+		return nil
+	}
+	return client
+}
+
+// authenticateClientAssertion authenticates a client using a JWT signed with
+// one of the keys the client registered as defined in RFC 7523.
+// The permissions of the client are read from the scopes stored for the
+// client
+func authenticateClientAssertion(c *gin.Context, credentials ClientCredentials) *types.Client {
+	assertion := strings.TrimSpace(credentials.ClientAssertion)
+	if assertion == "" {
+		c.Abort()
+		apiErrors.EmitOAuth(c, apiErrors.ErrMissingParameter)
+		return nil
+	}
+
+	if credentials.ClientAssertionType != ClientAssertionTypeJWTBearer {
+		c.Abort()
+		res := apiErrors.ErrInvalidClientCredentials
+		res.Errors = []error{errUnsupportedAssertionType}
+		apiErrors.EmitOAuth(c, res)
+		return nil
+	}
+
+	// the assertion is parsed without verification first to find out which
+	// client issued it and which keys need to be used for the verification
+	unverifiedAssertion, err := jwt.ParseInsecure([]byte(assertion))
+	if err != nil {
+		c.Abort()
+		res := apiErrors.ErrInvalidClientCredentials
+		res.Errors = []error{err}
+		apiErrors.EmitOAuth(c, res)
+		return nil
+	}
+
+	clientID := unverifiedAssertion.Subject()
+	if credentials.ClientID != "" && strings.TrimSpace(credentials.ClientID) != clientID {
+		c.Abort()
+		res := apiErrors.ErrInvalidClientCredentials
+		res.Errors = []error{errClientIDMismatch}
+		apiErrors.EmitOAuth(c, res)
+		return nil
+	}
+
+	client := getClient(c, clientID)
+	if client == nil {
+		return nil
+	}
+
+	keySet, err := clientKeySet(c, client)
+	if err != nil {
+		c.Abort()
+		res := apiErrors.ErrInvalidClientCredentials
+		res.Errors = []error{err}
+		apiErrors.EmitOAuth(c, res)
+		return nil
+	}
+
+	verifiedAssertion, err := jwt.Parse([]byte(assertion),
+		jwt.WithKeySet(keySet, jws.WithInferAlgorithmFromKey(true), jws.WithRequireKid(false)),
+		jwt.WithValidate(true),
+		jwt.WithIssuer(client.ID),
+		jwt.WithSubject(client.ID),
+		jwt.WithRequiredClaim(jwt.ExpirationKey),
+		jwt.WithRequiredClaim(jwt.JwtIDKey),
+		jwt.WithAcceptableSkew(30*time.Second),
+		jwt.WithValidator(assertionAudienceValidator(c)),
+	)
+	if err != nil {
+		c.Abort()
+		res := apiErrors.ErrInvalidClientCredentials
+		res.Errors = []error{err}
+		apiErrors.EmitOAuth(c, res)
+		return nil
+	}
+
+	if time.Until(verifiedAssertion.Expiration()) > MaxClientAssertionLifetime {
+		c.Abort()
+		res := apiErrors.ErrInvalidClientCredentials
+		res.Errors = []error{errAssertionLifetime}
+		apiErrors.EmitOAuth(c, res)
+		return nil
+	}
+
+	err = markAssertionUsed(c, client.ID, verifiedAssertion)
+	if errors.Is(err, errAssertionReplayed) {
+		c.Abort()
+		res := apiErrors.ErrInvalidClientCredentials
+		res.Errors = []error{err}
+		apiErrors.EmitOAuth(c, res)
+		return nil
+	}
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return nil
+	}
+
+	err = client.SetScopes(client.Scopes)
+	if err != nil {
+		c.Abort()
+		res := apiErrors.ErrInvalidClientCredentials
+		res.Errors = []error{err}
+		apiErrors.EmitOAuth(c, res)
+		return nil
+	}
+	return client
+}
+
+// markAssertionUsed keeps the identifier of the assertion until the assertion
+// expires to allow every assertion only to be used once
+func markAssertionUsed(ctx context.Context, clientID string, assertion jwt.Token) error {
+	replayKey := fmt.Sprintf("%s%s:%s", clientAssertionKeyPrefix, clientID, assertion.JwtID())
+	ttl := time.Until(assertion.Expiration()) + 30*time.Second
+	firstUse, err := db.Redis.SetNX(ctx, replayKey, true, ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !firstUse {
+		return errAssertionReplayed
+	}
+	return nil
+}
+
+// getClient loads the client from the database.
+// If the client does not exist, the invalid client credentials error is
+// emitted, the context is aborted and nil is returned
+func getClient(c *gin.Context, clientID string) *types.Client {
+	if err := uuid.Validate(clientID); err != nil {
+		c.Abort()
+		apiErrors.EmitOAuth(c, apiErrors.ErrInvalidClientCredentials)
+		return nil
+	}
+
+	query, err := db.Queries.Raw("get-client")
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return nil
+	}
+
+	var client types.Client
+	err = pgxscan.Get(c, db.Pool, &client, query, clientID)
+	if err != nil {
+		if pgxscan.NotFound(err) {
+			c.Abort()
+			apiErrors.EmitOAuth(c, apiErrors.ErrInvalidClientCredentials)
+			return nil
+		}
+		c.Abort()
+		_ = c.Error(err)
+		return nil
+	}
+	return &client
+}
+
+// clientKeySet returns the public keys registered by the client. Key sets
+// registered using an url are fetched and cached
+func clientKeySet(ctx context.Context, client *types.Client) (jwk.Set, error) {
+	if len(client.JWKS) > 0 {
+		return jwk.Parse(client.JWKS)
+	}
+
+	if client.JWKSUri == nil || *client.JWKSUri == "" {
+		return nil, errNoClientKeys
+	}
+
+	uri := *client.JWKSUri
+	if !clientKeyCache.IsRegistered(uri) {
+		err := clientKeyCache.Register(uri, jwk.WithMinRefreshInterval(15*time.Minute))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return clientKeyCache.Get(ctx, uri)
+}
+
+// assertionAudienceValidator accepts client assertions which name the token
+// endpoint or the issuer url as audience, as required by RFC 7523
+func assertionAudienceValidator(c *gin.Context) jwt.Validator {
+	acceptedAudiences := []string{issuerURL(c, "token"), strings.TrimSuffix(issuerURL(c, ""), "/")}
+	return jwt.ValidatorFunc(func(_ context.Context, token jwt.Token) jwt.ValidationError {
+		for _, audience := range token.Audience() {
+			if slices.Contains(acceptedAudiences, audience) {
+				return nil
+			}
+		}
+		return jwt.NewValidationError(errInvalidAssertionAudience)
+	})
+}
@@ -0,0 +1,166 @@
+package routes
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+
+	"microservice/internal/db"
+	"microservice/internal/redistest"
+)
+
+func TestAssertionAudienceValidator(t *testing.T) {
+	previousIssuerURL := IssuerURL
+	t.Cleanup(func() { IssuerURL = previousIssuerURL })
+
+	tests := map[string]struct {
+		issuerURL string
+		accepted  []string
+		rejected  []string
+	}{
+		"configuredIssuer": {
+			issuerURL: "https://example.com/api/auth/",
+			accepted:  []string{"https://example.com/api/auth/token", "https://example.com/api/auth"},
+			rejected:  []string{TokenIssuer, "https://service.internal/token", "https://example.com/api/auth/introspect"},
+		},
+		"derivedIssuer": {
+			accepted: []string{"https://service.internal/token", "https://service.internal"},
+			rejected: []string{TokenIssuer, "https://example.com/api/auth/token"},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			IssuerURL = test.issuerURL
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "https://service.internal/token", nil)
+			validator := assertionAudienceValidator(c)
+
+			for _, audience := range test.accepted {
+				assertion, err := jwt.NewBuilder().Audience([]string{"https://other.example", audience}).Build()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := validator.Validate(context.Background(), assertion); err != nil {
+					t.Errorf("audience %q rejected: %v", audience, err)
+				}
+			}
+			for _, audience := range test.rejected {
+				assertion, err := jwt.NewBuilder().Audience([]string{audience}).Build()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := validator.Validate(context.Background(), assertion); err == nil {
+					t.Errorf("audience %q accepted", audience)
+				}
+			}
+		})
+	}
+}
+
+func TestMarkAssertionUsed(t *testing.T) {
+	previousRedis := db.Redis
+	t.Cleanup(func() { db.Redis = previousRedis })
+	db.Redis = redistest.NewClient(t)
+
+	assertion, err := jwt.NewBuilder().JwtID("assertion-id").Expiration(time.Now().Add(time.Minute)).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := markAssertionUsed(context.Background(), "client", assertion); err != nil {
+		t.Fatalf("first use of the assertion rejected: %v", err)
+	}
+	if err := markAssertionUsed(context.Background(), "client", assertion); !errors.Is(err, errAssertionReplayed) {
+		t.Errorf("expected %v, got %v", errAssertionReplayed, err)
+	}
+
+	// the identifiers are chosen by the clients and may therefore be reused
+	// by other clients
+	if err := markAssertionUsed(context.Background(), "other-client", assertion); err != nil {
+		t.Errorf("assertion of another client rejected: %v", err)
+	}
+}
+
+func TestReadClientCredentials(t *testing.T) {
+	basicCredentials := "Basic " + base64.StdEncoding.EncodeToString([]byte("client%2Bid:secret%3Avalue"))
+
+	tests := map[string]struct {
+		authorization string
+		credentials   ClientCredentials
+		expected      ClientCredentials
+		errorCode     string
+	}{
+		"body": {
+			credentials: ClientCredentials{ClientID: "client", ClientSecret: "secret"},
+			expected:    ClientCredentials{ClientID: "client", ClientSecret: "secret"},
+		},
+		"basic": {
+			authorization: basicCredentials,
+			expected:      ClientCredentials{ClientID: "client+id", ClientSecret: "secret:value"},
+		},
+		"basicWithClientID": {
+			authorization: basicCredentials,
+			credentials:   ClientCredentials{ClientID: "client+id"},
+			expected:      ClientCredentials{ClientID: "client+id", ClientSecret: "secret:value"},
+		},
+		"basicWithOtherClientID": {
+			authorization: basicCredentials,
+			credentials:   ClientCredentials{ClientID: "other-client"},
+			errorCode:     "invalid_client",
+		},
+		"basicAndBodySecret": {
+			authorization: basicCredentials,
+			credentials:   ClientCredentials{ClientSecret: "secret"},
+			errorCode:     "invalid_request",
+		},
+		"basicAndAssertion": {
+			authorization: basicCredentials,
+			credentials:   ClientCredentials{ClientAssertionType: ClientAssertionTypeJWTBearer, ClientAssertion: "assertion"},
+			errorCode:     "invalid_request",
+		},
+		"secretAndAssertion": {
+			credentials: ClientCredentials{ClientSecret: "secret", ClientAssertion: "assertion"},
+			errorCode:   "invalid_request",
+		},
+		"invalidEncoding": {
+			authorization: "Basic " + base64.StdEncoding.EncodeToString([]byte("client%zz:secret")),
+			errorCode:     "invalid_client",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodPost, "/token", nil)
+			if test.authorization != "" {
+				c.Request.Header.Set("Authorization", test.authorization)
+			}
+
+			credentials := test.credentials
+			accepted := readClientCredentials(c, &credentials)
+			if test.errorCode != "" {
+				if accepted || !c.IsAborted() {
+					t.Fatal("invalid client credentials accepted")
+				}
+				if code := oauthErrorCode(t, recorder); code != test.errorCode {
+					t.Errorf("expected %q, got %q", test.errorCode, code)
+				}
+				return
+			}
+
+			if !accepted {
+				t.Fatalf("valid client credentials rejected: %s", recorder.Body.String())
+			}
+			if credentials != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, credentials)
+			}
+		})
+	}
+}
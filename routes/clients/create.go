@@ -1,19 +1,24 @@
 package clients
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"microservice/internal/db"
 	apiErrors "microservice/internal/errors"
-	"microservice/resources"
+	"microservice/internal/keys"
 	"microservice/types"
 	"microservice/utils"
 	"net/http"
+	"net/url"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/georgysavva/scany/v2/pgxscan"
 	"github.com/gin-gonic/gin"
 	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 )
 
@@ -23,6 +28,17 @@ func Create(c *gin.Context) {
 		ContactName  string   `json:"contactName" binding:"required"`
 		ContactEmail string   `json:"contactEMail" binding:"required"`
 		Scopes       []string `json:"scopes" binding:"required"`
+		// JWKS and JWKSUri optionally register public keys which allow the
+		// client to authenticate using signed assertions
+		JWKS    json.RawMessage `json:"jwks"`
+		JWKSUri string          `json:"jwksUri"`
+		// IDTokenSignedResponseAlg and AccessTokenSignedResponseAlg
+		// optionally select the algorithms used to sign the tokens
+		IDTokenSignedResponseAlg     string `json:"idTokenSignedResponseAlg"`
+		AccessTokenSignedResponseAlg string `json:"accessTokenSignedResponseAlg"`
+		// RedirectURIs optionally registers the uris the client receives
+		// authorization codes at
+		RedirectURIs []string `json:"redirectUris"`
 	}
 
 	err := c.BindJSON(&parameters)
@@ -40,6 +56,32 @@ func Create(c *gin.Context) {
 		return
 	}
 
+	jwks, jwksUri, err := validateClientKeys(parameters.JWKS, parameters.JWKSUri)
+	if err != nil {
+		c.Abort()
+		res := apiErrors.ErrInvalidClientKeys
+		res.Errors = []error{err}
+		res.Emit(c)
+		return
+	}
+
+	err = validateRedirectURIs(parameters.RedirectURIs)
+	if err != nil {
+		c.Abort()
+		res := apiErrors.ErrInvalidClientRedirectURIs
+		res.Errors = []error{err}
+		res.Emit(c)
+		return
+	}
+
+	for _, algorithm := range []string{parameters.IDTokenSignedResponseAlg, parameters.AccessTokenSignedResponseAlg} {
+		if algorithm != "" && !keys.IsSigningAlgorithmEnabled(jwa.SignatureAlgorithm(algorithm)) {
+			c.Abort()
+			apiErrors.ErrUnsupportedSigningAlgorithm.Emit(c)
+			return
+		}
+	}
+
 	userSubject := c.GetString("subject")
 	user, err := utils.GetUser(types.InternalIdentifier(userSubject))
 	if err != nil {
@@ -48,8 +90,15 @@ func Create(c *gin.Context) {
 		return
 	}
 
+	permissions, err := user.Permissions(c)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
 	userPermissions := make([]string, 0)
-	for system, scopes := range user.Permissions() {
+	for system, scopes := range permissions {
 		for _, scope := range scopes {
 			scopeString := fmt.Sprintf("%s:%s", system, scope)
 			userPermissions = append(userPermissions, scopeString)
@@ -89,6 +138,10 @@ func Create(c *gin.Context) {
 		}
 	}
 
+	// the secret is issued using the keys of the active generation, which is
+	// stored alongside the client to keep the keys until the client is deleted
+	keyGeneration, signingKey, encryptionKey := keys.ActiveKeys()
+
 	query, err = db.Queries.Raw("create-client")
 	if err != nil {
 		c.Abort()
@@ -97,7 +150,7 @@ func Create(c *gin.Context) {
 	}
 
 	var clientID string
-	err = pgxscan.Get(c, db.Pool, &clientID, query, parameters.Description, parameters.ContactName, parameters.ContactEmail)
+	err = pgxscan.Get(c, db.Pool, &clientID, query, parameters.Description, parameters.ContactName, parameters.ContactEmail, parameters.Scopes, jwks, jwksUri, parameters.IDTokenSignedResponseAlg, parameters.AccessTokenSignedResponseAlg, parameters.RedirectURIs, keyGeneration)
 	if err != nil {
 		c.Abort()
 		_ = c.Error(err)
@@ -119,8 +172,8 @@ func Create(c *gin.Context) {
 	}
 
 	s := jwt.NewSerializer()
-	s.Sign(jwt.WithKey(resources.PrivateSigningKey.Algorithm(), resources.PrivateSigningKey))
-	s.Encrypt(jwt.WithKey(jwa.ECDH_ES, resources.PublicEncryptionKey))
+	s.Sign(jwt.WithKey(signingKey.Algorithm(), signingKey))
+	s.Encrypt(jwt.WithKey(jwa.ECDH_ES, encryptionKey))
 
 	clientSecret, err := s.Serialize(clientToken)
 	if err != nil {
@@ -135,3 +188,64 @@ func Create(c *gin.Context) {
 	})
 
 }
+
+var errRelativeRedirectURI = errors.New("redirect uri is not absolute")
+var errRedirectURIFragment = errors.New("redirect uri contains a fragment")
+
+// validateRedirectURIs checks the redirect uris registered for a client as
+// required by RFC 6749, Section 3.1.2
+func validateRedirectURIs(redirectURIs []string) error {
+	for _, redirectURI := range redirectURIs {
+		parsedURI, err := url.Parse(redirectURI)
+		if err != nil {
+			return err
+		}
+		if !parsedURI.IsAbs() {
+			return errRelativeRedirectURI
+		}
+		if parsedURI.Fragment != "" || strings.Contains(redirectURI, "#") {
+			return errRedirectURIFragment
+		}
+	}
+	return nil
+}
+
+var errPrivateKeySupplied = errors.New("key set contains a private key")
+var errInsecureKeySetURL = errors.New("key set url does not use https")
+
+// validateClientKeys checks the key set or key set url registered for a
+// client and returns the values which are stored in the database.
+// Unset values are returned as nil
+func validateClientKeys(rawKeySet json.RawMessage, keySetURL string) ([]byte, *string, error) {
+	var jwks []byte
+	if len(rawKeySet) > 0 && string(rawKeySet) != "null" {
+		keySet, err := jwk.Parse(rawKeySet)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := 0; i < keySet.Len(); i++ {
+			key, _ := keySet.Key(i)
+			isPrivate, err := jwk.IsPrivateKey(key)
+			if err != nil {
+				return nil, nil, err
+			}
+			if isPrivate {
+				return nil, nil, errPrivateKeySupplied
+			}
+		}
+		jwks = rawKeySet
+	}
+
+	if keySetURL == "" {
+		return jwks, nil, nil
+	}
+
+	parsedURL, err := url.Parse(keySetURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if parsedURL.Scheme != "https" {
+		return nil, nil, errInsecureKeySetURL
+	}
+	return jwks, &keySetURL, nil
+}
@@ -0,0 +1,557 @@
+package routes
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/thanhpk/randstr"
+	"golang.org/x/oauth2"
+
+	"microservice/internal/db"
+	apiErrors "microservice/internal/errors"
+	"microservice/oidc"
+	"microservice/types"
+	"microservice/utils"
+)
+
+const GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+// DeviceCodeLifetime sets the time a device authorization request stays valid
+const DeviceCodeLifetime = 10 * time.Minute
+
+// DevicePollingInterval is the default minimal amount of seconds between two
+// polling requests of a device
+const DevicePollingInterval = 5
+
+// userCodeCharacters contains the characters used in user codes. It omits
+// vowels and easily confused characters as recommended by RFC 8628
+const userCodeCharacters = "BCDFGHJKLMNPQRSTVWXZ"
+
+const (
+	deviceCodeKeyPrefix            = "device-code:"
+	userCodeKeyPrefix              = "device-user-code:"
+	deviceConfirmationKeyPrefix    = "device-confirmation:"
+	devicePollKeyPrefix            = "device-poll:"
+	devicePollingIntervalKeyPrefix = "device-polling-interval:"
+)
+
+// deviceConfirmationCookie contains the confirmation token of the
+// confirmation page. It ties the confirmation to the browser the page has
+// been shown in, as the cookie is not sent with requests of other sites
+const deviceConfirmationCookie = "device_confirmation"
+
+// DeviceConfirmationLifetime sets how long the user may take to confirm a
+// device authorization request
+const DeviceConfirmationLifetime = 5 * time.Minute
+
+// verificationPage is shown to the user if the verification uri is opened
+// without a user code
+const verificationPage = `<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Device Login</title></head>
+<body>
+<form method="get">
+<label for="user_code">Please enter the code shown on your device</label>
+<input id="user_code" name="user_code" autocomplete="off" autofocus required>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>`
+
+// confirmationPage shows the client and the scopes requested by the device
+// and asks the user to confirm the request before logging in, as required by
+// RFC 8628, Section 5.4
+var confirmationPage = template.Must(template.New("confirmation").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Device Login</title></head>
+<body>
+<p><strong>{{.Client}}</strong> requests access to your account{{if .Scopes}} with the following scopes{{end}}.</p>
+{{if .Scopes}}<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>{{end}}
+<p>Only continue if you started the login on your device yourself and it shows the code <strong>{{.UserCode}}</strong>.</p>
+<form method="post">
+<input type="hidden" name="confirmation" value="{{.Confirmation}}">
+<button type="submit" name="decision" value="approve">Approve</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+</body>
+</html>`))
+
+// DeviceAuthorization starts a device authorization request as defined in
+// RFC 8628 and returns the device code and user code to the device.
+// Only registered clients may start a request. Clients sending credentials
+// are authenticated and need to authenticate again while polling
+func DeviceAuthorization(c *gin.Context) {
+	var parameters struct {
+		ClientCredentials
+		Scope string `form:"scope"`
+	}
+	if err := c.ShouldBind(&parameters); err != nil {
+		c.Abort()
+		res := apiErrors.ErrMissingParameter
+		res.Errors = []error{err}
+		apiErrors.EmitOAuth(c, res)
+		return
+	}
+
+	if !readClientCredentials(c, &parameters.ClientCredentials) {
+		return
+	}
+
+	var client *types.Client
+	authenticated := hasClientCredentials(parameters.ClientCredentials)
+	switch {
+	case authenticated:
+		client = authenticateClient(c, parameters.ClientCredentials)
+	case strings.TrimSpace(parameters.ClientID) == "":
+		c.Abort()
+		res := apiErrors.ErrMissingParameter
+		res.Errors = []error{errors.New("client_id is required")}
+		apiErrors.EmitOAuth(c, res)
+		return
+	default:
+		client = getClient(c, strings.TrimSpace(parameters.ClientID))
+	}
+	if client == nil {
+		return
+	}
+
+	deviceCode := randstr.Base62(64)
+	userCode := randstr.String(8, userCodeCharacters)
+
+	authorization := types.DeviceAuthorization{
+		UserCode:            userCode,
+		ClientID:            client.ID,
+		ClientAuthenticated: authenticated,
+		Scope:               parameters.Scope,
+		Status:              types.DeviceAuthorizationPending,
+	}
+
+	encodedAuthorization, _ := json.Marshal(authorization)
+	_, err := db.Redis.TxPipelined(c, func(pipe redis.Pipeliner) error {
+		pipe.Set(c, deviceCodeKeyPrefix+deviceCode, encodedAuthorization, DeviceCodeLifetime)
+		pipe.Set(c, userCodeKeyPrefix+userCode, deviceCode, DeviceCodeLifetime)
+		return nil
+	})
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	formattedUserCode := fmt.Sprintf("%s-%s", userCode[:4], userCode[4:])
+	verificationUri := issuerURL(c, "device")
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"device_code":               deviceCode,
+		"user_code":                 formattedUserCode,
+		"verification_uri":          verificationUri,
+		"verification_uri_complete": fmt.Sprintf("%s?user_code=%s", verificationUri, url.QueryEscape(formattedUserCode)),
+		"expires_in":                int(DeviceCodeLifetime.Seconds()),
+		"interval":                  DevicePollingInterval,
+	})
+}
+
+// DeviceVerification is opened by the user to approve a device authorization
+// request. After entering the user code, the client and the requested scopes
+// are shown and the user needs to confirm the request
+func DeviceVerification(c *gin.Context) {
+	var parameters struct {
+		UserCode string `form:"user_code"`
+	}
+	_ = c.ShouldBindQuery(&parameters)
+
+	userCode := normalizeUserCode(parameters.UserCode)
+	if userCode == "" {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(verificationPage))
+		return
+	}
+
+	deviceCode, err := db.Redis.Get(c, userCodeKeyPrefix+userCode).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			c.String(http.StatusBadRequest, "The code is unknown or has expired. Please restart the login on your device")
+			return
+		}
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	authorization, err := getDeviceAuthorization(c, deviceCode)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			c.String(http.StatusBadRequest, "The code has expired. Please restart the login on your device")
+			return
+		}
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	clientName := "An unregistered application"
+	client, err := registeredClient(c, authorization.ClientID)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+	if client != nil {
+		clientName = client.Name
+	}
+
+	confirmation := randstr.Base62(32)
+	err = db.Redis.Set(c, deviceConfirmationKeyPrefix+confirmation, deviceCode, DeviceConfirmationLifetime).Err()
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(deviceConfirmationCookie, confirmation, int(DeviceConfirmationLifetime.Seconds()), "", "", strings.HasPrefix(issuerURL(c, ""), "https://"), true)
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	err = confirmationPage.Execute(c.Writer, gin.H{
+		"Client":       clientName,
+		"Scopes":       strings.Fields(authorization.Scope),
+		"UserCode":     fmt.Sprintf("%s-%s", userCode[:4], userCode[4:]),
+		"Confirmation": confirmation,
+	})
+	if err != nil {
+		_ = c.Error(err)
+	}
+}
+
+// DeviceConfirmation receives the decision of the user about a device
+// authorization request. If the user approved the request, the user is
+// redirected to the external provider to log in
+func DeviceConfirmation(c *gin.Context) {
+	var parameters struct {
+		Confirmation string `form:"confirmation" binding:"required"`
+		Decision     string `form:"decision"`
+	}
+	if err := c.ShouldBind(&parameters); err != nil {
+		c.String(http.StatusBadRequest, "The confirmation is missing. Please open the link shown on your device again")
+		return
+	}
+
+	// the confirmation is only accepted from the browser the confirmation
+	// page has been shown in
+	confirmationCookie, err := c.Cookie(deviceConfirmationCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(confirmationCookie), []byte(parameters.Confirmation)) != 1 {
+		c.String(http.StatusBadRequest, "The confirmation is invalid. Please open the link shown on your device again")
+		return
+	}
+	c.SetCookie(deviceConfirmationCookie, "", -1, "", "", strings.HasPrefix(issuerURL(c, ""), "https://"), true)
+
+	deviceCode, err := db.Redis.GetDel(c, deviceConfirmationKeyPrefix+parameters.Confirmation).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			c.String(http.StatusBadRequest, "The confirmation has expired. Please open the link shown on your device again")
+			return
+		}
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	authorization, err := getDeviceAuthorization(c, deviceCode)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			c.String(http.StatusBadRequest, "The code has expired. Please restart the login on your device")
+			return
+		}
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+	if authorization.Status != types.DeviceAuthorizationPending {
+		c.String(http.StatusBadRequest, "The login of the device has already been completed")
+		return
+	}
+
+	if parameters.Decision != "approve" {
+		authorization.Status = types.DeviceAuthorizationDenied
+		err = storeDeviceAuthorization(c, deviceCode, authorization)
+		if err != nil {
+			c.Abort()
+			_ = c.Error(err)
+			return
+		}
+		_ = db.Redis.Del(c, userCodeKeyPrefix+authorization.UserCode).Err()
+		c.String(http.StatusOK, "The login of your device has been denied. You may close this window")
+		return
+	}
+
+	state := randstr.Base62(32)
+	loginParams := types.LoginParameters{
+		RedirectUri:  issuerURL(c, "device/callback"),
+		CodeVerifier: randstr.Base62(128),
+		DeviceCode:   deviceCode,
+	}
+
+	params, _ := json.Marshal(loginParams)
+	err = db.Redis.Set(c, state, params, 5*time.Minute).Err()
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, oidc.ExternalProvider.AuthCodeURL(state, oauth2.S256ChallengeOption(loginParams.CodeVerifier), oauth2.SetAuthURLParam("redirect_uri", loginParams.RedirectUri)))
+}
+
+// DeviceCallback receives the authorization code from the external provider
+// after the user logged in to approve a device authorization request and binds
+// the user to the request
+func DeviceCallback(c *gin.Context) {
+	var query struct {
+		Code  string `form:"code" binding:"required"`
+		State string `form:"state" binding:"required"`
+	}
+
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.Abort()
+		res := apiErrors.ErrMissingParameter
+		res.Errors = []error{err}
+		res.Emit(c)
+		return
+	}
+
+	params, err := db.Redis.GetDel(c, query.State).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			c.String(http.StatusBadRequest, "The login has expired. Please open the link shown on your device again")
+			return
+		}
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	var loginParams types.LoginParameters
+	_ = json.Unmarshal(params, &loginParams)
+	if loginParams.DeviceCode == "" {
+		c.String(http.StatusBadRequest, "The login has not been started for a device")
+		return
+	}
+
+	authorization, err := getDeviceAuthorization(c, loginParams.DeviceCode)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			c.String(http.StatusBadRequest, "The code has expired. Please restart the login on your device")
+			return
+		}
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	if authorization.Status != types.DeviceAuthorizationPending {
+		c.String(http.StatusBadRequest, "The login of the device has already been completed")
+		return
+	}
+
+	login, err := authenticateUpstream(c, query.Code, query.State, loginParams)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	if !login.user.IsActive() {
+		c.Abort()
+		apiErrors.ErrUserDisabled.Emit(c)
+		return
+	}
+
+	authorization.Status = types.DeviceAuthorizationApproved
+	authorization.UserID = login.user.GetID()
+	authorization.AuthTime = upstreamAuthTime(login.idToken)
+	authorization.UpstreamIDToken = login.rawIDToken
+
+	err = storeDeviceAuthorization(c, loginParams.DeviceCode, authorization)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	// the user code is not required anymore and is removed to prevent other
+	// users from approving the request again
+	_ = db.Redis.Del(c, userCodeKeyPrefix+authorization.UserCode).Err()
+
+	c.String(http.StatusOK, "Your device has been logged in. You may close this window and return to your device")
+}
+
+// normalizeUserCode removes the formatting from a user code entered by the
+// user
+func normalizeUserCode(userCode string) string {
+	userCode = strings.ToUpper(userCode)
+	userCode = strings.ReplaceAll(userCode, "-", "")
+	return strings.Join(strings.Fields(userCode), "")
+}
+
+func getDeviceAuthorization(c *gin.Context, deviceCode string) (*types.DeviceAuthorization, error) {
+	rawAuthorization, err := db.Redis.Get(c, deviceCodeKeyPrefix+deviceCode).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var authorization types.DeviceAuthorization
+	err = json.Unmarshal(rawAuthorization, &authorization)
+	if err != nil {
+		return nil, err
+	}
+	return &authorization, nil
+}
+
+// storeDeviceAuthorization updates the device authorization request while
+// keeping its expiry
+func storeDeviceAuthorization(c *gin.Context, deviceCode string, authorization *types.DeviceAuthorization) error {
+	encodedAuthorization, err := json.Marshal(authorization)
+	if err != nil {
+		return err
+	}
+	return db.Redis.SetArgs(c, deviceCodeKeyPrefix+deviceCode, encodedAuthorization, redis.SetArgs{KeepTTL: true, Mode: "XX"}).Err()
+}
+
+// throttleDevicePolling enforces the polling interval of the device and
+// reports if the device polled too fast. In that case, the interval is
+// increased by five seconds as required by RFC 8628, Section 3.5.
+// The polling state is kept apart from the device authorization request as
+// writing it could otherwise overwrite an approval received in the meantime
+func throttleDevicePolling(c *gin.Context, deviceCode string) (bool, error) {
+	interval, err := db.Redis.Get(c, devicePollingIntervalKeyPrefix+deviceCode).Int()
+	if errors.Is(err, redis.Nil) {
+		interval = DevicePollingInterval
+	} else if err != nil {
+		return false, err
+	}
+
+	// the key expires after the interval, so it only exists if the device
+	// polled within the interval
+	firstPoll, err := db.Redis.SetNX(c, devicePollKeyPrefix+deviceCode, true, time.Duration(interval)*time.Second).Result()
+	if err != nil {
+		return false, err
+	}
+	if firstPoll {
+		return false, nil
+	}
+
+	err = db.Redis.Set(c, devicePollingIntervalKeyPrefix+deviceCode, interval+5, DeviceCodeLifetime).Err()
+	return true, err
+}
+
+// exchangeDeviceCode handles the polling of a device for the result of a
+// device authorization request
+func exchangeDeviceCode(c *gin.Context, tokenRequest TokenRequest) *tokenGrant {
+	if strings.TrimSpace(tokenRequest.DeviceCode) == "" {
+		c.Abort()
+		apiErrors.EmitOAuth(c, apiErrors.ErrMissingParameter)
+		return nil
+	}
+
+	var client *types.Client
+	clientID := tokenRequest.ClientID
+	if hasClientCredentials(tokenRequest.ClientCredentials) {
+		client = authenticateClient(c, tokenRequest.ClientCredentials)
+		if client == nil {
+			return nil
+		}
+		clientID = client.ID
+	}
+
+	authorization, err := getDeviceAuthorization(c, tokenRequest.DeviceCode)
+	if err != nil {
+		c.Abort()
+		if errors.Is(err, redis.Nil) {
+			apiErrors.EmitOAuth(c, apiErrors.ErrExpiredDeviceCode)
+			return nil
+		}
+		_ = c.Error(err)
+		return nil
+	}
+
+	// the device code is bound to the client that requested it. Clients
+	// which authenticated while requesting it need to authenticate again
+	if authorization.ClientID != clientID {
+		c.Abort()
+		apiErrors.EmitOAuth(c, apiErrors.ErrExpiredDeviceCode)
+		return nil
+	}
+	if authorization.ClientAuthenticated && client == nil {
+		c.Abort()
+		apiErrors.EmitOAuth(c, apiErrors.ErrInvalidClientCredentials)
+		return nil
+	}
+
+	if authorization.Status == types.DeviceAuthorizationPending {
+		pollingTooFast, err := throttleDevicePolling(c, tokenRequest.DeviceCode)
+		if err != nil {
+			c.Abort()
+			_ = c.Error(err)
+			return nil
+		}
+
+		c.Abort()
+		if pollingTooFast {
+			apiErrors.EmitOAuth(c, apiErrors.ErrSlowDown)
+			return nil
+		}
+		apiErrors.EmitOAuth(c, apiErrors.ErrAuthorizationPending)
+		return nil
+	}
+
+	// the device code may only be used once
+	deleted, err := db.Redis.Del(c, deviceCodeKeyPrefix+tokenRequest.DeviceCode).Result()
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return nil
+	}
+	if deleted == 0 {
+		c.Abort()
+		apiErrors.EmitOAuth(c, apiErrors.ErrExpiredDeviceCode)
+		return nil
+	}
+
+	if authorization.Status == types.DeviceAuthorizationDenied {
+		c.Abort()
+		apiErrors.EmitOAuth(c, apiErrors.ErrDeviceAccessDenied)
+		return nil
+	}
+
+	user, err := utils.GetUser(types.InternalIdentifier(authorization.UserID))
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return nil
+	}
+
+	permissions, openIDScopes := splitScopes(strings.Fields(authorization.Scope))
+	grant := &tokenGrant{
+		subject:             user,
+		openIDScopes:        openIDScopes,
+		clientID:            authorization.ClientID,
+		authenticatedClient: client,
+		authTime:            authorization.AuthTime,
+		upstreamIDToken:     authorization.UpstreamIDToken,
+	}
+	if len(permissions) > 0 {
+		grant.scopes = permissions
+	}
+	return grant
+}
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"testing"
+)
+
+func TestNormalizeUserCode(t *testing.T) {
+	tests := map[string]string{
+		"BCDFGHJK":       "BCDFGHJK",
+		"BCDF-GHJK":      "BCDFGHJK",
+		"bcdf-ghjk":      "BCDFGHJK",
+		" bcdf ghjk\n":   "BCDFGHJK",
+		"BC-DF - GH\tJK": "BCDFGHJK",
+	}
+
+	for userCode, expected := range tests {
+		t.Run(userCode, func(t *testing.T) {
+			if normalized := normalizeUserCode(userCode); normalized != expected {
+				t.Errorf("expected %q, got %q", expected, normalized)
+			}
+		})
+	}
+}
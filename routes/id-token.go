@@ -0,0 +1,92 @@
+package routes
+
+import (
+	"slices"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/thanhpk/randstr"
+
+	"microservice/internal/keys"
+	"microservice/types"
+)
+
+// OpenIDScopes contains the scopes defined by OpenID Connect.
+// They do not grant any permissions but control if an ID token is issued and
+// which claims are contained in it
+var OpenIDScopes = []string{"openid", "profile", "email"}
+
+// DefaultIDTokenAudience is used as audience of an ID token if the login has
+// not been initiated by a specific client
+const DefaultIDTokenAudience = "wisdom"
+
+// IDTokenClaims contains the claims which may be contained in an ID token
+var IDTokenClaims = []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "azp", "sid", "name", "preferred_username", "email"}
+
+// splitScopes separates the OpenID Connect scopes from the scopes granting
+// permissions on services
+func splitScopes(scopes []string) (permissions []string, openIDScopes []string) {
+	for _, scope := range scopes {
+		if slices.Contains(OpenIDScopes, scope) {
+			openIDScopes = append(openIDScopes, scope)
+			continue
+		}
+		permissions = append(permissions, scope)
+	}
+	return permissions, openIDScopes
+}
+
+// buildIDToken creates a signed ID token for the user.
+// The profile information is only added if the matching scopes have been
+// granted
+func buildIDToken(user *types.User, grant *tokenGrant) (string, error) {
+	audience := grant.clientID
+	if audience == "" {
+		audience = DefaultIDTokenAudience
+	}
+
+	authTime := grant.authTime
+	if authTime.IsZero() {
+		authTime = time.Now()
+	}
+
+	builder := jwt.NewBuilder()
+	builder.Issuer(idTokenIssuer())
+	builder.Subject(user.GetID())
+	builder.Audience([]string{audience})
+	builder.Expiration(time.Now().Add(time.Minute * 15))
+	builder.IssuedAt(time.Now())
+	builder.JwtID(randstr.Base62(64))
+	builder.Claim("auth_time", authTime.Unix())
+	builder.Claim("azp", audience)
+	builder.Claim("sid", grant.family)
+
+	if grant.nonce != "" {
+		builder.Claim("nonce", grant.nonce)
+	}
+
+	if slices.Contains(grant.openIDScopes, "profile") {
+		builder.Claim("name", user.Name)
+		builder.Claim("preferred_username", user.Username)
+	}
+
+	if slices.Contains(grant.openIDScopes, "email") {
+		builder.Claim("email", user.Email)
+	}
+
+	idToken, err := builder.Build()
+	if err != nil {
+		return "", err
+	}
+
+	signingKey, err := keys.SigningKeyFor(grant.idTokenAlgorithm)
+	if err != nil {
+		return "", err
+	}
+
+	serializedToken, err := jwt.Sign(idToken, jwt.WithKey(signingKey.Algorithm(), signingKey))
+	if err != nil {
+		return "", err
+	}
+	return string(serializedToken), nil
+}
@@ -0,0 +1,107 @@
+package routes
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwt"
+
+	"microservice/internal/keys"
+	"microservice/types"
+)
+
+func TestSplitScopes(t *testing.T) {
+	permissions, openIDScopes := splitScopes([]string{"openid", "water:read", "email", "*:*"})
+	if !slices.Equal(permissions, []string{"water:read", "*:*"}) {
+		t.Errorf("unexpected permissions: %v", permissions)
+	}
+	if !slices.Equal(openIDScopes, []string{"openid", "email"}) {
+		t.Errorf("unexpected openid scopes: %v", openIDScopes)
+	}
+}
+
+// parseIDToken verifies the ID token using the published keys
+func parseIDToken(t *testing.T, rawIDToken string) jwt.Token {
+	t.Helper()
+	idToken, err := jwt.ParseString(rawIDToken, jwt.WithKeySet(keys.PublicKeySet()), jwt.WithValidate(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idToken
+}
+
+func TestBuildIDToken(t *testing.T) {
+	user := &types.User{ID: "user", Name: "Jane Doe", Username: "jane", Email: "jane@example.com"}
+	authTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	grant := &tokenGrant{
+		clientID:         "client",
+		family:           "family",
+		nonce:            "nonce",
+		authTime:         authTime,
+		openIDScopes:     []string{"openid", "profile"},
+		idTokenAlgorithm: keys.SigningAlgorithms[0],
+	}
+
+	rawIDToken, err := buildIDToken(user, grant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idToken := parseIDToken(t, rawIDToken)
+
+	if idToken.Issuer() != idTokenIssuer() || idToken.Subject() != "user" {
+		t.Error("unexpected issuer or subject")
+	}
+	if !slices.Equal(idToken.Audience(), []string{"client"}) {
+		t.Errorf("unexpected audience: %v", idToken.Audience())
+	}
+
+	claims := idToken.PrivateClaims()
+	expectedClaims := map[string]any{
+		"azp":                "client",
+		"sid":                "family",
+		"nonce":              "nonce",
+		"name":               "Jane Doe",
+		"preferred_username": "jane",
+		"auth_time":          float64(authTime.Unix()),
+	}
+	for claim, expected := range expectedClaims {
+		if claims[claim] != expected {
+			t.Errorf("expected %s to be %v, got %v", claim, expected, claims[claim])
+		}
+	}
+	if _, set := claims["email"]; set {
+		t.Error("email added without the email scope")
+	}
+}
+
+func TestBuildIDTokenWithoutClient(t *testing.T) {
+	user := &types.User{ID: "user", Name: "Jane Doe", Email: "jane@example.com"}
+	grant := &tokenGrant{
+		openIDScopes:     []string{"openid", "email"},
+		idTokenAlgorithm: keys.SigningAlgorithms[0],
+	}
+
+	rawIDToken, err := buildIDToken(user, grant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idToken := parseIDToken(t, rawIDToken)
+
+	if !slices.Equal(idToken.Audience(), []string{DefaultIDTokenAudience}) {
+		t.Errorf("unexpected audience: %v", idToken.Audience())
+	}
+
+	claims := idToken.PrivateClaims()
+	if claims["email"] != "jane@example.com" {
+		t.Errorf("unexpected email: %v", claims["email"])
+	}
+	for _, claim := range []string{"nonce", "name", "preferred_username"} {
+		if _, set := claims[claim]; set {
+			t.Errorf("unexpected claim %s", claim)
+		}
+	}
+	if _, set := claims["auth_time"]; !set {
+		t.Error("authentication time missing")
+	}
+}
@@ -0,0 +1,131 @@
+package routes
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+
+	"microservice/dpop"
+	"microservice/internal/db"
+	"microservice/internal/errors"
+	"microservice/types"
+)
+
+// IntrospectToken implements the token introspection as defined in RFC 7662.
+// It accepts access tokens and refresh tokens issued by this service and
+// requires the requesting party to authenticate as a registered client
+func IntrospectToken(c *gin.Context) {
+	var parameters struct {
+		Token         string `form:"token" binding:"required"`
+		TokenTypeHint string `form:"token_type_hint"`
+		ClientCredentials
+	}
+
+	if err := c.ShouldBind(&parameters); err != nil {
+		c.Abort()
+		res := errors.ErrMissingParameter
+		res.Errors = []error{err}
+		errors.EmitOAuth(c, res)
+		return
+	}
+
+	if !readClientCredentials(c, &parameters.ClientCredentials) {
+		return
+	}
+
+	client := authenticateClient(c, parameters.ClientCredentials)
+	if client == nil {
+		return
+	}
+
+	// the response must not be cached as the token state may change at any time
+	c.Header("Cache-Control", "no-store")
+
+	rawToken := []byte(strings.TrimSpace(parameters.Token))
+
+	var token jwt.Token
+	var tokenType string
+	switch jwx.GuessFormat(rawToken) {
+	case jwx.JWS:
+		var err error
+		token, err = parseAccessToken(rawToken)
+		if err != nil {
+			c.JSON(http.StatusOK, types.IntrospectionResponse{Active: false})
+			return
+		}
+
+		revoked, err := denyList.IsRevoked(c, token.JwtID())
+		if err != nil {
+			c.Abort()
+			_ = c.Error(err)
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusOK, types.IntrospectionResponse{Active: false})
+			return
+		}
+		tokenType = "Bearer"
+	case jwx.JWE:
+		var err error
+		token, err = parseRefreshToken(rawToken)
+		if err != nil {
+			c.JSON(http.StatusOK, types.IntrospectionResponse{Active: false})
+			return
+		}
+
+		query, err := db.Queries.Raw("check-for-refresh-token")
+		if err != nil {
+			c.Abort()
+			_ = c.Error(err)
+			return
+		}
+
+		var tokenAlive bool
+		err = pgxscan.Get(c, db.Pool, &tokenAlive, query, token.JwtID())
+		if err != nil {
+			c.Abort()
+			_ = c.Error(err)
+			return
+		}
+
+		if !tokenAlive {
+			c.JSON(http.StatusOK, types.IntrospectionResponse{Active: false})
+			return
+		}
+		tokenType = "refresh_token"
+	default:
+		c.JSON(http.StatusOK, types.IntrospectionResponse{Active: false})
+		return
+	}
+
+	res := types.IntrospectionResponse{
+		Active:    true,
+		Scope:     strings.Join(tokenScopes(token), " "),
+		Subject:   token.Subject(),
+		ExpiresAt: token.Expiration().Unix(),
+		Issuer:    token.Issuer(),
+		Audience:  token.Audience(),
+		TokenType: tokenType,
+	}
+
+	if !token.IssuedAt().IsZero() {
+		res.IssuedAt = token.IssuedAt().Unix()
+	}
+
+	if thumbprint := dpop.ConfirmationThumbprint(token); thumbprint != "" {
+		res.Confirmation = map[string]string{"jkt": thumbprint}
+		if tokenType == "Bearer" {
+			res.TokenType = "DPoP"
+		}
+	}
+
+	if clientID, set := token.Get("client_id"); set {
+		res.ClientID, _ = clientID.(string)
+	}
+
+	c.JSON(http.StatusOK, res)
+}
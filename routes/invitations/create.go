@@ -0,0 +1,177 @@
+package invitations
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/gin-gonic/gin"
+
+	"microservice/internal/db"
+	apiErrors "microservice/internal/errors"
+	"microservice/types"
+	"microservice/utils"
+)
+
+// DefaultLifetime is used if the invitation has no expiry date
+const DefaultLifetime = 14 * 24 * time.Hour
+
+// Create invites a user who has not logged in yet and grants them the
+// permissions once they log in for the first time.
+// The user is matched by the external identifier or by the email address if
+// the external provider reports it as verified
+func Create(c *gin.Context) {
+	var parameters struct {
+		Email              string     `json:"email"`
+		ExternalIdentifier string     `json:"externalIdentifier"`
+		ExpiresAt          *time.Time `json:"expiresAt"`
+		Assignments        []struct {
+			Service string `json:"service" binding:"required"`
+			Scope   string `json:"scope" binding:"required"`
+		} `json:"assignments" binding:"required"`
+	}
+	err := c.BindJSON(&parameters)
+	if err != nil {
+		res := apiErrors.ErrMissingParameter
+		res.Errors = []error{err}
+		res.Emit(c)
+		return
+	}
+
+	email := optional(parameters.Email)
+	externalIdentifier := optional(parameters.ExternalIdentifier)
+	expiresAt := time.Now().Add(DefaultLifetime)
+	if parameters.ExpiresAt != nil {
+		expiresAt = *parameters.ExpiresAt
+	}
+	if (email == nil && externalIdentifier == nil) || len(parameters.Assignments) == 0 || !expiresAt.After(time.Now()) {
+		c.Abort()
+		apiErrors.ErrInvalidInvitation.Emit(c)
+		return
+	}
+
+	query, err := db.Queries.Raw("user-exists")
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	var userExists bool
+	err = pgxscan.Get(c, db.Pool, &userExists, query, externalIdentifier, email)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+	if userExists {
+		c.Abort()
+		apiErrors.ErrUserExists.Emit(c)
+		return
+	}
+
+	query, err = db.Queries.Raw("get-service-by-external-id")
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	services := make([]types.Service, len(parameters.Assignments))
+	for i, assignment := range parameters.Assignments {
+		err = pgxscan.Get(c, db.Pool, &services[i], query, assignment.Service)
+		if err != nil {
+			c.Abort()
+			if pgxscan.NotFound(err) {
+				apiErrors.ErrBadService.Emit(c)
+				return
+			}
+			_ = c.Error(err)
+			return
+		}
+
+		if !slices.Contains(services[i].SupportedScopes, assignment.Scope) {
+			c.Abort()
+			apiErrors.ErrInvalidScope.Emit(c)
+			return
+		}
+	}
+
+	// invitations may also be created by clients, which are not recorded as
+	// creator
+	var createdBy *string
+	if creator, err := utils.GetUser(types.InternalIdentifier(c.GetString("subject"))); err == nil {
+		createdBy = &creator.ID
+	} else if !errors.Is(err, utils.ErrNoUser) {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	tx, err := db.Pool.Begin(c)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+	defer tx.Rollback(c)
+
+	query, err = db.Queries.Raw("create-invitation")
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	var invitationID string
+	err = pgxscan.Get(c, tx, &invitationID, query, email, externalIdentifier, expiresAt, createdBy)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	query, err = db.Queries.Raw("add-invitation-permission")
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	for i, assignment := range parameters.Assignments {
+		_, err = tx.Exec(c, query, invitationID, services[i].ID, assignment.Scope)
+		if err != nil {
+			c.Abort()
+			_ = c.Error(err)
+			return
+		}
+	}
+
+	err = tx.Commit(c)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	invitation, err := getPendingInvitation(c, invitationID)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, invitation)
+}
+
+// optional returns nil for empty values
+func optional(value string) *string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	return &value
+}
@@ -0,0 +1,48 @@
+package invitations
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/gin-gonic/gin"
+
+	"microservice/internal/db"
+	"microservice/types"
+)
+
+// List returns the invitations which have neither been accepted nor expired
+func List(c *gin.Context) {
+	query, err := db.Queries.Raw("get-pending-invitations")
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	invitations := make([]types.Invitation, 0)
+	err = pgxscan.Select(c, db.Pool, &invitations, query, nil)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, invitations)
+}
+
+// getPendingInvitation reads a single invitation which has neither been
+// accepted nor expired
+func getPendingInvitation(ctx context.Context, id string) (*types.Invitation, error) {
+	query, err := db.Queries.Raw("get-pending-invitations")
+	if err != nil {
+		return nil, err
+	}
+
+	var invitation types.Invitation
+	err = pgxscan.Get(ctx, db.Pool, &invitation, query, id)
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
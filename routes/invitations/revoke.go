@@ -0,0 +1,42 @@
+package invitations
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"microservice/internal/db"
+	apiErrors "microservice/internal/errors"
+)
+
+// Revoke deletes an invitation which has not been accepted yet
+func Revoke(c *gin.Context) {
+	invitationID := c.Param("invitationID")
+	if err := uuid.Validate(invitationID); err != nil {
+		c.Abort()
+		apiErrors.ErrUnknownInvitation.Emit(c)
+		return
+	}
+
+	query, err := db.Queries.Raw("revoke-invitation")
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	result, err := db.Pool.Exec(c, query, invitationID)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.Abort()
+		apiErrors.ErrUnknownInvitation.Emit(c)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -5,9 +5,9 @@ import (
 
 	"github.com/gin-gonic/gin"
 
-	"microservice/resources"
+	"microservice/internal/keys"
 )
 
 func JWK(c *gin.Context) {
-	c.JSON(http.StatusOK, resources.KeySet)
+	c.JSON(http.StatusOK, keys.PublicKeySet())
 }
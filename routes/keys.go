@@ -0,0 +1,55 @@
+package routes
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"microservice/internal/keys"
+)
+
+// keyGeneration contains the public information about a key generation
+type keyGeneration struct {
+	ID              string      `json:"id"`
+	Status          keys.Status `json:"status"`
+	CreatedAt       time.Time   `json:"createdAt"`
+	ActivatedAt     *time.Time  `json:"activatedAt,omitempty"`
+	RetiredAt       *time.Time  `json:"retiredAt,omitempty"`
+	SigningKeyIDs   []string    `json:"signingKeyIDs"`
+	EncryptionKeyID string      `json:"encryptionKeyID"`
+}
+
+// RotateKeys activates the next key generation immediately, retires the
+// currently active one and prepares a new next generation.
+// It returns the key generations known after the rotation
+func RotateKeys(c *gin.Context) {
+	err := keys.Rotate(c)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	var generations []keyGeneration
+	for _, generation := range keys.Generations() {
+		g := keyGeneration{
+			ID:              generation.ID,
+			Status:          generation.Status,
+			CreatedAt:       generation.CreatedAt,
+			EncryptionKeyID: generation.EncryptionKey.KeyID(),
+		}
+		for _, key := range generation.SigningKeys {
+			g.SigningKeyIDs = append(g.SigningKeyIDs, key.KeyID())
+		}
+		if !generation.ActivatedAt.IsZero() {
+			g.ActivatedAt = &generation.ActivatedAt
+		}
+		if !generation.RetiredAt.IsZero() {
+			g.RetiredAt = &generation.RetiredAt
+		}
+		generations = append(generations, g)
+	}
+
+	c.JSON(http.StatusOK, generations)
+}
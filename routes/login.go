@@ -3,6 +3,8 @@ package routes
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"slices"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -15,9 +17,20 @@ import (
 	"microservice/types"
 )
 
+// InitiateLogin starts the authorization code flow for a registered client.
+// The user is redirected to the external provider and returns to the
+// callback of this service, which sends the user back to the client.
+// Logins without a client id use the legacy flow
 func InitiateLogin(c *gin.Context) {
 	var parameters struct {
-		RedirectUri string `form:"redirect_uri" binding:"required"`
+		RedirectUri         string `form:"redirect_uri" binding:"required"`
+		ClientID            string `form:"client_id"`
+		ResponseType        string `form:"response_type"`
+		State               string `form:"state"`
+		Scope               string `form:"scope"`
+		Nonce               string `form:"nonce"`
+		CodeChallenge       string `form:"code_challenge"`
+		CodeChallengeMethod string `form:"code_challenge_method"`
 	}
 	err := c.ShouldBindQuery(&parameters)
 	if err != nil {
@@ -25,12 +38,63 @@ func InitiateLogin(c *gin.Context) {
 		res := errors.ErrMissingParameter
 		res.Errors = []error{err}
 		res.Emit(c)
+		return
+	}
+
+	if parameters.ClientID == "" {
+		initiateLegacyLogin(c, parameters.RedirectUri, parameters.Scope, parameters.Nonce)
+		return
+	}
+
+	client, err := registeredClient(c, parameters.ClientID)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+	if client == nil {
+		c.Abort()
+		errors.ErrUnknownClient.Emit(c)
+		return
+	}
+
+	// the user is only sent back to uris registered by the client as the
+	// authorization code would be leaked otherwise
+	if !slices.Contains(client.RedirectURIs, parameters.RedirectUri) {
+		c.Abort()
+		errors.ErrInvalidRedirectURI.Emit(c)
+		return
+	}
+
+	// once the redirect uri is known to belong to the client, the remaining
+	// errors are reported to the client as defined in RFC 6749, Section 4.1.2.1
+	if parameters.ResponseType != "" && parameters.ResponseType != "code" {
+		redirectToClient(c, parameters.RedirectUri, url.Values{
+			"error": {"unsupported_response_type"},
+			"state": {parameters.State},
+		})
+		return
+	}
+	if parameters.CodeChallenge != "" && parameters.CodeChallengeMethod != "S256" {
+		redirectToClient(c, parameters.RedirectUri, url.Values{
+			"error":             {"invalid_request"},
+			"error_description": {"only the S256 code challenge method is supported"},
+			"state":             {parameters.State},
+		})
+		return
 	}
+
 	// generate a new state for this login
 	state := randstr.Base62(32)
 	tokenParams := types.LoginParameters{}
-	tokenParams.RedirectUri = parameters.RedirectUri
+	tokenParams.RedirectUri = issuerURL(c, "callback")
 	tokenParams.CodeVerifier = randstr.Base62(128)
+	tokenParams.ClientRedirectUri = parameters.RedirectUri
+	tokenParams.ClientID = client.ID
+	tokenParams.State = parameters.State
+	tokenParams.CodeChallenge = parameters.CodeChallenge
+	tokenParams.Scope = parameters.Scope
+	tokenParams.Nonce = parameters.Nonce
 
 	params, _ := json.Marshal(tokenParams)
 	err = db.Redis.Set(c, state, params, 5*time.Minute).Err()
@@ -40,5 +104,51 @@ func InitiateLogin(c *gin.Context) {
 		_ = c.Error(err)
 		return
 	}
-	c.Redirect(http.StatusFound, oidc.ExternalProvider.AuthCodeURL(state, oauth2.S256ChallengeOption(tokenParams.CodeVerifier), oauth2.SetAuthURLParam("redirect_uri", parameters.RedirectUri)))
+	c.Redirect(http.StatusFound, oidc.ExternalProvider.AuthCodeURL(state, oauth2.S256ChallengeOption(tokenParams.CodeVerifier), oauth2.SetAuthURLParam("redirect_uri", tokenParams.RedirectUri)))
+}
+
+// initiateLegacyLogin starts the login of a client which has not been
+// registered. The user is sent back to the redirect uri by the external
+// provider directly and the client exchanges the code issued by the external
+// provider together with the state at the token endpoint.
+// The redirect uri needs to be registered at the external provider instead
+func initiateLegacyLogin(c *gin.Context, redirectURI string, scope string, nonce string) {
+	state := randstr.Base62(32)
+	tokenParams := types.LoginParameters{
+		RedirectUri:  redirectURI,
+		CodeVerifier: randstr.Base62(128),
+		Scope:        scope,
+		Nonce:        nonce,
+	}
+
+	params, _ := json.Marshal(tokenParams)
+	err := db.Redis.Set(c, state, params, 5*time.Minute).Err()
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+	c.Redirect(http.StatusFound, oidc.ExternalProvider.AuthCodeURL(state, oauth2.S256ChallengeOption(tokenParams.CodeVerifier), oauth2.SetAuthURLParam("redirect_uri", redirectURI)))
+}
+
+// redirectToClient sends the user back to the redirect uri of the client.
+// The parameters are added to the query of the redirect uri while empty
+// parameters are omitted
+func redirectToClient(c *gin.Context, redirectURI string, parameters url.Values) {
+	redirectURL, err := url.Parse(redirectURI)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	query := redirectURL.Query()
+	for name, values := range parameters {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(name, values[0])
+		}
+	}
+	redirectURL.RawQuery = query.Encode()
+
+	c.Redirect(http.StatusFound, redirectURL.String())
 }
@@ -0,0 +1,155 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/redis/go-redis/v9"
+
+	"microservice/internal/db"
+	apiErrors "microservice/internal/errors"
+	"microservice/internal/keys"
+	"microservice/oidc"
+)
+
+// PostLogoutRedirectURIs contains the uris a user may be redirected to after
+// the logout. They need to be registered at the external provider as well
+var PostLogoutRedirectURIs []string
+
+// EndSession implements the RP-initiated logout as defined by OpenID Connect.
+// The session identified by the ID token hint is ended by revoking its
+// refresh tokens and the user is redirected to the external provider to end
+// the session there as well.
+// The ID token hint is required, as the session would otherwise only be ended
+// at the external provider while the refresh tokens stay valid
+func EndSession(c *gin.Context) {
+	var parameters struct {
+		IDTokenHint           string `form:"id_token_hint" binding:"required"`
+		PostLogoutRedirectURI string `form:"post_logout_redirect_uri"`
+		State                 string `form:"state"`
+	}
+
+	if err := c.ShouldBind(&parameters); err != nil {
+		c.Abort()
+		res := apiErrors.ErrMissingParameter
+		res.Errors = []error{err}
+		res.Emit(c)
+		return
+	}
+
+	if parameters.PostLogoutRedirectURI != "" && !slices.Contains(PostLogoutRedirectURIs, parameters.PostLogoutRedirectURI) {
+		c.Abort()
+		apiErrors.ErrInvalidPostLogoutRedirectURI.Emit(c)
+		return
+	}
+
+	idToken, err := parseIDTokenHint(parameters.IDTokenHint)
+	if err != nil {
+		c.Abort()
+		res := apiErrors.ErrInvalidIDTokenHint
+		res.Errors = []error{err}
+		res.Emit(c)
+		return
+	}
+
+	upstreamIDToken, err := endSession(c, idToken)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	if oidc.EndSessionEndpoint != "" {
+		endSessionURL, err := url.Parse(oidc.EndSessionEndpoint)
+		if err != nil {
+			c.Abort()
+			_ = c.Error(err)
+			return
+		}
+
+		query := endSessionURL.Query()
+		query.Set("client_id", oidc.ExternalProvider.ClientID)
+		if upstreamIDToken != "" {
+			query.Set("id_token_hint", upstreamIDToken)
+		}
+		if parameters.PostLogoutRedirectURI != "" {
+			query.Set("post_logout_redirect_uri", parameters.PostLogoutRedirectURI)
+			if parameters.State != "" {
+				query.Set("state", parameters.State)
+			}
+		}
+		endSessionURL.RawQuery = query.Encode()
+
+		c.Redirect(http.StatusFound, endSessionURL.String())
+		return
+	}
+
+	if parameters.PostLogoutRedirectURI != "" {
+		redirectURL, _ := url.Parse(parameters.PostLogoutRedirectURI)
+		if parameters.State != "" {
+			query := redirectURL.Query()
+			query.Set("state", parameters.State)
+			redirectURL.RawQuery = query.Encode()
+		}
+		c.Redirect(http.StatusFound, redirectURL.String())
+		return
+	}
+
+	c.String(http.StatusOK, "You have been logged out")
+}
+
+// parseIDTokenHint verifies that the ID token has been issued by this
+// service. As ID tokens are short-lived, expired ID tokens are accepted
+func parseIDTokenHint(rawIDToken string) (jwt.Token, error) {
+	idToken, err := jwt.Parse([]byte(rawIDToken),
+		jwt.WithVerify(true),
+		jwt.WithValidate(false),
+		jwt.WithKeySet(keys.VerificationKeys()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if idToken.Issuer() != idTokenIssuer() {
+		return nil, errors.New("id token issued by another issuer")
+	}
+	return idToken, nil
+}
+
+// endSession revokes the refresh tokens of the session the ID token has been
+// issued for and returns the ID token issued by the external provider for the
+// session.
+// ID tokens without a session identifier end every session of the user
+func endSession(c *gin.Context, idToken jwt.Token) (string, error) {
+	session, _ := idToken.Get("sid")
+	sessionID, _ := session.(string)
+
+	if sessionID == "" {
+		query, err := db.Queries.Raw("revoke-user-refresh-tokens")
+		if err != nil {
+			return "", err
+		}
+		_, err = db.Pool.Exec(c, query, idToken.Subject())
+		return "", err
+	}
+
+	query, err := db.Queries.Raw("revoke-user-session")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.Pool.Exec(c, query, idToken.Subject(), sessionID)
+	if err != nil {
+		return "", err
+	}
+
+	upstreamIDToken, err := db.Redis.GetDel(c, upstreamIDTokenKeyPrefix+sessionID).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", err
+	}
+	return upstreamIDToken, nil
+}
@@ -0,0 +1,28 @@
+package routes
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"microservice/internal/keys"
+)
+
+// TestMain creates the key generations used to sign and encrypt the tokens
+// in a temporary directory
+func TestMain(m *testing.M) {
+	directory, err := os.MkdirTemp("", "user-management-keys")
+	if err != nil {
+		panic(err)
+	}
+
+	err = keys.Init(context.Background(), &keys.FileStore{Path: filepath.Join(directory, "keys.json")})
+	if err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	_ = os.RemoveAll(directory)
+	os.Exit(code)
+}
@@ -3,14 +3,23 @@ package routes
 import (
 	"context"
 	"fmt"
+	"microservice/dpop"
 	"microservice/internal/db"
+	"microservice/internal/keys"
 	"microservice/types"
-	"path"
+	"strings"
 
 	"github.com/georgysavva/scany/v2/pgxscan"
 	"github.com/gin-gonic/gin"
 )
 
+// IssuerURL is the https url under which the service is reachable by the
+// clients. It identifies the service as OpenID Connect provider and is used
+// as issuer of the ID tokens.
+// If no issuer url has been configured, the urls of the service are derived
+// from the requests and the TokenIssuer is used as issuer of the ID tokens
+var IssuerURL string
+
 func OpenIDConfiguration(c *gin.Context) {
 	query, err := db.Queries.Raw("get-services")
 	if err != nil {
@@ -30,26 +39,45 @@ func OpenIDConfiguration(c *gin.Context) {
 	}
 
 	scopes = append(scopes, "*:*")
-
-	scheme := "http"
-	if c.Request.TLS != nil {
-		scheme = "https"
-	}
-	if proxiedScheme := c.Request.Header.Get("X-Forwarded-Proto"); proxiedScheme != "" {
-		scheme = proxiedScheme
-	}
-
-	pathPrefix := c.Request.Header.Get("X-Forwarded-Prefix")
+	scopes = append(scopes, OpenIDScopes...)
 
 	c.JSON(200, gin.H{
-		"issuer":                                "user-management",
-		"authorization_endpoint":                fmt.Sprintf("%s://%s", scheme, path.Clean(fmt.Sprintf("%s/%s/login", c.Request.Host, pathPrefix))),
-		"userinfo_endpoint":                     fmt.Sprintf("%s://%s", scheme, path.Clean(fmt.Sprintf("%s/%s/users/me", c.Request.Host, pathPrefix))),
-		"token_endpoint":                        fmt.Sprintf("%s://%s", scheme, path.Clean(fmt.Sprintf("%s/%s/token", c.Request.Host, pathPrefix))),
-		"jwks_uri":                              fmt.Sprintf("%s://%s", scheme, path.Clean(fmt.Sprintf("%s/%s/.well-known/jwks.json", c.Request.Host, pathPrefix))),
+		"issuer":                                idTokenIssuer(),
+		"authorization_endpoint":                issuerURL(c, "login"),
+		"userinfo_endpoint":                     issuerURL(c, "users/me"),
+		"token_endpoint":                        issuerURL(c, "token"),
+		"introspection_endpoint":                issuerURL(c, "introspect"),
+		"device_authorization_endpoint":         issuerURL(c, "device_authorization"),
+		"end_session_endpoint":                  issuerURL(c, "logout"),
+		"jwks_uri":                              issuerURL(c, ".well-known/jwks.json"),
 		"scopes_supported":                      scopes,
-		"id_token_signing_alg_values_supported": []string{"none"},
-		"response_types_supported":              []string{"token"},
-		"grant_types_supported":                 []string{"authorization_code", "refresh_token", "client_credentials"},
+		"id_token_signing_alg_values_supported": keys.SigningAlgorithms,
+		"response_types_supported":              []string{"code"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"subject_types_supported":               []string{"public"},
+		"claims_supported":                      IDTokenClaims,
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token", "client_credentials", GrantTypeTokenExchange, GrantTypeDeviceCode},
+
+		"token_endpoint_auth_methods_supported":            ClientAuthenticationMethods,
+		"token_endpoint_auth_signing_alg_values_supported": dpop.SupportedAlgorithms,
+		"introspection_endpoint_auth_methods_supported":    ClientAuthenticationMethods,
+		"dpop_signing_alg_values_supported":                dpop.SupportedAlgorithms,
 	})
 }
+
+// issuerURL builds the URL of the supplied route below the issuer. Without a
+// configured issuer url, the URL is derived from the request
+func issuerURL(c *gin.Context, route string) string {
+	if IssuerURL == "" {
+		return dpop.BaseURL(c.Request) + "/" + route
+	}
+	return strings.TrimSuffix(IssuerURL, "/") + "/" + route
+}
+
+// idTokenIssuer returns the issuer of the ID tokens
+func idTokenIssuer() string {
+	if IssuerURL == "" {
+		return TokenIssuer
+	}
+	return IssuerURL
+}
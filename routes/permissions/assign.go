@@ -96,5 +96,13 @@ func Assign(c *gin.Context) {
 		return
 	}
 
+	types.PermissionLoaderFrom(c).Forget(user.ID)
+	err = types.LoadPermissions(c, user)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
 	c.JSON(200, user)
 }
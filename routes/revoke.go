@@ -1,68 +1,139 @@
 package routes
 
 import (
+	"strings"
+
 	"github.com/gin-gonic/gin"
 	"github.com/lestrrat-go/jwx/v2"
-	"github.com/lestrrat-go/jwx/v2/jwa"
-	"github.com/lestrrat-go/jwx/v2/jwe"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 
 	"microservice/internal/db"
 	"microservice/internal/errors"
-	"microservice/resources"
 )
 
+// RevokeToken revokes access tokens and refresh tokens as defined in
+// RFC 7009.
+// Confidential clients need to authenticate themselves while public clients
+// identify themselves using their client id. Only tokens issued to the client
+// may be revoked.
+// Revoked access tokens are added to the deny list until they expire.
+// Invalid and unknown tokens are answered like successful revocations as the
+// client can't do anything about them
 func RevokeToken(c *gin.Context) {
 	var parameters struct {
-		Token string `form:"token" binding:"required"`
+		Token         string `form:"token" binding:"required"`
+		TokenTypeHint string `form:"token_type_hint"`
+		ClientCredentials
 	}
 
 	if err := c.ShouldBind(&parameters); err != nil {
 		c.Abort()
 		res := errors.ErrMissingParameter
 		res.Errors = []error{err}
-		res.Emit(c)
+		errors.EmitOAuth(c, res)
+		return
+	}
+
+	if !readClientCredentials(c, &parameters.ClientCredentials) {
+		return
+	}
+
+	clientID := revokingClient(c, parameters.ClientCredentials)
+	if clientID == "" {
 		return
 	}
 
 	tokenType := jwx.GuessFormat([]byte(parameters.Token))
 	switch tokenType {
 	case jwx.JWE:
-		decryptedRefreshToken, err := jwe.Decrypt(
-			[]byte(parameters.Token),
-			jwe.WithKey(jwa.ECDH_ES, resources.PrivateSigningKey),
-		)
+		token, err := parseRefreshToken([]byte(parameters.Token))
 		if err != nil {
 			c.Status(200)
 			return
 		}
-
-		token, err := jwt.Parse(decryptedRefreshToken,
-			jwt.WithIssuer("user-management"),
-			jwt.WithVerify(true),
-			jwt.WithKey(resources.PublicSigningKey.Algorithm(), resources.PublicSigningKey),
-		)
-		if err != nil {
-			c.Status(200)
+		if !issuedToClient(c, token, clientID) {
 			return
 		}
 
 		query, err := db.Queries.Raw("revoke-refresh-token")
 		if err != nil {
-			c.Status(200)
+			c.Abort()
+			_ = c.Error(err)
 			return
 		}
 
 		_, err = db.Pool.Exec(c, query, token.JwtID())
+		if err != nil {
+			c.Abort()
+			_ = c.Error(err)
+			return
+		}
+
+		c.Status(200)
+		return
+	case jwx.JWS:
+		token, err := parseAccessToken([]byte(parameters.Token))
 		if err != nil {
 			c.Status(200)
 			return
 		}
+		if !issuedToClient(c, token, clientID) {
+			return
+		}
+
+		err = denyList.Revoke(c, token)
+		if err != nil {
+			c.Abort()
+			_ = c.Error(err)
+			return
+		}
 
 		c.Status(200)
 		return
 	default:
 		c.Status(200)
 	}
+}
 
+// revokingClient returns the id of the client requesting the revocation.
+// Clients sending credentials are authenticated, while public clients only
+// need to be registered.
+// If the client is unknown or could not be authenticated, the matching error
+// is emitted, the context is aborted and an empty id is returned
+func revokingClient(c *gin.Context, credentials ClientCredentials) string {
+	if hasClientCredentials(credentials) {
+		client := authenticateClient(c, credentials)
+		if client == nil {
+			return ""
+		}
+		return client.ID
+	}
+
+	if strings.TrimSpace(credentials.ClientID) == "" {
+		c.Abort()
+		errors.EmitOAuth(c, errors.ErrInvalidClientCredentials)
+		return ""
+	}
+	client := getClient(c, strings.TrimSpace(credentials.ClientID))
+	if client == nil {
+		return ""
+	}
+	return client.ID
+}
+
+// issuedToClient checks if the token has been issued to the client. Tokens
+// issued to clients contain their id in the client_id claim, while tokens
+// issued to users after a login initiated by a client contain it in the azp
+// claim.
+// If the token has been issued to another client, the error is emitted, the
+// context is aborted and false is returned
+func issuedToClient(c *gin.Context, token jwt.Token, clientID string) bool {
+	for _, claim := range []string{"client_id", "azp"} {
+		if value, set := token.Get(claim); set && value == clientID {
+			return true
+		}
+	}
+	c.Abort()
+	errors.EmitOAuth(c, errors.ErrTokenNotIssuedToClient)
+	return false
 }
@@ -0,0 +1,243 @@
+package scim
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// supported describes whether an optional feature is supported
+type supported struct {
+	Supported bool `json:"supported"`
+}
+
+// ServiceProviderConfig describes the features supported by the endpoint as
+// defined in RFC 7643, Section 5
+func ServiceProviderConfig(c *gin.Context) {
+	render(c, http.StatusOK, gin.H{
+		"schemas":          []string{ServiceProviderConfigSchema},
+		"documentationUri": "https://www.rfc-editor.org/rfc/rfc7644",
+		"patch":            supported{true},
+		"bulk":             gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":           gin.H{"supported": true, "maxResults": MaxPageSize},
+		"changePassword":   supported{false},
+		"sort":             supported{false},
+		"etag":             supported{false},
+		"authenticationSchemes": []gin.H{
+			{
+				"type":        "oauthbearertoken",
+				"name":        "OAuth Bearer Token",
+				"description": "Access token issued by this service using the client credentials grant",
+				"specUri":     "https://www.rfc-editor.org/rfc/rfc6750",
+				"primary":     true,
+			},
+		},
+		"meta": meta{ResourceType: "ServiceProviderConfig", Location: location(c, "ServiceProviderConfig")},
+	})
+}
+
+// resourceType describes a resource type as defined in RFC 7643, Section 6
+type resourceType struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Endpoint    string   `json:"endpoint"`
+	Description string   `json:"description"`
+	Schema      string   `json:"schema"`
+	Meta        meta     `json:"meta"`
+}
+
+func resourceTypes(c *gin.Context) []resourceType {
+	return []resourceType{
+		{
+			Schemas:     []string{ResourceTypeSchema},
+			ID:          "User",
+			Name:        "User",
+			Endpoint:    "/Users",
+			Description: "Users of the service",
+			Schema:      UserSchema,
+			Meta:        meta{ResourceType: "ResourceType", Location: location(c, "ResourceTypes", "User")},
+		},
+		{
+			Schemas:     []string{ResourceTypeSchema},
+			ID:          "Group",
+			Name:        "Group",
+			Endpoint:    "/Groups",
+			Description: "Scopes offered by the registered services",
+			Schema:      GroupSchema,
+			Meta:        meta{ResourceType: "ResourceType", Location: location(c, "ResourceTypes", "Group")},
+		},
+	}
+}
+
+// ResourceTypes lists the supported resource types
+func ResourceTypes(c *gin.Context) {
+	types := resourceTypes(c)
+	render(c, http.StatusOK, listResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: len(types),
+		StartIndex:   1,
+		ItemsPerPage: len(types),
+		Resources:    types,
+	})
+}
+
+// ResourceType returns a single resource type
+func ResourceType(c *gin.Context) {
+	for _, t := range resourceTypes(c) {
+		if t.ID == c.Param("resourceTypeID") {
+			render(c, http.StatusOK, t)
+			return
+		}
+	}
+	emit(c, errNotFound)
+}
+
+// attribute describes an attribute of a schema as defined in RFC 7643,
+// Section 7
+type attribute struct {
+	Name          string      `json:"name"`
+	Type          string      `json:"type"`
+	MultiValued   bool        `json:"multiValued"`
+	Description   string      `json:"description"`
+	Required      bool        `json:"required"`
+	CaseExact     bool        `json:"caseExact"`
+	Mutability    string      `json:"mutability"`
+	Returned      string      `json:"returned"`
+	Uniqueness    string      `json:"uniqueness"`
+	SubAttributes []attribute `json:"subAttributes,omitempty"`
+}
+
+// stringAttribute describes a single valued string attribute which may be
+// read and written
+func stringAttribute(name string, description string) attribute {
+	return attribute{
+		Name:        name,
+		Type:        "string",
+		Description: description,
+		Mutability:  "readWrite",
+		Returned:    "default",
+		Uniqueness:  "none",
+	}
+}
+
+// schema describes a resource as defined in RFC 7643, Section 7
+type schema struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Attributes  []attribute `json:"attributes"`
+	Meta        meta        `json:"meta"`
+}
+
+func schemas(c *gin.Context) []schema {
+	userName := stringAttribute("userName", "Username of the user")
+	userName.Required = true
+	userName.Uniqueness = "server"
+
+	externalID := stringAttribute("externalId", "Identifier of the user at the external provider")
+	externalID.Required = true
+	externalID.CaseExact = true
+	externalID.Uniqueness = "server"
+
+	emailValue := stringAttribute("value", "Email address of the user")
+	members := attribute{
+		Name:        "members",
+		Type:        "complex",
+		MultiValued: true,
+		Description: "Users granted the scope",
+		Mutability:  "readWrite",
+		Returned:    "default",
+		Uniqueness:  "none",
+		SubAttributes: []attribute{
+			{Name: "value", Type: "string", Description: "Identifier of the user", Mutability: "immutable", Returned: "default", Uniqueness: "none"},
+			{Name: "display", Type: "string", Description: "Username of the user", Mutability: "readOnly", Returned: "default", Uniqueness: "none"},
+			{Name: "$ref", Type: "reference", Description: "URI of the user", Mutability: "readOnly", Returned: "default", Uniqueness: "none"},
+		},
+	}
+
+	return []schema{
+		{
+			Schemas:     []string{SchemaSchema},
+			ID:          UserSchema,
+			Name:        "User",
+			Description: "User of the service",
+			Attributes: []attribute{
+				userName,
+				externalID,
+				stringAttribute("displayName", "Name of the user"),
+				{
+					Name:          "name",
+					Type:          "complex",
+					Description:   "Name of the user. Only the formatted name is stored",
+					Mutability:    "readWrite",
+					Returned:      "default",
+					Uniqueness:    "none",
+					SubAttributes: []attribute{stringAttribute("formatted", "Full name of the user")},
+				},
+				{
+					Name:          "emails",
+					Type:          "complex",
+					MultiValued:   true,
+					Description:   "Email address of the user. Only the primary address is stored",
+					Mutability:    "readWrite",
+					Returned:      "default",
+					Uniqueness:    "none",
+					SubAttributes: []attribute{emailValue},
+				},
+				{
+					Name:        "active",
+					Type:        "boolean",
+					Description: "Inactive users are disabled and may not log in",
+					Mutability:  "readWrite",
+					Returned:    "default",
+					Uniqueness:  "none",
+				},
+			},
+			Meta: meta{ResourceType: "Schema", Location: location(c, "Schemas", UserSchema)},
+		},
+		{
+			Schemas:     []string{SchemaSchema},
+			ID:          GroupSchema,
+			Name:        "Group",
+			Description: "Scope offered by a registered service",
+			Attributes: []attribute{
+				{
+					Name:        "displayName",
+					Type:        "string",
+					Description: "Scope granted to the members",
+					Required:    true,
+					Mutability:  "readOnly",
+					Returned:    "default",
+					Uniqueness:  "server",
+				},
+				members,
+			},
+			Meta: meta{ResourceType: "Schema", Location: location(c, "Schemas", GroupSchema)},
+		},
+	}
+}
+
+// Schemas lists the schemas of the supported resources
+func Schemas(c *gin.Context) {
+	s := schemas(c)
+	render(c, http.StatusOK, listResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: len(s),
+		StartIndex:   1,
+		ItemsPerPage: len(s),
+		Resources:    s,
+	})
+}
+
+// Schema returns a single schema
+func Schema(c *gin.Context) {
+	for _, s := range schemas(c) {
+		if s.ID == c.Param("schemaID") {
+			render(c, http.StatusOK, s)
+			return
+		}
+	}
+	emit(c, errNotFound)
+}
@@ -0,0 +1,378 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// filter is a parsed filter expression as defined in RFC 7644,
+// Section 3.4.2.2
+type filter interface {
+	isFilter()
+}
+
+// logicalExpression combines two filters using and or or
+type logicalExpression struct {
+	operator    string
+	left, right filter
+}
+
+// notExpression negates a filter
+type notExpression struct {
+	filter filter
+}
+
+// attributeExpression compares an attribute with a value. The value is nil
+// for the present operator
+type attributeExpression struct {
+	attribute string
+	operator  string
+	value     any
+}
+
+func (logicalExpression) isFilter()   {}
+func (notExpression) isFilter()       {}
+func (attributeExpression) isFilter() {}
+
+// comparisonOperators contains the supported operators besides present
+var comparisonOperators = []string{"eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le"}
+
+// invalidFilter creates an error for an unusable filter
+func invalidFilter(format string, arguments ...any) Error {
+	return Error{Status: http.StatusBadRequest, ScimType: "invalidFilter", Detail: fmt.Sprintf(format, arguments...)}
+}
+
+// parseFilter parses the filter expression. Attribute names and operators
+// are case-insensitive and returned in lower case
+func parseFilter(expression string) (filter, error) {
+	tokens, err := tokenizeFilter(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := filterParser{tokens: tokens}
+	f, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.position < len(parser.tokens) {
+		return nil, invalidFilter("unexpected %q", parser.tokens[parser.position])
+	}
+	return f, nil
+}
+
+// tokenizeFilter splits the expression into parentheses, quoted strings and
+// words
+func tokenizeFilter(expression string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expression); {
+		switch character := expression[i]; {
+		case character == ' ':
+			i++
+		case character == '(' || character == ')':
+			tokens = append(tokens, string(character))
+			i++
+		case character == '"':
+			end := i + 1
+			for ; end < len(expression) && expression[end] != '"'; end++ {
+				if expression[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(expression) {
+				return nil, invalidFilter("unterminated string")
+			}
+			tokens = append(tokens, expression[i:end+1])
+			i = end + 1
+		default:
+			end := i
+			for end < len(expression) && !strings.ContainsRune(" ()\"", rune(expression[end])) {
+				end++
+			}
+			tokens = append(tokens, expression[i:end])
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens   []string
+	position int
+}
+
+// next returns the next token without consuming it
+func (p *filterParser) next() string {
+	if p.position >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.position]
+}
+
+// consume returns the next token and advances the parser
+func (p *filterParser) consume() (string, error) {
+	if p.position >= len(p.tokens) {
+		return "", invalidFilter("unexpected end of filter")
+	}
+	p.position++
+	return p.tokens[p.position-1], nil
+}
+
+func (p *filterParser) parseOr() (filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.next(), "or") {
+		p.position++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalExpression{operator: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filter, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.next(), "and") {
+		p.position++
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalExpression{operator: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseFactor() (filter, error) {
+	token, err := p.consume()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.EqualFold(token, "not"):
+		if token, err = p.consume(); err != nil || token != "(" {
+			return nil, invalidFilter("expected ( after not")
+		}
+		f, err := p.parseGroup()
+		if err != nil {
+			return nil, err
+		}
+		return notExpression{filter: f}, nil
+	case token == "(":
+		return p.parseGroup()
+	case token == ")" || strings.HasPrefix(token, "\""):
+		return nil, invalidFilter("unexpected %q", token)
+	}
+
+	expression := attributeExpression{attribute: normalizeAttribute(token)}
+	operator, err := p.consume()
+	if err != nil {
+		return nil, err
+	}
+	expression.operator = strings.ToLower(operator)
+	if expression.operator == "pr" {
+		return expression, nil
+	}
+	if !slices.Contains(comparisonOperators, expression.operator) {
+		return nil, invalidFilter("unsupported operator %q", operator)
+	}
+
+	rawValue, err := p.consume()
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(rawValue), &expression.value)
+	if err != nil || expression.value == nil {
+		return nil, invalidFilter("invalid value %q", rawValue)
+	}
+	return expression, nil
+}
+
+// parseGroup parses the filter inside parentheses after the opening
+// parenthesis has been consumed
+func (p *filterParser) parseGroup() (filter, error) {
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if token, err := p.consume(); err != nil || token != ")" {
+		return nil, invalidFilter("expected )")
+	}
+	return f, nil
+}
+
+// filterAttribute describes how an attribute is stored in the database
+type filterAttribute struct {
+	// column is the expression selecting the attribute
+	column string
+
+	// caseExact indicates that strings are compared case-sensitively
+	caseExact bool
+
+	// boolean indicates that the attribute contains a boolean value. The
+	// column contains the inverted value if inverted is set
+	boolean  bool
+	inverted bool
+}
+
+// sqlCondition translates the filter into a SQL condition. Values are
+// appended to the arguments and referenced by their position
+func sqlCondition(f filter, attributes map[string]filterAttribute, arguments *[]any) (string, error) {
+	switch f := f.(type) {
+	case logicalExpression:
+		left, err := sqlCondition(f.left, attributes, arguments)
+		if err != nil {
+			return "", err
+		}
+		right, err := sqlCondition(f.right, attributes, arguments)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%s %s %s)", left, strings.ToUpper(f.operator), right), nil
+	case notExpression:
+		condition, err := sqlCondition(f.filter, attributes, arguments)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("NOT %s", condition), nil
+	case attributeExpression:
+		return attributeCondition(f, attributes, arguments)
+	default:
+		return "", invalidFilter("unsupported filter")
+	}
+}
+
+func attributeCondition(f attributeExpression, attributes map[string]filterAttribute, arguments *[]any) (string, error) {
+	attribute, ok := attributes[f.attribute]
+	if !ok {
+		return "", invalidFilter("unsupported attribute %q", f.attribute)
+	}
+
+	placeholder := func(value any) string {
+		*arguments = append(*arguments, value)
+		return fmt.Sprintf("$%d", len(*arguments))
+	}
+
+	if attribute.boolean {
+		if f.operator == "pr" {
+			return "TRUE", nil
+		}
+		value, ok := f.value.(bool)
+		if !ok || (f.operator != "eq" && f.operator != "ne") {
+			return "", invalidFilter("%s only supports eq and ne with boolean values", f.attribute)
+		}
+		if attribute.inverted {
+			value = !value
+		}
+		if f.operator == "ne" {
+			value = !value
+		}
+		return fmt.Sprintf("(%s = %s)", attribute.column, placeholder(value)), nil
+	}
+
+	if f.operator == "pr" {
+		return fmt.Sprintf("(%s <> '')", attribute.column), nil
+	}
+	value, ok := f.value.(string)
+	if !ok {
+		return "", invalidFilter("%s only supports string values", f.attribute)
+	}
+
+	column := attribute.column
+	if !attribute.caseExact {
+		column = fmt.Sprintf("lower(%s)", column)
+		value = strings.ToLower(value)
+	}
+
+	pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+	switch f.operator {
+	case "eq":
+		return fmt.Sprintf("(%s = %s)", column, placeholder(value)), nil
+	case "ne":
+		return fmt.Sprintf("(%s <> %s)", column, placeholder(value)), nil
+	case "co":
+		return fmt.Sprintf("(%s LIKE %s)", column, placeholder("%"+pattern+"%")), nil
+	case "sw":
+		return fmt.Sprintf("(%s LIKE %s)", column, placeholder(pattern+"%")), nil
+	case "ew":
+		return fmt.Sprintf("(%s LIKE %s)", column, placeholder("%"+pattern)), nil
+	case "gt":
+		return fmt.Sprintf("(%s > %s)", column, placeholder(value)), nil
+	case "ge":
+		return fmt.Sprintf("(%s >= %s)", column, placeholder(value)), nil
+	case "lt":
+		return fmt.Sprintf("(%s < %s)", column, placeholder(value)), nil
+	default:
+		return fmt.Sprintf("(%s <= %s)", column, placeholder(value)), nil
+	}
+}
+
+// matches evaluates the filter against a resource. The attribute values are
+// returned by the lookup, which reports unsupported attributes
+func matches(f filter, lookup func(attribute string) (string, bool)) (bool, error) {
+	switch f := f.(type) {
+	case logicalExpression:
+		left, err := matches(f.left, lookup)
+		if err != nil {
+			return false, err
+		}
+		right, err := matches(f.right, lookup)
+		if err != nil {
+			return false, err
+		}
+		if f.operator == "and" {
+			return left && right, nil
+		}
+		return left || right, nil
+	case notExpression:
+		matched, err := matches(f.filter, lookup)
+		return !matched, err
+	case attributeExpression:
+		actual, ok := lookup(f.attribute)
+		if !ok {
+			return false, invalidFilter("unsupported attribute %q", f.attribute)
+		}
+		if f.operator == "pr" {
+			return actual != "", nil
+		}
+		expected, ok := f.value.(string)
+		if !ok {
+			return false, invalidFilter("%s only supports string values", f.attribute)
+		}
+
+		actual, expected = strings.ToLower(actual), strings.ToLower(expected)
+		switch f.operator {
+		case "eq":
+			return actual == expected, nil
+		case "ne":
+			return actual != expected, nil
+		case "co":
+			return strings.Contains(actual, expected), nil
+		case "sw":
+			return strings.HasPrefix(actual, expected), nil
+		case "ew":
+			return strings.HasSuffix(actual, expected), nil
+		case "gt":
+			return actual > expected, nil
+		case "ge":
+			return actual >= expected, nil
+		case "lt":
+			return actual < expected, nil
+		default:
+			return actual <= expected, nil
+		}
+	default:
+		return false, invalidFilter("unsupported filter")
+	}
+}
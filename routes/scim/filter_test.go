@@ -0,0 +1,229 @@
+package scim
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := map[string]struct {
+		expression string
+		expected   filter
+	}{
+		"comparison": {
+			expression: `userName eq "jdoe"`,
+			expected:   attributeExpression{attribute: "username", operator: "eq", value: "jdoe"},
+		},
+		"caseInsensitive": {
+			expression: `UserName EQ "JDoe"`,
+			expected:   attributeExpression{attribute: "username", operator: "eq", value: "JDoe"},
+		},
+		"schemaPrefix": {
+			expression: `urn:ietf:params:scim:schemas:core:2.0:User:userName sw "j"`,
+			expected:   attributeExpression{attribute: "username", operator: "sw", value: "j"},
+		},
+		"present": {
+			expression: `emails pr`,
+			expected:   attributeExpression{attribute: "emails", operator: "pr"},
+		},
+		"boolean": {
+			expression: `active eq false`,
+			expected:   attributeExpression{attribute: "active", operator: "eq", value: false},
+		},
+		"escapedQuotes": {
+			expression: `displayName eq "say \"hi\" (now)"`,
+			expected:   attributeExpression{attribute: "displayname", operator: "eq", value: `say "hi" (now)`},
+		},
+		"precedence": {
+			expression: `userName pr or emails pr and active pr`,
+			expected: logicalExpression{
+				operator: "or",
+				left:     attributeExpression{attribute: "username", operator: "pr"},
+				right: logicalExpression{
+					operator: "and",
+					left:     attributeExpression{attribute: "emails", operator: "pr"},
+					right:    attributeExpression{attribute: "active", operator: "pr"},
+				},
+			},
+		},
+		"grouping": {
+			expression: `(userName pr or emails pr) and not (active eq true)`,
+			expected: logicalExpression{
+				operator: "and",
+				left: logicalExpression{
+					operator: "or",
+					left:     attributeExpression{attribute: "username", operator: "pr"},
+					right:    attributeExpression{attribute: "emails", operator: "pr"},
+				},
+				right: notExpression{filter: attributeExpression{attribute: "active", operator: "eq", value: true}},
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			f, err := parseFilter(test.expression)
+			if err != nil {
+				t.Fatalf("valid filter rejected: %v", err)
+			}
+			if !reflect.DeepEqual(f, test.expected) {
+				t.Errorf("expected %#v, got %#v", test.expected, f)
+			}
+		})
+	}
+}
+
+func TestParseFilterRejectsInvalidFilters(t *testing.T) {
+	tests := map[string]string{
+		"empty":               ``,
+		"missingValue":        `userName eq`,
+		"missingOperator":     `userName`,
+		"unsupportedOperator": `userName like "j"`,
+		"unterminatedString":  `userName eq "jdoe`,
+		"unquotedString":      `userName eq jdoe`,
+		"nullValue":           `userName eq null`,
+		"unclosedGroup":       `(userName pr`,
+		"unopenedGroup":       `userName pr)`,
+		"notWithoutGroup":     `not userName pr`,
+		"valueAsAttribute":    `"userName" eq "jdoe"`,
+		"trailingTokens":      `userName eq "jdoe" emails pr`,
+		"danglingAnd":         `userName pr and`,
+	}
+	for name, expression := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseFilter(expression)
+			if err == nil {
+				t.Fatal("invalid filter accepted")
+			}
+			if e, ok := err.(Error); !ok || e.ScimType != "invalidFilter" {
+				t.Errorf("expected an invalidFilter error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSQLCondition(t *testing.T) {
+	tests := map[string]struct {
+		expression string
+		condition  string
+		arguments  []any
+	}{
+		"caseInsensitive": {
+			expression: `userName eq "JDoe"`,
+			condition:  `(lower(username) = $1)`,
+			arguments:  []any{"jdoe"},
+		},
+		"caseExact": {
+			expression: `externalId eq "Upstream-Subject"`,
+			condition:  `(external_identifier = $1)`,
+			arguments:  []any{"Upstream-Subject"},
+		},
+		"escapedPattern": {
+			expression: `emails co "50%_off\\"`,
+			condition:  `(lower(email) LIKE $1)`,
+			arguments:  []any{`%50\%\_off\\%`},
+		},
+		"invertedBoolean": {
+			expression: `active eq true`,
+			condition:  `(disabled = $1)`,
+			arguments:  []any{false},
+		},
+		"negatedInvertedBoolean": {
+			expression: `active ne true`,
+			condition:  `(disabled = $1)`,
+			arguments:  []any{true},
+		},
+		"logical": {
+			expression: `userName sw "j" and not (displayName ew "doe" or emails pr)`,
+			condition:  `((lower(username) LIKE $1) AND NOT ((lower(name) LIKE $2) OR (email <> '')))`,
+			arguments:  []any{"j%", "%doe"},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			f, err := parseFilter(test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var arguments []any
+			condition, err := sqlCondition(f, userAttributes, &arguments)
+			if err != nil {
+				t.Fatalf("filter rejected: %v", err)
+			}
+			if condition != test.condition {
+				t.Errorf("expected condition %s, got %s", test.condition, condition)
+			}
+			if !reflect.DeepEqual(arguments, test.arguments) {
+				t.Errorf("expected arguments %v, got %v", test.arguments, arguments)
+			}
+		})
+	}
+}
+
+func TestSQLConditionRejectsUnsupportedFilters(t *testing.T) {
+	tests := map[string]string{
+		"unknownAttribute":    `password eq "secret"`,
+		"booleanComparison":   `active gt true`,
+		"stringAsBoolean":     `active eq "true"`,
+		"booleanAsString":     `userName eq true`,
+		"numberAsString":      `userName eq 42`,
+		"nestedUnknownFilter": `userName pr and not (groups pr)`,
+	}
+	for name, expression := range tests {
+		t.Run(name, func(t *testing.T) {
+			f, err := parseFilter(expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var arguments []any
+			if _, err := sqlCondition(f, userAttributes, &arguments); err == nil {
+				t.Error("unsupported filter accepted")
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	attributes := map[string]string{
+		"displayname": "user-management:read",
+		"id":          "a1b2c3",
+	}
+	lookup := func(attribute string) (string, bool) {
+		value, ok := attributes[attribute]
+		return value, ok
+	}
+
+	tests := map[string]bool{
+		`displayName eq "USER-MANAGEMENT:READ"`:                true,
+		`displayName sw "user-management:"`:                    true,
+		`displayName ew ":write"`:                              false,
+		`displayName co "management" and id eq "a1b2c3"`:       true,
+		`displayName co "example" or not (id eq "other")`:      true,
+		`not (displayName pr)`:                                 false,
+		`displayName gt "user-management:a" and id lt "a1b2d"`: true,
+	}
+	for expression, expected := range tests {
+		t.Run(expression, func(t *testing.T) {
+			f, err := parseFilter(expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			matched, err := matches(f, lookup)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if matched != expected {
+				t.Errorf("expected %v, got %v", expected, matched)
+			}
+		})
+	}
+
+	f, err := parseFilter(`members pr`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := matches(f, lookup); err == nil {
+		t.Error("unsupported attribute accepted")
+	}
+}
@@ -0,0 +1,473 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"microservice/internal/db"
+	"microservice/types"
+	"microservice/utils"
+)
+
+// member is a user belonging to a group
+type member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// groupResource is the representation of a group as defined in RFC 7643,
+// Section 4.2.
+// Every scope offered by a service is a group named after the scope, e.g.
+// "user-management:read". The id consists of the id of the service and the
+// scope level
+type groupResource struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []member `json:"members,omitempty"`
+	Meta        *meta    `json:"meta,omitempty"`
+
+	service, level string
+}
+
+// loadGroups reads the groups and their members. Members are omitted if
+// withMembers is not set
+func loadGroups(c *gin.Context, withMembers bool) ([]groupResource, error) {
+	query, err := db.Queries.Raw("get-services")
+	if err != nil {
+		return nil, err
+	}
+
+	var services []types.Service
+	err = pgxscan.Select(c, db.Pool, &services, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []groupResource
+	for _, service := range services {
+		for _, level := range service.SupportedScopes {
+			id := service.ID + ":" + level
+			groups = append(groups, groupResource{
+				Schemas:     []string{GroupSchema},
+				ID:          id,
+				DisplayName: service.Name + ":" + level,
+				Meta:        &meta{ResourceType: "Group", Location: location(c, "Groups", id)},
+				service:     service.ID,
+				level:       level,
+			})
+		}
+	}
+	slices.SortFunc(groups, func(a, b groupResource) int {
+		return strings.Compare(a.DisplayName, b.DisplayName)
+	})
+
+	if !withMembers {
+		return groups, nil
+	}
+
+	query, err = db.Queries.Raw("get-permission-members")
+	if err != nil {
+		return nil, err
+	}
+
+	var assignments []struct {
+		Service  string `db:"service"`
+		Level    string `db:"level"`
+		UserID   string `db:"id"`
+		Username string `db:"username"`
+	}
+	err = pgxscan.Select(c, db.Pool, &assignments, query)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string][]member)
+	for _, assignment := range assignments {
+		id := assignment.Service + ":" + assignment.Level
+		members[id] = append(members[id], member{
+			Value:   assignment.UserID,
+			Display: assignment.Username,
+			Ref:     location(c, "Users", assignment.UserID),
+		})
+	}
+	for i := range groups {
+		groups[i].Members = members[groups[i].ID]
+	}
+	return groups, nil
+}
+
+// findGroup returns the group with the id
+func findGroup(c *gin.Context, id string) (*groupResource, error) {
+	groups, err := loadGroups(c, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		if group.ID == id {
+			return &group, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+// ListGroups returns a page of the groups matching the filter. As the groups
+// are derived from the services, they are filtered in memory.
+// The members may be excluded using the excludedAttributes parameter
+func ListGroups(c *gin.Context) {
+	parameters, err := readListParameters(c)
+	if err != nil {
+		emit(c, err)
+		return
+	}
+
+	var f filter
+	if parameters.Filter != "" {
+		f, err = parseFilter(parameters.Filter)
+		if err != nil {
+			emit(c, err)
+			return
+		}
+	}
+
+	excludeMembers := slices.ContainsFunc(strings.Split(c.Query("excludedAttributes"), ","), func(attribute string) bool {
+		return normalizeAttribute(strings.TrimSpace(attribute)) == "members"
+	})
+	groups, err := loadGroups(c, !excludeMembers)
+	if err != nil {
+		emit(c, err)
+		return
+	}
+
+	matchingGroups := make([]groupResource, 0)
+	for _, group := range groups {
+		if f != nil {
+			matched, err := matches(f, func(attribute string) (string, bool) {
+				switch attribute {
+				case "id":
+					return group.ID, true
+				case "displayname":
+					return group.DisplayName, true
+				case "externalid":
+					return "", true
+				default:
+					return "", false
+				}
+			})
+			if err != nil {
+				emit(c, err)
+				return
+			}
+			if !matched {
+				continue
+			}
+		}
+		matchingGroups = append(matchingGroups, group)
+	}
+
+	start := min(parameters.StartIndex-1, len(matchingGroups))
+	end := min(start+*parameters.Count, len(matchingGroups))
+	page := matchingGroups[start:end]
+
+	render(c, http.StatusOK, listResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: len(matchingGroups),
+		StartIndex:   parameters.StartIndex,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	})
+}
+
+// GetGroup returns a single group
+func GetGroup(c *gin.Context) {
+	group, err := findGroup(c, c.Param("groupID"))
+	if err != nil {
+		emit(c, err)
+		return
+	}
+	render(c, http.StatusOK, group)
+}
+
+// CreateGroup rejects the creation of groups as they are defined by the
+// registered services
+func CreateGroup(c *gin.Context) {
+	emit(c, errGroupDefined)
+}
+
+// DeleteGroup rejects the deletion of groups as they are defined by the
+// registered services
+func DeleteGroup(c *gin.Context) {
+	emit(c, errGroupDefined)
+}
+
+// ReplaceGroup replaces the members of the group
+func ReplaceGroup(c *gin.Context) {
+	group, err := findGroup(c, c.Param("groupID"))
+	if err != nil {
+		emit(c, err)
+		return
+	}
+
+	var resource groupResource
+	err = bindJSON(c, &resource)
+	if err != nil {
+		emit(c, err)
+		return
+	}
+	if resource.DisplayName != "" && resource.DisplayName != group.DisplayName {
+		emit(c, errGroupDefined)
+		return
+	}
+
+	err = changeMembers(c, *group, memberChange{replace: true, add: memberIDs(resource.Members)})
+	if err != nil {
+		emit(c, err)
+		return
+	}
+
+	GetGroup(c)
+}
+
+// PatchGroup adds and removes members of the group
+func PatchGroup(c *gin.Context) {
+	group, err := findGroup(c, c.Param("groupID"))
+	if err != nil {
+		emit(c, err)
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		emit(c, err)
+		return
+	}
+
+	operations, err := readPatchRequest(body)
+	if err != nil {
+		emit(c, err)
+		return
+	}
+
+	var change memberChange
+	for _, operation := range operations {
+		err = change.apply(*group, operation)
+		if err != nil {
+			emit(c, err)
+			return
+		}
+	}
+
+	err = changeMembers(c, *group, change)
+	if err != nil {
+		emit(c, err)
+		return
+	}
+
+	GetGroup(c)
+}
+
+// memberChange collects the changes to the members of a group. If replace is
+// set, the current members are removed before adding the new members
+type memberChange struct {
+	replace     bool
+	add, remove []string
+}
+
+// apply adds the changes of the PATCH operation
+func (m *memberChange) apply(group groupResource, operation patchOperation) error {
+	if operation.Path == "" {
+		var attributes map[string]json.RawMessage
+		err := json.Unmarshal(operation.Value, &attributes)
+		if err != nil {
+			return invalidValue("operations without path require an object as value")
+		}
+		for attribute, value := range attributes {
+			err = m.apply(group, patchOperation{Op: operation.Op, Path: normalizeAttribute(attribute), Value: value})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	attribute, valueFilter, subAttribute, err := splitValuePath(operation.Path)
+	if err != nil {
+		return err
+	}
+
+	switch attribute {
+	case "members":
+	case "displayname":
+		displayName, err := readString(attribute, operation.Value)
+		if err != nil || displayName != group.DisplayName {
+			return errGroupDefined
+		}
+		return nil
+	case "id", "meta", "schemas":
+		return errMutability
+	default:
+		return errInvalidPath
+	}
+	if subAttribute != "" {
+		return errInvalidPath
+	}
+
+	var members []member
+	if len(operation.Value) > 0 {
+		err = json.Unmarshal(operation.Value, &members)
+		if err != nil {
+			return invalidValue("members needs to be a list of members")
+		}
+	}
+
+	// the members selected by a filter are only supported while removing
+	if valueFilter != nil {
+		if operation.Op != "remove" {
+			return errInvalidPath
+		}
+		for _, member := range group.Members {
+			matched, err := matches(valueFilter, func(attribute string) (string, bool) {
+				switch attribute {
+				case "value":
+					return member.Value, true
+				case "display":
+					return member.Display, true
+				default:
+					return "", false
+				}
+			})
+			if err != nil {
+				return err
+			}
+			if matched {
+				m.removeMembers(member.Value)
+			}
+		}
+		return nil
+	}
+
+	switch operation.Op {
+	case "add":
+		m.addMembers(memberIDs(members)...)
+	case "replace":
+		m.replace = true
+		m.add = memberIDs(members)
+		m.remove = nil
+	case "remove":
+		// removing the attribute without values removes every member
+		if len(operation.Value) == 0 {
+			m.replace = true
+			m.add = nil
+			m.remove = nil
+			return nil
+		}
+		m.removeMembers(memberIDs(members)...)
+	}
+	return nil
+}
+
+// addMembers adds the members and drops them from the members removed by
+// previous operations
+func (m *memberChange) addMembers(ids ...string) {
+	m.remove = slices.DeleteFunc(m.remove, func(id string) bool { return slices.Contains(ids, id) })
+	m.add = append(m.add, ids...)
+}
+
+// removeMembers removes the members and drops them from the members added by
+// previous operations
+func (m *memberChange) removeMembers(ids ...string) {
+	m.add = slices.DeleteFunc(m.add, func(id string) bool { return slices.Contains(ids, id) })
+	m.remove = append(m.remove, ids...)
+}
+
+// memberIDs returns the ids of the members
+func memberIDs(members []member) []string {
+	ids := make([]string, len(members))
+	for i, member := range members {
+		ids[i] = member.Value
+	}
+	return ids
+}
+
+// changeMembers assigns the scope of the group to the added members and
+// removes it from the removed members in a single transaction
+func changeMembers(ctx context.Context, group groupResource, change memberChange) error {
+	for _, userID := range change.add {
+		if uuid.Validate(userID) != nil {
+			return invalidValue("unknown member " + userID)
+		}
+		_, err := utils.GetUser(types.InternalIdentifier(userID))
+		if err != nil {
+			if errors.Is(err, utils.ErrNoUser) {
+				return invalidValue("unknown member " + userID)
+			}
+			return err
+		}
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if change.replace {
+		query, err := db.Queries.Raw("remove-permission-members")
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, query, group.service, group.level)
+		if err != nil {
+			return err
+		}
+	}
+
+	query, err := db.Queries.Raw("remove-permission")
+	if err != nil {
+		return err
+	}
+	for _, userID := range change.remove {
+		if uuid.Validate(userID) != nil {
+			continue
+		}
+		_, err = tx.Exec(ctx, query, userID, group.service, group.level)
+		if err != nil {
+			return err
+		}
+	}
+
+	query, err = db.Queries.Raw("assign-permission")
+	if err != nil {
+		return err
+	}
+	for _, userID := range change.add {
+		_, err = tx.Exec(ctx, query, userID, group.service, group.level)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	changedUserIDs := slices.Concat(change.add, change.remove)
+	if change.replace {
+		changedUserIDs = append(changedUserIDs, memberIDs(group.Members)...)
+	}
+	loader := types.PermissionLoaderFrom(ctx)
+	for _, userID := range changedUserIDs {
+		loader.Forget(userID)
+	}
+	return nil
+}
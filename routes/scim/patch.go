@@ -0,0 +1,112 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// patchRequest contains the operations of a PATCH request as defined in
+// RFC 7644, Section 3.5.2
+type patchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []patchOperation `json:"Operations"`
+}
+
+// patchOperation is a single operation of a PATCH request. Some identity
+// management systems send the operation capitalized, so it is normalized
+// while reading the request
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+var (
+	errNoTarget    = Error{Status: http.StatusBadRequest, ScimType: "noTarget", Detail: "the operation requires a path"}
+	errInvalidPath = Error{Status: http.StatusBadRequest, ScimType: "invalidPath", Detail: "the path is not supported"}
+	errMutability  = Error{Status: http.StatusBadRequest, ScimType: "mutability", Detail: "the attribute may not be changed"}
+)
+
+// readPatchRequest reads the operations and validates their types
+func readPatchRequest(body []byte) ([]patchOperation, error) {
+	var request patchRequest
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		e := errInvalidBody
+		e.Detail = err.Error()
+		return nil, e
+	}
+
+	if len(request.Operations) == 0 {
+		return nil, invalidValue("the request contains no operations")
+	}
+
+	for i, operation := range request.Operations {
+		operation.Op = strings.ToLower(operation.Op)
+		switch operation.Op {
+		case "add", "replace", "remove":
+		default:
+			return nil, invalidValue("unsupported operation " + operation.Op)
+		}
+		if operation.Op == "remove" && operation.Path == "" {
+			return nil, errNoTarget
+		}
+		if operation.Op != "remove" && len(operation.Value) == 0 {
+			return nil, invalidValue("the operation requires a value")
+		}
+		operation.Path = normalizeAttribute(operation.Path)
+		request.Operations[i] = operation
+	}
+	return request.Operations, nil
+}
+
+// splitValuePath splits a path selecting values of a multi-valued attribute,
+// e.g. members[value eq "id"], into the attribute, the filter and the
+// sub-attribute. The filter is nil if the path contains none
+func splitValuePath(path string) (attribute string, valueFilter filter, subAttribute string, err error) {
+	start := strings.Index(path, "[")
+	if start == -1 {
+		return path, nil, "", nil
+	}
+	end := strings.LastIndex(path, "]")
+	if end < start {
+		return "", nil, "", errInvalidPath
+	}
+
+	valueFilter, err = parseFilter(path[start+1 : end])
+	if err != nil {
+		return "", nil, "", errInvalidPath
+	}
+	return path[:start], valueFilter, strings.TrimPrefix(path[end+1:], "."), nil
+}
+
+// readString reads a string value
+func readString(attribute string, value json.RawMessage) (string, error) {
+	var s string
+	err := json.Unmarshal(value, &s)
+	if err != nil {
+		return "", invalidValue(attribute + " needs to be a string")
+	}
+	return s, nil
+}
+
+// readBoolean reads a boolean value. Booleans sent as strings are accepted
+// as they are used by some identity management systems
+func readBoolean(attribute string, value json.RawMessage) (bool, error) {
+	var b *bool
+	if err := json.Unmarshal(value, &b); err == nil && b != nil {
+		return *b, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		switch strings.ToLower(s) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return false, invalidValue(attribute + " needs to be a boolean")
+}
@@ -0,0 +1,93 @@
+package scim
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestReadPatchRequest(t *testing.T) {
+	operations, err := readPatchRequest([]byte(`{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [
+			{"op": "Replace", "path": "urn:ietf:params:scim:schemas:core:2.0:User:userName", "value": "jdoe"},
+			{"op": "remove", "path": "emails[type eq \"work\"]"},
+			{"op": "add", "value": {"displayName": "Jane Doe"}}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("valid request rejected: %v", err)
+	}
+
+	expected := []patchOperation{
+		{Op: "replace", Path: "username", Value: json.RawMessage(`"jdoe"`)},
+		{Op: "remove", Path: `emails[type eq "work"]`},
+		{Op: "add", Value: json.RawMessage(`{"displayName": "Jane Doe"}`)},
+	}
+	if !reflect.DeepEqual(operations, expected) {
+		t.Errorf("expected %+v, got %+v", expected, operations)
+	}
+}
+
+func TestReadPatchRequestRejectsInvalidRequests(t *testing.T) {
+	tests := map[string]struct {
+		body     string
+		scimType string
+	}{
+		"malformed":            {`{"Operations": [`, "invalidSyntax"},
+		"noOperations":         {`{"Operations": []}`, "invalidValue"},
+		"unsupportedOperation": {`{"Operations": [{"op": "move", "path": "userName", "value": "jdoe"}]}`, "invalidValue"},
+		"removeWithoutPath":    {`{"Operations": [{"op": "remove"}]}`, "noTarget"},
+		"replaceWithoutValue":  {`{"Operations": [{"op": "replace", "path": "userName"}]}`, "invalidValue"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := readPatchRequest([]byte(test.body))
+			e, ok := err.(Error)
+			if !ok || e.ScimType != test.scimType {
+				t.Errorf("expected a %s error, got %v", test.scimType, err)
+			}
+		})
+	}
+}
+
+func TestSplitValuePath(t *testing.T) {
+	attribute, valueFilter, subAttribute, err := splitValuePath(`emails[type eq "work"].value`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attribute != "emails" || subAttribute != "value" {
+		t.Errorf("unexpected attribute %q and sub-attribute %q", attribute, subAttribute)
+	}
+	expectedFilter := attributeExpression{attribute: "type", operator: "eq", value: "work"}
+	if !reflect.DeepEqual(valueFilter, expectedFilter) {
+		t.Errorf("expected filter %#v, got %#v", expectedFilter, valueFilter)
+	}
+
+	attribute, valueFilter, subAttribute, err = splitValuePath("name.givenname")
+	if err != nil || attribute != "name.givenname" || valueFilter != nil || subAttribute != "" {
+		t.Errorf("unexpected split of a plain path: %q %v %q %v", attribute, valueFilter, subAttribute, err)
+	}
+
+	for _, path := range []string{`emails]type eq "work"[`, `emails[type eq]`} {
+		if _, _, _, err := splitValuePath(path); err != errInvalidPath {
+			t.Errorf("invalid path %s accepted: %v", path, err)
+		}
+	}
+}
+
+func TestReadBoolean(t *testing.T) {
+	tests := map[string]bool{`true`: true, `false`: false, `"True"`: true, `"false"`: false}
+	for rawValue, expected := range tests {
+		value, err := readBoolean("active", json.RawMessage(rawValue))
+		if err != nil || value != expected {
+			t.Errorf("expected %s to be read as %v, got %v (%v)", rawValue, expected, value, err)
+		}
+	}
+
+	for _, rawValue := range []string{`"yes"`, `1`, `null`} {
+		if _, err := readBoolean("active", json.RawMessage(rawValue)); err == nil {
+			t.Errorf("%s accepted as boolean", rawValue)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Package scim implements the SCIM 2.0 provisioning endpoint as defined in
+// RFC 7643 and RFC 7644.
+// Users are mapped onto the users of the service while groups represent the
+// scopes offered by the registered services. Adding a user to a group
+// assigns the scope to the user
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"microservice/dpop"
+)
+
+// ContentType is the media type of requests and responses
+const ContentType = "application/scim+json"
+
+// BasePath is the path under which the endpoint is mounted
+const BasePath = "/scim/v2"
+
+const (
+	UserSchema                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ResourceTypeSchema          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	ListResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+const (
+	// DefaultPageSize is used if no count has been requested
+	DefaultPageSize = 100
+
+	// MaxPageSize limits the number of resources returned on a single page
+	MaxPageSize = 200
+)
+
+// Error is the error response defined in RFC 7644, Section 3.12
+type Error struct {
+	Status   int    `json:"-"`
+	ScimType string `json:"scimType,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+func (e Error) Error() string {
+	return e.Detail
+}
+
+// MarshalJSON adds the schema and sends the status as string as required by
+// the specification
+func (e Error) MarshalJSON() ([]byte, error) {
+	type output struct {
+		Schemas  []string `json:"schemas"`
+		Status   string   `json:"status"`
+		ScimType string   `json:"scimType,omitempty"`
+		Detail   string   `json:"detail,omitempty"`
+	}
+	return json.Marshal(output{
+		Schemas:  []string{ErrorSchema},
+		Status:   strconv.Itoa(e.Status),
+		ScimType: e.ScimType,
+		Detail:   e.Detail,
+	})
+}
+
+// invalidValue creates an error for a request containing an unusable value
+func invalidValue(detail string) Error {
+	return Error{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: detail}
+}
+
+var (
+	errNotFound     = Error{Status: http.StatusNotFound, Detail: "the resource does not exist"}
+	errUniqueness   = Error{Status: http.StatusConflict, ScimType: "uniqueness", Detail: "the userName or externalId is already used by another user"}
+	errInvalidBody  = Error{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: "the request body could not be parsed"}
+	errLastAdmin    = Error{Status: http.StatusConflict, ScimType: "mutability", Detail: "the last active administrator may neither be deactivated nor deleted"}
+	errGroupDefined = Error{Status: http.StatusBadRequest, ScimType: "mutability", Detail: "groups are defined by the registered services and may not be created, renamed or deleted"}
+)
+
+// meta contains the metadata of a resource
+type meta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location"`
+}
+
+// listResponse is a page of resources
+type listResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    any      `json:"Resources"`
+}
+
+// listParameters contains the query parameters supported while listing
+// resources
+type listParameters struct {
+	Filter     string `form:"filter"`
+	StartIndex int    `form:"startIndex"`
+	Count      *int   `form:"count"`
+}
+
+// readListParameters reads the query parameters and applies the defaults.
+// Out of range values are clamped as required by RFC 7644, Section 3.4.2.4
+func readListParameters(c *gin.Context) (listParameters, error) {
+	var parameters listParameters
+	err := c.ShouldBindQuery(&parameters)
+	if err != nil {
+		return parameters, invalidValue(err.Error())
+	}
+
+	if parameters.StartIndex < 1 {
+		parameters.StartIndex = 1
+	}
+
+	count := DefaultPageSize
+	if parameters.Count != nil {
+		count = min(max(*parameters.Count, 0), MaxPageSize)
+	}
+	parameters.Count = &count
+	return parameters, nil
+}
+
+// normalizeAttribute converts the attribute path into lower case and removes
+// the schema of the core resources
+func normalizeAttribute(path string) string {
+	path = strings.ToLower(path)
+	for _, schema := range []string{UserSchema, GroupSchema} {
+		path = strings.TrimPrefix(path, strings.ToLower(schema)+":")
+	}
+	return path
+}
+
+// location returns the absolute URL of the resource. The URL of the endpoint
+// is derived from the URL the request has been sent to
+func location(c *gin.Context, path ...string) string {
+	requestURI := dpop.RequestURI(c.Request)
+	endpoint := requestURI[:strings.Index(requestURI, BasePath)+len(BasePath)]
+	return endpoint + "/" + strings.Join(path, "/")
+}
+
+// render sends the resource using the SCIM media type
+func render(c *gin.Context, status int, resource any) {
+	body, err := json.Marshal(resource)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+	c.Data(status, ContentType, body)
+}
+
+// emit sends the error. Errors which are not SCIM errors are passed to the
+// error handler
+func emit(c *gin.Context, err error) {
+	c.Abort()
+	if scimError, ok := err.(Error); ok {
+		render(c, scimError.Status, scimError)
+		return
+	}
+	_ = c.Error(err)
+}
+
+// bindJSON reads the request body. Clients may use either the SCIM media
+// type or plain JSON
+func bindJSON(c *gin.Context, target any) error {
+	body, err := c.GetRawData()
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(body, target)
+	if err != nil {
+		e := errInvalidBody
+		e.Detail = err.Error()
+		return e
+	}
+	return nil
+}
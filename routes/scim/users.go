@@ -0,0 +1,443 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"microservice/internal/db"
+	"microservice/types"
+	"microservice/utils"
+)
+
+// name contains the name of a user. Only the formatted name is stored, the
+// given and family name are used to build it if it is missing
+type name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// formatted returns the formatted name or builds it from the given and
+// family name
+func (n name) formatted() string {
+	if n.Formatted != "" {
+		return n.Formatted
+	}
+	return strings.TrimSpace(n.GivenName + " " + n.FamilyName)
+}
+
+// email is an email address of a user. Only a single address is stored
+type email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// primaryEmail returns the address marked as primary or the first address
+func primaryEmail(emails []email) string {
+	for _, email := range emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(emails) > 0 {
+		return emails[0].Value
+	}
+	return ""
+}
+
+// userResource is the representation of a user as defined in RFC 7643,
+// Section 4.1.
+// The externalId contains the identifier of the user at the external
+// provider and is used to match the user on their login
+type userResource struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	UserName    string   `json:"userName"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Name        *name    `json:"name,omitempty"`
+	Emails      []email  `json:"emails,omitempty"`
+	Active      *bool    `json:"active,omitempty"`
+	Meta        *meta    `json:"meta,omitempty"`
+}
+
+// newUserResource converts the user into its SCIM representation
+func newUserResource(c *gin.Context, user types.User) userResource {
+	active := !user.Disabled
+	resource := userResource{
+		Schemas:     []string{UserSchema},
+		ID:          user.ID,
+		ExternalID:  user.ExternalIdentifier,
+		UserName:    user.Username,
+		DisplayName: user.Name,
+		Active:      &active,
+		Meta:        &meta{ResourceType: "User", Location: location(c, "Users", user.ID)},
+	}
+	if user.Name != "" {
+		resource.Name = &name{Formatted: user.Name}
+	}
+	if user.Email != "" {
+		resource.Emails = []email{{Value: user.Email, Type: "work", Primary: true}}
+	}
+	return resource
+}
+
+// apply sets the attributes of the resource on the user. Users are active
+// unless stated otherwise.
+// The identity management system is authoritative for the users it
+// provisions, so the fields listed in UpstreamAuthoritativeFields may be
+// changed as well and the user is no longer synchronized with the external
+// provider
+func (r userResource) apply(user *types.User) error {
+	if strings.TrimSpace(r.UserName) == "" {
+		return invalidValue("userName is required")
+	}
+	if strings.TrimSpace(r.ExternalID) == "" {
+		return invalidValue("externalId is required")
+	}
+
+	user.ExternalIdentifier = r.ExternalID
+	user.Username = r.UserName
+	user.Name = r.DisplayName
+	if user.Name == "" && r.Name != nil {
+		user.Name = r.Name.formatted()
+	}
+	user.Email = primaryEmail(r.Emails)
+	user.Disabled = r.Active != nil && !*r.Active
+	user.ScimManaged = true
+	return nil
+}
+
+// userAttributes maps the filterable attributes onto the columns of the
+// users table
+var userAttributes = map[string]filterAttribute{
+	"id":             {column: "id::text", caseExact: true},
+	"externalid":     {column: "external_identifier", caseExact: true},
+	"username":       {column: "username"},
+	"displayname":    {column: "name"},
+	"name.formatted": {column: "name"},
+	"emails":         {column: "email"},
+	"emails.value":   {column: "email"},
+	"active":         {column: "disabled", boolean: true, inverted: true},
+}
+
+// ListUsers returns a page of the users matching the filter
+func ListUsers(c *gin.Context) {
+	parameters, err := readListParameters(c)
+	if err != nil {
+		emit(c, err)
+		return
+	}
+
+	condition := "TRUE"
+	var arguments []any
+	if parameters.Filter != "" {
+		f, err := parseFilter(parameters.Filter)
+		if err != nil {
+			emit(c, err)
+			return
+		}
+		condition, err = sqlCondition(f, userAttributes, &arguments)
+		if err != nil {
+			emit(c, err)
+			return
+		}
+	}
+
+	var totalResults int
+	err = pgxscan.Get(c, db.Pool, &totalResults, "SELECT count(*) FROM auth.users WHERE "+condition, arguments...)
+	if err != nil {
+		emit(c, err)
+		return
+	}
+
+	users := make([]types.User, 0)
+	if *parameters.Count > 0 {
+		query := fmt.Sprintf(
+			"SELECT * FROM auth.users WHERE %s ORDER BY username, id OFFSET $%d LIMIT $%d",
+			condition, len(arguments)+1, len(arguments)+2,
+		)
+		arguments = append(arguments, parameters.StartIndex-1, *parameters.Count)
+		err = pgxscan.Select(c, db.Pool, &users, query, arguments...)
+		if err != nil {
+			emit(c, err)
+			return
+		}
+	}
+
+	resources := make([]userResource, len(users))
+	for i, user := range users {
+		resources[i] = newUserResource(c, user)
+	}
+
+	render(c, http.StatusOK, listResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: totalResults,
+		StartIndex:   parameters.StartIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+// GetUser returns a single user
+func GetUser(c *gin.Context) {
+	userID := c.Param("userID")
+	if err := uuid.Validate(userID); err != nil {
+		emit(c, errNotFound)
+		return
+	}
+
+	user, err := utils.GetUser(types.InternalIdentifier(userID))
+	if err != nil {
+		emit(c, userError(err))
+		return
+	}
+
+	render(c, http.StatusOK, newUserResource(c, *user))
+}
+
+// CreateUser provisions a new user. The user is able to log in as soon as
+// the external provider reports the externalId as subject
+func CreateUser(c *gin.Context) {
+	var resource userResource
+	err := bindJSON(c, &resource)
+	if err != nil {
+		emit(c, err)
+		return
+	}
+
+	var user types.User
+	err = resource.apply(&user)
+	if err != nil {
+		emit(c, err)
+		return
+	}
+
+	createdUser, err := utils.ProvisionUser(c, user)
+	if err != nil {
+		emit(c, userError(err))
+		return
+	}
+
+	createdResource := newUserResource(c, *createdUser)
+	c.Header("Location", createdResource.Meta.Location)
+	render(c, http.StatusCreated, createdResource)
+}
+
+// ReplaceUser replaces the attributes of the user
+func ReplaceUser(c *gin.Context) {
+	userID := c.Param("userID")
+	if err := uuid.Validate(userID); err != nil {
+		emit(c, errNotFound)
+		return
+	}
+
+	var resource userResource
+	err := bindJSON(c, &resource)
+	if err != nil {
+		emit(c, err)
+		return
+	}
+
+	user, err := utils.UpdateUser(c, userID, resource.apply)
+	if err != nil {
+		emit(c, userError(err))
+		return
+	}
+
+	render(c, http.StatusOK, newUserResource(c, *user))
+}
+
+// PatchUser applies the operations of the PATCH request to the user
+func PatchUser(c *gin.Context) {
+	userID := c.Param("userID")
+	if err := uuid.Validate(userID); err != nil {
+		emit(c, errNotFound)
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		emit(c, err)
+		return
+	}
+
+	operations, err := readPatchRequest(body)
+	if err != nil {
+		emit(c, err)
+		return
+	}
+
+	user, err := utils.UpdateUser(c, userID, func(user *types.User) error {
+		user.ScimManaged = true
+		for _, operation := range operations {
+			err := applyUserOperation(user, operation)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		emit(c, userError(err))
+		return
+	}
+
+	render(c, http.StatusOK, newUserResource(c, *user))
+}
+
+// DeleteUser deletes the user. The last active administrator may not be
+// deleted
+func DeleteUser(c *gin.Context) {
+	userID := c.Param("userID")
+	if err := uuid.Validate(userID); err != nil {
+		emit(c, errNotFound)
+		return
+	}
+
+	err := utils.DeleteUser(c, userID)
+	if err != nil {
+		emit(c, userError(err))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// userError converts the errors returned while reading and changing users
+// into SCIM errors
+func userError(err error) error {
+	switch {
+	case errors.Is(err, utils.ErrNoUser):
+		return errNotFound
+	case errors.Is(err, utils.ErrUserConflict):
+		return errUniqueness
+	case errors.Is(err, utils.ErrLastAdministrator):
+		return errLastAdmin
+	default:
+		return err
+	}
+}
+
+// applyUserOperation applies a single PATCH operation to the user.
+// Operations without a path contain an object with the attributes to set
+func applyUserOperation(user *types.User, operation patchOperation) error {
+	if operation.Op == "remove" {
+		return removeUserAttribute(user, operation.Path)
+	}
+
+	if operation.Path != "" {
+		return setUserAttribute(user, operation.Path, operation.Value)
+	}
+
+	var attributes map[string]json.RawMessage
+	err := json.Unmarshal(operation.Value, &attributes)
+	if err != nil {
+		return invalidValue("operations without path require an object as value")
+	}
+	for attribute, value := range attributes {
+		err = setUserAttribute(user, normalizeAttribute(attribute), value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setUserAttribute sets the value of the attribute selected by the path
+func setUserAttribute(user *types.User, path string, value json.RawMessage) error {
+	attribute, valueFilter, subAttribute, err := splitValuePath(path)
+	if err != nil {
+		return err
+	}
+
+	// only a single email address is stored, so every address selected by a
+	// filter refers to it
+	if valueFilter != nil {
+		if attribute != "emails" || subAttribute != "value" {
+			return errInvalidPath
+		}
+		user.Email, err = readString(path, value)
+		return err
+	}
+
+	switch attribute {
+	case "active":
+		active, err := readBoolean(attribute, value)
+		if err != nil {
+			return err
+		}
+		user.Disabled = !active
+	case "username", "externalid":
+		s, err := readString(attribute, value)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(s) == "" {
+			return invalidValue(attribute + " may not be empty")
+		}
+		if attribute == "username" {
+			user.Username = s
+		} else {
+			user.ExternalIdentifier = s
+		}
+	case "displayname", "name.formatted":
+		user.Name, err = readString(attribute, value)
+		return err
+	case "name.givenname", "name.familyname":
+		// the name is only stored formatted
+	case "name":
+		var n name
+		if err := json.Unmarshal(value, &n); err != nil {
+			return invalidValue("name needs to be an object")
+		}
+		if formatted := n.formatted(); formatted != "" {
+			user.Name = formatted
+		}
+	case "emails":
+		var emails []email
+		if err := json.Unmarshal(value, &emails); err != nil {
+			return invalidValue("emails needs to be a list of email addresses")
+		}
+		user.Email = primaryEmail(emails)
+	case "emails.value":
+		user.Email, err = readString(attribute, value)
+		return err
+	case "id", "meta", "schemas":
+		return errMutability
+	default:
+		return errInvalidPath
+	}
+	return nil
+}
+
+// removeUserAttribute clears the attribute selected by the path. Required
+// attributes may not be removed
+func removeUserAttribute(user *types.User, path string) error {
+	attribute, _, _, err := splitValuePath(path)
+	if err != nil {
+		return err
+	}
+
+	switch attribute {
+	case "emails", "emails.value":
+		user.Email = ""
+	case "displayname", "name", "name.formatted":
+		user.Name = ""
+	case "name.givenname", "name.familyname":
+		// the name is only stored formatted
+	case "id", "meta", "schemas", "username", "externalid", "active":
+		return errMutability
+	default:
+		return errInvalidPath
+	}
+	return nil
+}
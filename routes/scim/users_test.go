@@ -0,0 +1,110 @@
+package scim
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"microservice/types"
+)
+
+func TestUserResourceApply(t *testing.T) {
+	active := false
+	resource := userResource{
+		UserName:   "jdoe",
+		ExternalID: "upstream-subject",
+		Name:       &name{GivenName: "Jane", FamilyName: "Doe"},
+		Emails:     []email{{Value: "private@example.com"}, {Value: "jane@example.com", Primary: true}},
+		Active:     &active,
+	}
+
+	var user types.User
+	err := resource.apply(&user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := types.User{
+		ExternalIdentifier: "upstream-subject",
+		Name:               "Jane Doe",
+		Email:              "jane@example.com",
+		Username:           "jdoe",
+		Disabled:           true,
+		ScimManaged:        true,
+	}
+	if !reflect.DeepEqual(user, expected) {
+		t.Errorf("expected %+v, got %+v", expected, user)
+	}
+
+	for _, invalidResource := range []userResource{{ExternalID: "upstream-subject"}, {UserName: "jdoe", ExternalID: " "}} {
+		if err := invalidResource.apply(&types.User{}); err == nil {
+			t.Errorf("resource without required attributes accepted: %+v", invalidResource)
+		}
+	}
+}
+
+func TestApplyUserOperation(t *testing.T) {
+	tests := map[string]struct {
+		operation patchOperation
+		expected  types.User
+	}{
+		"replaceActive": {
+			operation: patchOperation{Op: "replace", Path: "active", Value: json.RawMessage(`"False"`)},
+			expected:  types.User{Name: "John Doe", Username: "jdoe", Email: "jdoe@example.com", Disabled: true},
+		},
+		"replaceFilteredEmail": {
+			operation: patchOperation{Op: "replace", Path: `emails[type eq "work"].value`, Value: json.RawMessage(`"john@example.com"`)},
+			expected:  types.User{Name: "John Doe", Username: "jdoe", Email: "john@example.com"},
+		},
+		"replaceWithoutPath": {
+			operation: patchOperation{Op: "replace", Value: json.RawMessage(`{"displayName": "Jane Doe", "userName": "jane"}`)},
+			expected:  types.User{Name: "Jane Doe", Username: "jane", Email: "jdoe@example.com"},
+		},
+		"removeEmails": {
+			operation: patchOperation{Op: "remove", Path: "emails"},
+			expected:  types.User{Name: "John Doe", Username: "jdoe"},
+		},
+		"ignoreGivenName": {
+			operation: patchOperation{Op: "replace", Path: "name.givenname", Value: json.RawMessage(`"Jane"`)},
+			expected:  types.User{Name: "John Doe", Username: "jdoe", Email: "jdoe@example.com"},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			user := types.User{Name: "John Doe", Username: "jdoe", Email: "jdoe@example.com"}
+			err := applyUserOperation(&user, test.operation)
+			if err != nil {
+				t.Fatalf("valid operation rejected: %v", err)
+			}
+			if !reflect.DeepEqual(user, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, user)
+			}
+		})
+	}
+}
+
+func TestApplyUserOperationRejectsInvalidOperations(t *testing.T) {
+	tests := map[string]struct {
+		operation patchOperation
+		expected  error
+	}{
+		"replaceID":          {patchOperation{Op: "replace", Path: "id", Value: json.RawMessage(`"other"`)}, errMutability},
+		"removeUserName":     {patchOperation{Op: "remove", Path: "username"}, errMutability},
+		"removeActive":       {patchOperation{Op: "remove", Path: "active"}, errMutability},
+		"unknownAttribute":   {patchOperation{Op: "add", Path: "title", Value: json.RawMessage(`"Dr."`)}, errInvalidPath},
+		"filteredOtherValue": {patchOperation{Op: "replace", Path: `emails[type eq "work"].type`, Value: json.RawMessage(`"home"`)}, errInvalidPath},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := applyUserOperation(&types.User{Username: "jdoe"}, test.operation)
+			if err != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+
+	err := applyUserOperation(&types.User{Username: "jdoe"}, patchOperation{Op: "replace", Path: "username", Value: json.RawMessage(`""`)})
+	if e, ok := err.(Error); !ok || e.ScimType != "invalidValue" {
+		t.Errorf("empty user name accepted: %v", err)
+	}
+}
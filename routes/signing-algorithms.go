@@ -0,0 +1,68 @@
+package routes
+
+import (
+	"context"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/google/uuid"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+
+	"microservice/internal/db"
+	"microservice/internal/keys"
+	"microservice/types"
+)
+
+// resolveSigningAlgorithms sets the algorithms used to sign the access token
+// and ID token of the grant to the algorithms selected by the client the
+// tokens are issued to.
+// The algorithms are only resolved for authenticated clients, as the client
+// id sent by others can't be trusted. The default algorithm is used for
+// unauthenticated clients and instead of algorithms which have been disabled
+// since the registration of the client
+func resolveSigningAlgorithms(grant *tokenGrant) {
+	client := grant.authenticatedClient
+	if subject, isClient := grant.subject.(*types.Client); isClient {
+		client = subject
+	}
+
+	grant.accessTokenAlgorithm = keys.SigningAlgorithms[0]
+	grant.idTokenAlgorithm = keys.SigningAlgorithms[0]
+	if client == nil {
+		return
+	}
+
+	grant.accessTokenAlgorithm = selectedSigningAlgorithm(client.AccessTokenSigningAlgorithm)
+	grant.idTokenAlgorithm = selectedSigningAlgorithm(client.IDTokenSigningAlgorithm)
+}
+
+// selectedSigningAlgorithm returns the algorithm selected by a client if it
+// is still enabled. Otherwise, the default algorithm is returned
+func selectedSigningAlgorithm(algorithm *string) jwa.SignatureAlgorithm {
+	if algorithm == nil || !keys.IsSigningAlgorithmEnabled(jwa.SignatureAlgorithm(*algorithm)) {
+		return keys.SigningAlgorithms[0]
+	}
+	return jwa.SignatureAlgorithm(*algorithm)
+}
+
+// registeredClient returns the client registered with the id. If no client
+// has been registered, nil is returned
+func registeredClient(ctx context.Context, clientID string) (*types.Client, error) {
+	if uuid.Validate(clientID) != nil {
+		return nil, nil
+	}
+
+	query, err := db.Queries.Raw("get-client")
+	if err != nil {
+		return nil, err
+	}
+
+	var client types.Client
+	err = pgxscan.Get(ctx, db.Pool, &client, query, clientID)
+	if err != nil {
+		if pgxscan.NotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &client, nil
+}
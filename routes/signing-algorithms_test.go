@@ -0,0 +1,66 @@
+package routes
+
+import (
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+
+	"microservice/internal/keys"
+	"microservice/types"
+)
+
+func TestResolveSigningAlgorithms(t *testing.T) {
+	previousAlgorithms := keys.SigningAlgorithms
+	t.Cleanup(func() { keys.SigningAlgorithms = previousAlgorithms })
+	keys.SigningAlgorithms = []jwa.SignatureAlgorithm{jwa.ES256, jwa.RS256, jwa.EdDSA}
+
+	rs256 := jwa.RS256.String()
+	eddsa := jwa.EdDSA.String()
+	ps256 := jwa.PS256.String()
+	client := &types.Client{AccessTokenSigningAlgorithm: &rs256, IDTokenSigningAlgorithm: &eddsa}
+
+	tests := map[string]struct {
+		grant       tokenGrant
+		accessToken jwa.SignatureAlgorithm
+		idToken     jwa.SignatureAlgorithm
+	}{
+		"unauthenticated client": {
+			grant:       tokenGrant{clientID: "client"},
+			accessToken: jwa.ES256,
+			idToken:     jwa.ES256,
+		},
+		"authenticated client": {
+			grant:       tokenGrant{authenticatedClient: client},
+			accessToken: jwa.RS256,
+			idToken:     jwa.EdDSA,
+		},
+		"client credentials": {
+			grant:       tokenGrant{subject: client},
+			accessToken: jwa.RS256,
+			idToken:     jwa.EdDSA,
+		},
+		"no selected algorithms": {
+			grant:       tokenGrant{authenticatedClient: &types.Client{}},
+			accessToken: jwa.ES256,
+			idToken:     jwa.ES256,
+		},
+		"disabled algorithm": {
+			grant:       tokenGrant{authenticatedClient: &types.Client{AccessTokenSigningAlgorithm: &ps256, IDTokenSigningAlgorithm: &rs256}},
+			accessToken: jwa.ES256,
+			idToken:     jwa.RS256,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			grant := test.grant
+			resolveSigningAlgorithms(&grant)
+			if grant.accessTokenAlgorithm != test.accessToken {
+				t.Errorf("expected access token algorithm %s, got %s", test.accessToken, grant.accessTokenAlgorithm)
+			}
+			if grant.idTokenAlgorithm != test.idToken {
+				t.Errorf("expected id token algorithm %s, got %s", test.idToken, grant.idTokenAlgorithm)
+			}
+		})
+	}
+}
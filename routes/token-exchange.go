@@ -0,0 +1,162 @@
+package routes
+
+import (
+	"slices"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/gin-gonic/gin"
+
+	"microservice/dpop"
+	"microservice/internal/db"
+	apiErrors "microservice/internal/errors"
+	"microservice/types"
+	"microservice/utils"
+)
+
+const GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+const (
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// exchangeToken implements the token exchange as defined in RFC 8693.
+// It allows an authenticated client to exchange an access token of a user for
+// an access token with a narrower audience and scope set.
+// The issued token contains an act claim naming the client to allow the
+// receiving service to identify the party acting on behalf of the user.
+// As the exchanged token is only used for a single delegated call, no refresh
+// token is issued and the token does not outlive the subject token.
+// Subject tokens bound to a DPoP key can only be exchanged using a proof of
+// the same key
+func exchangeToken(c *gin.Context, tokenRequest TokenRequest) *tokenGrant {
+	client := authenticateClient(c, tokenRequest.ClientCredentials)
+	if client == nil {
+		return nil
+	}
+
+	if tokenRequest.SubjectToken == "" || tokenRequest.SubjectTokenType == "" {
+		c.Abort()
+		apiErrors.EmitOAuth(c, apiErrors.ErrMissingParameter)
+		return nil
+	}
+
+	if tokenRequest.SubjectTokenType != TokenTypeAccessToken && tokenRequest.SubjectTokenType != TokenTypeJWT {
+		c.Abort()
+		apiErrors.EmitOAuth(c, apiErrors.ErrUnsupportedTokenType)
+		return nil
+	}
+
+	if tokenRequest.RequestedTokenType != "" && tokenRequest.RequestedTokenType != TokenTypeAccessToken {
+		c.Abort()
+		apiErrors.EmitOAuth(c, apiErrors.ErrUnsupportedTokenType)
+		return nil
+	}
+
+	subjectToken, err := parseAccessToken([]byte(tokenRequest.SubjectToken))
+	if err != nil {
+		c.Abort()
+		res := apiErrors.ErrInvalidSubjectToken
+		res.Errors = []error{err}
+		apiErrors.EmitOAuth(c, res)
+		return nil
+	}
+
+	revoked, err := denyList.IsRevoked(c, subjectToken.JwtID())
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return nil
+	}
+	if revoked {
+		c.Abort()
+		apiErrors.EmitOAuth(c, apiErrors.ErrInvalidSubjectToken)
+		return nil
+	}
+
+	// a subject token bound to a key may only be exchanged together with a
+	// proof signed by the same key, which binds the issued token to it as well
+	boundThumbprint := dpop.ConfirmationThumbprint(subjectToken)
+	if boundThumbprint != "" && boundThumbprint != c.GetString(dpop.KeyThumbprint) {
+		c.Abort()
+		apiErrors.EmitOAuth(c, apiErrors.ErrDPoPKeyMismatch)
+		return nil
+	}
+
+	// only tokens issued to users may be exchanged as clients are able to
+	// request tokens on their own
+	if _, isClientToken := subjectToken.Get("client_id"); isClientToken {
+		c.Abort()
+		apiErrors.EmitOAuth(c, apiErrors.ErrInvalidSubjectToken)
+		return nil
+	}
+
+	user, err := utils.GetUser(types.InternalIdentifier(subjectToken.Subject()))
+	if err != nil {
+		c.Abort()
+		if err == utils.ErrNoUser {
+			apiErrors.EmitOAuth(c, apiErrors.ErrInvalidSubjectToken)
+			return nil
+		}
+		_ = c.Error(err)
+		return nil
+	}
+
+	audiences := subjectToken.Audience()
+	if len(tokenRequest.Audience) > 0 {
+		knownAudiences, err := availableAudiences(c)
+		if err != nil {
+			c.Abort()
+			_ = c.Error(err)
+			return nil
+		}
+		for _, audience := range tokenRequest.Audience {
+			if !slices.Contains(knownAudiences, audience) {
+				c.Abort()
+				apiErrors.EmitOAuth(c, apiErrors.ErrInvalidTarget)
+				return nil
+			}
+		}
+		audiences = tokenRequest.Audience
+	}
+
+	// nest the actor of an already exchanged token to keep the delegation
+	// chain visible for the receiving service
+	actor := map[string]any{"sub": client.GetID()}
+	if previousActor, set := subjectToken.Get("act"); set {
+		actor["act"] = previousActor
+	}
+
+	return &tokenGrant{
+		subject:             user,
+		scopes:              tokenScopes(subjectToken),
+		audiences:           audiences,
+		actor:               actor,
+		expiresBefore:       subjectToken.Expiration(),
+		withoutRefreshToken: true,
+		issuedTokenType:     TokenTypeAccessToken,
+		authenticatedClient: client,
+	}
+}
+
+// availableAudiences returns the audiences which may be requested for an
+// access token. This includes the default audiences and every registered
+// service
+func availableAudiences(c *gin.Context) ([]string, error) {
+	query, err := db.Queries.Raw("get-services")
+	if err != nil {
+		return nil, err
+	}
+
+	var services []types.Service
+	err = pgxscan.Select(c, db.Pool, &services, query)
+	if err != nil {
+		return nil, err
+	}
+
+	audiences := slices.Clone(TokenAudiences)
+	for _, service := range services {
+		audiences = append(audiences, service.Name)
+	}
+	return audiences, nil
+}
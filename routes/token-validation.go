@@ -0,0 +1,74 @@
+package routes
+
+import (
+	"github.com/lestrrat-go/jwx/v2/jwt"
+
+	"microservice/internal/db"
+	"microservice/internal/keys"
+	"microservice/revocation"
+)
+
+// denyList contains the access tokens which have been revoked before their
+// expiry
+var denyList = revocation.DenyList{Redis: db.Redis}
+
+// parseAccessToken verifies the signature of a serialized access token issued
+// by this service and validates the registered claims contained in it.
+// The signature may have been created by any key generation
+func parseAccessToken(rawToken []byte) (jwt.Token, error) {
+	return jwt.Parse(rawToken,
+		jwt.WithIssuer(TokenIssuer),
+		jwt.WithVerify(true),
+		jwt.WithValidate(true),
+		jwt.WithKeySet(keys.VerificationKeys()),
+	)
+}
+
+// parseRefreshToken decrypts a serialized refresh token issued by this service
+// and verifies the signature of the token contained in it.
+// Refresh tokens encrypted with the keys of retired generations are
+// accepted as well.
+// It does not check if the refresh token has been revoked in the meantime
+func parseRefreshToken(rawToken []byte) (jwt.Token, error) {
+	decryptedRefreshToken, err := keys.Decrypt(rawToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return jwt.Parse(decryptedRefreshToken,
+		jwt.WithIssuer(TokenIssuer),
+		jwt.WithVerify(true),
+		jwt.WithValidate(true),
+		jwt.WithKeySet(keys.VerificationKeys()),
+	)
+}
+
+// tokenScopes reads the scopes claim from the token
+func tokenScopes(token jwt.Token) []string {
+	return stringListClaim(token, "scopes")
+}
+
+// stringListClaim reads a claim containing a list of strings from the token.
+// As the claim may either be set by the token builder or read from a parsed
+// token, both representations of the claim are supported
+func stringListClaim(token jwt.Token, name string) []string {
+	claim, set := token.Get(name)
+	if !set {
+		return []string{}
+	}
+
+	switch values := claim.(type) {
+	case []string:
+		return values
+	case []any:
+		output := make([]string, 0, len(values))
+		for _, value := range values {
+			if s, ok := value.(string); ok {
+				output = append(output, s)
+			}
+		}
+		return output
+	default:
+		return []string{}
+	}
+}
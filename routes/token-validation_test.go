@@ -0,0 +1,152 @@
+package routes
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+
+	"microservice/internal/keys"
+)
+
+// buildToken creates a token as issued by the service
+func buildToken(t *testing.T, issuer string, expiration time.Time) jwt.Token {
+	t.Helper()
+	token, err := jwt.NewBuilder().
+		Issuer(issuer).
+		Subject("4c0aa8d4-1e6d-4d56-9d84-5d6a0b4d5f0e").
+		IssuedAt(time.Now()).
+		Expiration(expiration).
+		JwtID("token-id").
+		Claim("scopes", []string{"user-management:read", "example:write"}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// signToken signs the token using the active signing key of the service
+func signToken(t *testing.T, token jwt.Token) []byte {
+	t.Helper()
+	signingKey := keys.SigningKey()
+	serializedToken, err := jwt.Sign(token, jwt.WithKey(signingKey.Algorithm(), signingKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serializedToken
+}
+
+// encryptToken signs and encrypts the token like a refresh token
+func encryptToken(t *testing.T, token jwt.Token) []byte {
+	t.Helper()
+	serializer := jwt.NewSerializer()
+	serializer.Sign(jwt.WithKey(keys.SigningKey().Algorithm(), keys.SigningKey()))
+	serializer.Encrypt(jwt.WithKey(jwa.ECDH_ES, keys.EncryptionKey()))
+	serializedToken, err := serializer.Serialize(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serializedToken
+}
+
+func TestParseAccessToken(t *testing.T) {
+	rawToken := signToken(t, buildToken(t, TokenIssuer, time.Now().Add(time.Hour)))
+
+	token, err := parseAccessToken(rawToken)
+	if err != nil {
+		t.Fatalf("valid access token rejected: %v", err)
+	}
+	if scopes := tokenScopes(token); !slices.Equal(scopes, []string{"user-management:read", "example:write"}) {
+		t.Errorf("unexpected scopes %v", scopes)
+	}
+}
+
+func TestParseAccessTokenRejectsInvalidTokens(t *testing.T) {
+	foreignKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreignSigningKey, err := jwk.FromRaw(foreignKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreignToken, err := jwt.Sign(buildToken(t, TokenIssuer, time.Now().Add(time.Hour)), jwt.WithKey(jwa.ES256, foreignSigningKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]byte{
+		"expired":       signToken(t, buildToken(t, TokenIssuer, time.Now().Add(-time.Minute))),
+		"foreignIssuer": signToken(t, buildToken(t, "https://example.com", time.Now().Add(time.Hour))),
+		"foreignKey":    foreignToken,
+		"refreshToken":  encryptToken(t, buildToken(t, TokenIssuer, time.Now().Add(time.Hour))),
+	}
+	for name, rawToken := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseAccessToken(rawToken); err == nil {
+				t.Error("invalid access token accepted")
+			}
+		})
+	}
+}
+
+func TestParseRefreshToken(t *testing.T) {
+	rawToken := encryptToken(t, buildToken(t, TokenIssuer, time.Now().Add(time.Hour)))
+
+	token, err := parseRefreshToken(rawToken)
+	if err != nil {
+		t.Fatalf("valid refresh token rejected: %v", err)
+	}
+	if token.JwtID() != "token-id" {
+		t.Errorf("unexpected token id %q", token.JwtID())
+	}
+
+	_, err = parseRefreshToken(signToken(t, buildToken(t, TokenIssuer, time.Now().Add(time.Hour))))
+	if err == nil {
+		t.Error("unencrypted token accepted as refresh token")
+	}
+
+	_, err = parseRefreshToken(encryptToken(t, buildToken(t, TokenIssuer, time.Now().Add(-time.Minute))))
+	if err == nil {
+		t.Error("expired refresh token accepted")
+	}
+}
+
+func TestStringListClaim(t *testing.T) {
+	token := buildToken(t, TokenIssuer, time.Now().Add(time.Hour))
+	if err := token.Set("mixed", []any{"a", 1, "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := token.Set("single", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]string{
+		"scopes":  {"user-management:read", "example:write"},
+		"mixed":   {"a", "b"},
+		"single":  {},
+		"missing": {},
+	}
+	for claim, expected := range tests {
+		t.Run(claim, func(t *testing.T) {
+			if values := stringListClaim(token, claim); !slices.Equal(values, expected) {
+				t.Errorf("expected %v, got %v", expected, values)
+			}
+		})
+	}
+
+	parsedToken, err := parseAccessToken(signToken(t, token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scopes := tokenScopes(parsedToken); !slices.Equal(scopes, tests["scopes"]) {
+		t.Errorf("unexpected scopes of parsed token %v", scopes)
+	}
+}
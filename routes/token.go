@@ -5,23 +5,25 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/georgysavva/scany/v2/pgxscan"
 	"github.com/gin-gonic/gin"
-	"github.com/jackc/pgx/v5"
+	"github.com/google/uuid"
 	"github.com/lestrrat-go/jwx/v2/jwa"
-	"github.com/lestrrat-go/jwx/v2/jwe"
 	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
 	"github.com/thanhpk/randstr"
 	"golang.org/x/oauth2"
 
+	"microservice/dpop"
 	"microservice/interfaces"
 	"microservice/internal/db"
 	apiErrors "microservice/internal/errors"
-	"microservice/oidc"
-	"microservice/resources"
+	"microservice/internal/keys"
 	"microservice/types"
 	"microservice/utils"
 )
@@ -30,53 +32,190 @@ type TokenRequest struct {
 	GrantType    string `json:"grant_type" form:"grant_type" binding:"required"`
 	Code         string `json:"code" form:"code"`
 	State        string `json:"state" form:"state"`
-	ClientID     string `json:"client_id" form:"client_id"`
-	ClientSecret string `json:"client_secret" form:"client_secret"`
+	RedirectURI  string `json:"redirect_uri" form:"redirect_uri"`
+	CodeVerifier string `json:"code_verifier" form:"code_verifier"`
 	RefreshToken string `json:"refresh_token" form:"refresh_token"`
+	Scope        string `json:"scope" form:"scope"`
+
+	ClientCredentials
+
+	SubjectToken       string   `json:"subject_token" form:"subject_token"`
+	SubjectTokenType   string   `json:"subject_token_type" form:"subject_token_type"`
+	RequestedTokenType string   `json:"requested_token_type" form:"requested_token_type"`
+	Audience           []string `json:"audience" form:"audience"`
+
+	DeviceCode string `json:"device_code" form:"device_code"`
 }
 
 var TokenAudiences = []string{"user-management", "wisdom"}
 var RefreshTokenAudiences = []string{"wisdom"}
 
+// TokenIssuer is the issuer of the access and refresh tokens. It differs from
+// the IssuerURL as the other services of the platform expect it
 const TokenIssuer = "user-management"
 
+// proofValidator verifies the DPoP proofs sent to the token endpoint
+var proofValidator = dpop.Validator{Redis: db.Redis}
+
+// tokenGrant contains the information gathered while processing the grant
+// contained in a token request which is required to issue the token set
+type tokenGrant struct {
+	// subject is the user or client the token set is issued for
+	subject interfaces.PermissionableObject
+
+	// family contains the id of the refresh token family the refresh token
+	// issued with this grant is added to
+	family string
+
+	// scopes limits the scopes that may be issued with this grant.
+	// If no limit is set, every permission of the subject may be issued
+	scopes []string
+
+	// audiences overrides the default audiences of the access token
+	audiences []string
+
+	// actor contains the value of the act claim identifying the party acting
+	// on behalf of the subject
+	actor map[string]any
+
+	// expiresBefore limits the lifetime of the access token
+	expiresBefore time.Time
+
+	// withoutRefreshToken disables the issuing of a refresh token
+	withoutRefreshToken bool
+
+	// issuedTokenType contains the type of the issued access token which is
+	// only reported back to the client during a token exchange
+	issuedTokenType string
+
+	// openIDScopes contains the OpenID Connect scopes granted to the client.
+	// An ID token is only issued if the openid scope has been granted
+	openIDScopes []string
+
+	// clientID contains the identifier of the client that initiated the login
+	// and is used as the audience of the ID token
+	clientID string
+
+	// authenticatedClient contains the client which authenticated itself
+	// while requesting the tokens on behalf of the subject
+	authenticatedClient *types.Client
+
+	// nonce contains the nonce supplied while initiating the login
+	nonce string
+
+	// authTime contains the time at which the user authenticated
+	authTime time.Time
+
+	// keyThumbprint contains the thumbprint of the DPoP key the issued tokens
+	// are bound to
+	keyThumbprint string
+
+	// upstreamIDToken contains the ID token issued by the external provider
+	// if the grant is based on a login at the external provider
+	upstreamIDToken string
+
+	// upstreamClaims contains the claims copied from the ID token issued by
+	// the external provider. They are carried in the refresh token as the
+	// ID token is not available while refreshing
+	upstreamClaims map[string]any
+
+	// accessTokenAlgorithm and idTokenAlgorithm contain the algorithms used
+	// to sign the issued tokens
+	accessTokenAlgorithm jwa.SignatureAlgorithm
+	idTokenAlgorithm     jwa.SignatureAlgorithm
+}
+
 func Token(c *gin.Context) {
 	var tokenRequest TokenRequest
 	if err := c.ShouldBind(&tokenRequest); err != nil {
 		c.Abort()
-		_ = c.Error(err)
+		res := apiErrors.ErrMissingParameter
+		res.Errors = []error{err}
+		apiErrors.EmitOAuth(c, res)
+		return
+	}
+
+	if !readClientCredentials(c, &tokenRequest.ClientCredentials) {
 		return
 	}
 
-	var user interfaces.PermissionableObject
+	if len(c.Request.Header.Values(dpop.HeaderName)) > 0 {
+		// the token endpoint is identified by the issuer url instead of the
+		// headers of the request
+		proof, err := proofValidator.VerifyFor(c.Request, issuerURL(c, "token"), "")
+		if err != nil {
+			c.Abort()
+			res := apiErrors.ErrInvalidDPoPProof
+			res.Errors = []error{err}
+			apiErrors.EmitOAuth(c, res)
+			return
+		}
+		c.Set(dpop.KeyThumbprint, proof.Thumbprint)
+	}
+
+	var grant *tokenGrant
 	switch tokenRequest.GrantType {
 	case "client_credentials":
-		// TODO: Handle client credentials
-		user = checkClientCredentials(c, tokenRequest) // todo: fully implement client credentials
+		grant = checkClientCredentials(c, tokenRequest)
 	case "authorization_code":
-		user = exchangeAuthorizationCode(c, tokenRequest)
+		grant = exchangeAuthorizationCode(c, tokenRequest)
 	case "refresh_token":
-		user = issueFromRefreshToken(c, tokenRequest)
+		grant = issueFromRefreshToken(c, tokenRequest)
+	case GrantTypeTokenExchange:
+		grant = exchangeToken(c, tokenRequest)
+	case GrantTypeDeviceCode:
+		grant = exchangeDeviceCode(c, tokenRequest)
+	default:
+		c.Abort()
+		apiErrors.EmitOAuth(c, apiErrors.ErrUnsupportedGrantType)
+		return
 	}
 
 	if c.IsAborted() {
 		return
 	}
 
-	if user == nil {
+	if grant == nil {
 		c.Abort()
-		c.Status(500)
+		apiErrors.EmitOAuth(c, apiErrors.ErrInvalidGrant)
 		return
 	}
 
+	if grant.family == "" {
+		grant.family = uuid.NewString()
+	}
+
+	grant.keyThumbprint = c.GetString(dpop.KeyThumbprint)
+
+	if grant.upstreamClaims == nil && grant.upstreamIDToken != "" {
+		var err error
+		grant.upstreamClaims, err = extractUpstreamClaims(grant.upstreamIDToken)
+		if err != nil {
+			c.Abort()
+			_ = c.Error(err)
+			return
+		}
+	}
+
+	resolveSigningAlgorithms(grant)
+
+	user := grant.subject
+
 	if !user.IsActive() {
 		c.Abort()
-		apiErrors.ErrUserDisabled.Emit(c)
+		apiErrors.EmitOAuth(c, apiErrors.ErrUserDisabled)
+		return
+	}
+
+	userPermissions, err := user.Permissions(c)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
 		return
 	}
 
 	var permissions []string
-	for system, scopes := range user.Permissions() {
+	for system, scopes := range userPermissions {
 		for _, scope := range scopes {
 			scopeString := fmt.Sprintf("%s:%s", system, scope)
 			permissions = append(permissions, scopeString)
@@ -87,19 +226,74 @@ func Token(c *gin.Context) {
 		permissions = append(permissions, "*:*")
 	}
 
+	if grant.scopes != nil {
+		permissions = slices.DeleteFunc(permissions, func(permission string) bool {
+			return !slices.Contains(grant.scopes, permission)
+		})
+	}
+
+	requestedScopes, requestedOpenIDScopes := splitScopes(strings.Fields(tokenRequest.Scope))
+	for _, requestedScope := range requestedOpenIDScopes {
+		if !slices.Contains(grant.openIDScopes, requestedScope) {
+			c.Abort()
+			apiErrors.EmitOAuth(c, apiErrors.ErrInvalidScope)
+			return
+		}
+	}
+	if len(requestedOpenIDScopes) > 0 {
+		grant.openIDScopes = requestedOpenIDScopes
+	}
+
+	if len(requestedScopes) > 0 {
+		for _, requestedScope := range requestedScopes {
+			if !scopeGranted(permissions, requestedScope) {
+				c.Abort()
+				apiErrors.EmitOAuth(c, apiErrors.ErrInvalidScope)
+				return
+			}
+		}
+		slices.Sort(requestedScopes)
+		permissions = slices.Compact(requestedScopes)
+	}
+
 	if len(permissions) == 0 {
 		permissions = []string{}
 	}
 
+	expiration := time.Now().Add(time.Minute * 15)
+	if !grant.expiresBefore.IsZero() && grant.expiresBefore.Before(expiration) {
+		expiration = grant.expiresBefore
+	}
+
+	audiences := TokenAudiences
+	if len(grant.audiences) > 0 {
+		audiences = grant.audiences
+	}
+
 	tokenBuilder := jwt.NewBuilder()
-	tokenBuilder.Expiration(time.Now().Add(time.Minute * 15))
+	tokenBuilder.Expiration(expiration)
 	tokenBuilder.IssuedAt(time.Now())
 	tokenBuilder.NotBefore(time.Now())
 	tokenBuilder.Subject(user.GetID())
-	tokenBuilder.Audience(TokenAudiences)
+	tokenBuilder.Audience(audiences)
 	tokenBuilder.Issuer(TokenIssuer)
 	tokenBuilder.JwtID(randstr.Base62(256))
 	tokenBuilder.Claim("scopes", permissions)
+	if client, isClient := user.(*types.Client); isClient {
+		tokenBuilder.Claim("client_id", client.GetID())
+	}
+	if user, isUser := user.(*types.User); isUser {
+		addIdentityClaims(tokenBuilder, user, audiences, grant.upstreamClaims)
+		if grant.clientID != "" {
+			tokenBuilder.Claim("azp", grant.clientID)
+		}
+	}
+	if grant.actor != nil {
+		tokenBuilder.Claim("act", grant.actor)
+	}
+	if grant.keyThumbprint != "" {
+		tokenBuilder.Claim("cnf", map[string]string{"jkt": grant.keyThumbprint})
+	}
 
 	token, err := tokenBuilder.Build()
 	if err != nil {
@@ -108,8 +302,15 @@ func Token(c *gin.Context) {
 		return
 	}
 
+	signingKey, err := keys.SigningKeyFor(grant.accessTokenAlgorithm)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
 	serializer := jwt.NewSerializer()
-	serializer.Sign(jwt.WithKey(resources.PrivateSigningKey.Algorithm(), resources.PrivateSigningKey))
+	serializer.Sign(jwt.WithKey(signingKey.Algorithm(), signingKey))
 	serializedToken, err := serializer.Serialize(token)
 	if err != nil {
 		c.Abort()
@@ -117,230 +318,420 @@ func Token(c *gin.Context) {
 		return
 	}
 
+	tokenType := "Bearer"
+	if grant.keyThumbprint != "" {
+		tokenType = "DPoP"
+	}
+
+	res := types.TokenResponse{
+		AccessToken:     string(serializedToken),
+		ExpiresIn:       int(math.Ceil(time.Until(token.Expiration()).Seconds())),
+		TokenType:       tokenType,
+		Scope:           strings.Join(slices.Concat(grant.openIDScopes, permissions), " "),
+		IssuedTokenType: grant.issuedTokenType,
+	}
+
+	if user, isUser := grant.subject.(*types.User); isUser && slices.Contains(grant.openIDScopes, "openid") {
+		res.IDToken, err = buildIDToken(user, grant)
+		if err != nil {
+			c.Abort()
+			_ = c.Error(err)
+			return
+		}
+	}
+
+	if !grant.withoutRefreshToken {
+		// the access token stays usable even if the refresh token could not be
+		// issued, therefore the error is only recorded
+		res.RefreshToken, err = issueRefreshToken(c, grant, permissions)
+		if err != nil {
+			_ = c.Error(err)
+		}
+	}
+
+	c.JSON(200, res)
+}
+
+// issueRefreshToken builds, signs and encrypts a refresh token for the grant
+// and registers it in the database to allow revoking it
+func issueRefreshToken(c *gin.Context, grant *tokenGrant, permissions []string) (string, error) {
 	refreshTokenBuilder := jwt.NewBuilder()
 	refreshTokenBuilder.Expiration(time.Now().Add(time.Hour * 12))
 	refreshTokenBuilder.NotBefore(time.Now())
-	tokenBuilder.IssuedAt(time.Now())
-	refreshTokenBuilder.Subject(user.GetID())
+	refreshTokenBuilder.IssuedAt(time.Now())
+	refreshTokenBuilder.Subject(grant.subject.GetID())
 	refreshTokenBuilder.Issuer(TokenIssuer)
 	refreshTokenBuilder.Audience(RefreshTokenAudiences)
 	refreshTokenBuilder.Claim("scopes", permissions)
+	if client, isClient := grant.subject.(*types.Client); isClient {
+		refreshTokenBuilder.Claim("client_id", client.GetID())
+	}
+	if len(grant.openIDScopes) > 0 {
+		refreshTokenBuilder.Claim("openid_scopes", grant.openIDScopes)
+		refreshTokenBuilder.Claim("auth_time", grant.authTime.Unix())
+	}
+	if grant.clientID != "" {
+		refreshTokenBuilder.Claim("azp", grant.clientID)
+	}
+	if grant.keyThumbprint != "" {
+		refreshTokenBuilder.Claim("cnf", map[string]string{"jkt": grant.keyThumbprint})
+	}
+	if len(grant.upstreamClaims) > 0 {
+		refreshTokenBuilder.Claim("upstream_claims", grant.upstreamClaims)
+	}
 	refreshTokenBuilder.JwtID(randstr.Base62(128))
 	refreshToken, err := refreshTokenBuilder.Build()
 	if err != nil {
-		c.Abort()
-		_ = c.Error(err)
-		return
+		return "", err
 	}
 
-	serializer.Encrypt(jwt.WithKey(jwa.ECDH_ES, resources.PublicEncryptionKey))
+	serializer := jwt.NewSerializer()
+	serializer.Sign(jwt.WithKey(keys.SigningKey().Algorithm(), keys.SigningKey()))
+	serializer.Encrypt(jwt.WithKey(jwa.ECDH_ES, keys.EncryptionKey()))
 	serializedRefreshToken, err := serializer.Serialize(refreshToken)
 	if err != nil {
-		c.Abort()
-		_ = c.Error(err)
-		return
-	}
-
-	res := types.TokenResponse{
-		AccessToken:  string(serializedToken),
-		ExpiresIn:    int(math.Ceil(time.Until(token.Expiration()).Seconds())),
-		TokenType:    "Bearer",
-		RefreshToken: string(serializedRefreshToken),
+		return "", err
 	}
 
 	query, err := db.Queries.Raw("register-refresh-token")
 	if err != nil {
-		fmt.Println(err)
-		_ = c.Error(err)
-		res.RefreshToken = ""
-		goto output
+		return "", err
 	}
 
-	_, err = db.Pool.Exec(c, query, refreshToken.JwtID(), refreshToken.Expiration())
+	// the owner of the refresh token is recorded to allow listing and ending
+	// the sessions of a user
+	var userID *string
+	clientID := grant.clientID
+	switch subject := grant.subject.(type) {
+	case *types.User:
+		userID = &subject.ID
+	case *types.Client:
+		clientID = subject.GetID()
+	}
+
+	_, err = db.Pool.Exec(c, query, refreshToken.JwtID(), refreshToken.Expiration(), grant.family,
+		userID, clientID, c.ClientIP(), c.Request.UserAgent())
 	if err != nil {
-		res.RefreshToken = ""
-		fmt.Println(err)
-		_ = c.Error(err)
-		goto output
+		return "", err
 	}
 
-output:
-	c.JSON(200, res)
-}
+	if userID != nil {
+		err = storeUpstreamIDToken(c, grant.family, grant.upstreamIDToken, refreshToken.Expiration())
+		if err != nil {
+			return "", err
+		}
+	}
 
-func checkClientCredentials(c *gin.Context, tokenRequest TokenRequest) interfaces.PermissionableObject {
-	clientID := strings.TrimSpace(tokenRequest.ClientID)
-	clientSecret := strings.TrimSpace(tokenRequest.ClientSecret)
+	return string(serializedRefreshToken), nil
+}
 
-	if clientID == "" || clientSecret == "" {
-		c.Abort()
-		apiErrors.ErrMissingParameter.Emit(c)
+func checkClientCredentials(c *gin.Context, tokenRequest TokenRequest) *tokenGrant {
+	client := authenticateClient(c, tokenRequest.ClientCredentials)
+	if client == nil {
 		return nil
 	}
+	return &tokenGrant{subject: client}
+}
 
-	query, err := db.Queries.Raw("get-client")
-	if err != nil {
+// exchangeAuthorizationCode redeems an authorization code issued by the
+// callback after the user logged in
+func exchangeAuthorizationCode(c *gin.Context, tokenRequest TokenRequest) *tokenGrant {
+	if strings.TrimSpace(tokenRequest.Code) == "" {
 		c.Abort()
-		_ = c.Error(err)
+		apiErrors.EmitOAuth(c, apiErrors.ErrMissingParameter)
 		return nil
 	}
 
-	var client types.Client
-	err = pgxscan.Get(c, db.Pool, &client, query, clientID)
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			c.Abort()
-			apiErrors.ErrInvalidClientCredentials.Emit(c)
+	// only codes of the legacy flow are exchanged together with the state
+	if tokenRequest.State != "" {
+		return exchangeLegacyAuthorizationCode(c, tokenRequest)
+	}
+
+	// clients sending credentials need to authenticate themselves before the
+	// code is redeemed
+	var client *types.Client
+	clientID := tokenRequest.ClientID
+	if hasClientCredentials(tokenRequest.ClientCredentials) {
+		client = authenticateClient(c, tokenRequest.ClientCredentials)
+		if client == nil {
 			return nil
 		}
-		c.Abort()
-		_ = c.Error(err)
-		return nil
+		clientID = client.ID
 	}
 
-	err = client.ReadPermissions(clientID, clientSecret)
+	// the code may only be used once
+	rawAuthorizationCode, err := db.Redis.GetDel(c, authorizationCodeKeyPrefix+tokenRequest.Code).Bytes()
 	if err != nil {
-		if errors.Is(err, types.ErrInvalidSubject) {
-			c.Abort()
-			apiErrors.ErrInvalidClientCredentials.Emit(c)
-		}
 		c.Abort()
+		if errors.Is(err, redis.Nil) {
+			apiErrors.EmitOAuth(c, apiErrors.ErrInvalidGrant)
+			return nil
+		}
 		_ = c.Error(err)
 		return nil
 	}
-	return client
-}
 
-func exchangeAuthorizationCode(c *gin.Context, tokenRequest TokenRequest) interfaces.PermissionableObject {
-	// retrieve the verifier from the database
-	params, err := db.Redis.Get(c, tokenRequest.State).Bytes()
+	var authorizationCode types.AuthorizationCode
+	err = json.Unmarshal(rawAuthorizationCode, &authorizationCode)
 	if err != nil {
 		c.Abort()
 		_ = c.Error(err)
 		return nil
 	}
 
-	tokenParams := types.LoginParameters{}
-	json.Unmarshal(params, &tokenParams)
+	// the code is only accepted from the client that initiated the login and
+	// with the redirect uri it has been sent to (RFC 6749, Section 4.1.3)
+	if clientID != authorizationCode.ClientID || tokenRequest.RedirectURI != authorizationCode.RedirectUri {
+		c.Abort()
+		apiErrors.EmitOAuth(c, apiErrors.ErrInvalidGrant)
+		return nil
+	}
 
-	if strings.TrimSpace(tokenRequest.Code) == "" || strings.TrimSpace(tokenRequest.State) == "" {
+	if authorizationCode.CodeChallenge != "" && oauth2.S256ChallengeFromVerifier(tokenRequest.CodeVerifier) != authorizationCode.CodeChallenge {
 		c.Abort()
-		apiErrors.ErrMissingParameter.Emit(c)
+		apiErrors.EmitOAuth(c, apiErrors.ErrInvalidGrant)
 		return nil
 	}
 
-	// now exchange the code for a token
-	token, err := oidc.ExternalProvider.Exchange(c, tokenRequest.Code, oauth2.VerifierOption(tokenParams.CodeVerifier), oauth2.SetAuthURLParam("state", tokenRequest.State), oauth2.SetAuthURLParam("redirect_uri", tokenParams.RedirectUri))
+	user, err := utils.GetUser(types.InternalIdentifier(authorizationCode.UserID))
 	if err != nil {
 		c.Abort()
 		_ = c.Error(err)
 		return nil
 	}
 
-	rawIDToken, ok := token.Extra("id_token").(string)
-	if !ok {
-		c.Abort()
-		c.Status(500)
-		return nil
+	// the openid scopes may be requested while initiating the login or while
+	// exchanging the authorization code
+	_, openIDScopes := splitScopes(strings.Fields(authorizationCode.Scope + " " + tokenRequest.Scope))
+	slices.Sort(openIDScopes)
+
+	return &tokenGrant{
+		subject:             user,
+		openIDScopes:        slices.Compact(openIDScopes),
+		clientID:            authorizationCode.ClientID,
+		authenticatedClient: client,
+		nonce:               authorizationCode.Nonce,
+		authTime:            authorizationCode.AuthTime,
+		upstreamIDToken:     authorizationCode.UpstreamIDToken,
 	}
+}
 
-	idToken, err := oidc.TokenVerifier.Verify(c, rawIDToken)
+// exchangeLegacyAuthorizationCode exchanges the authorization code issued by
+// the external provider to a client which started the login without a client
+// id
+func exchangeLegacyAuthorizationCode(c *gin.Context, tokenRequest TokenRequest) *tokenGrant {
+	params, err := db.Redis.GetDel(c, tokenRequest.State).Bytes()
 	if err != nil {
 		c.Abort()
+		if errors.Is(err, redis.Nil) {
+			apiErrors.EmitOAuth(c, apiErrors.ErrInvalidGrant)
+			return nil
+		}
 		_ = c.Error(err)
 		return nil
 	}
 
-	var user *types.User
-	user, err = utils.GetUser(types.ExternalIdentifier(idToken.Subject))
+	var tokenParams types.LoginParameters
+	_ = json.Unmarshal(params, &tokenParams)
+
+	// logins of registered clients and devices are finished by the callbacks
+	// of this service
+	if tokenParams.ClientRedirectUri != "" || tokenParams.DeviceCode != "" {
+		c.Abort()
+		apiErrors.EmitOAuth(c, apiErrors.ErrInvalidGrant)
+		return nil
+	}
+
+	login, err := authenticateUpstream(c, tokenRequest.Code, tokenRequest.State, tokenParams)
 	if err != nil {
-		if err == utils.ErrNoUser {
-			newUser, err := utils.CreateUser(idToken.Subject, token)
-			if err != nil {
-				fmt.Println("error while creating user")
-				c.Abort()
-				_ = c.Error(err)
-				return nil
-			}
-			return newUser
-		}
 		c.Abort()
+		// the external provider rejects codes which are invalid or have
+		// already been used
+		var retrieveError *oauth2.RetrieveError
+		if errors.As(err, &retrieveError) {
+			res := apiErrors.ErrInvalidGrant
+			res.Errors = []error{err}
+			apiErrors.EmitOAuth(c, res)
+			return nil
+		}
 		_ = c.Error(err)
 		return nil
 	}
 
-	return user
+	_, openIDScopes := splitScopes(strings.Fields(tokenParams.Scope + " " + tokenRequest.Scope))
+	slices.Sort(openIDScopes)
+
+	return &tokenGrant{
+		subject:         login.user,
+		openIDScopes:    slices.Compact(openIDScopes),
+		clientID:        tokenRequest.ClientID,
+		nonce:           tokenParams.Nonce,
+		authTime:        upstreamAuthTime(login.idToken),
+		upstreamIDToken: login.rawIDToken,
+	}
+}
+
+// scopeGranted reports if the scope is contained in the permissions.
+// The administrator scope (*:*) grants every scope of every service and the
+// wildcard scope of a service (<service>:*) every scope of the service, which
+// allows requesting tokens limited to single scopes
+func scopeGranted(permissions []string, scope string) bool {
+	if slices.Contains(permissions, scope) {
+		return true
+	}
+	service, level, found := strings.Cut(scope, ":")
+	if !found || service == "" || level == "" {
+		return false
+	}
+	return slices.Contains(permissions, "*:*") || slices.Contains(permissions, service+":*")
 }
 
 // issueFromRefreshToken is the only function that issues tokens directly as
 // a user can only gain access to the scopes already present while generating
-// the refresh token
-func issueFromRefreshToken(c *gin.Context, tokenRequest TokenRequest) interfaces.PermissionableObject {
-	decryptedRefreshToken, err := jwe.Decrypt(
-		[]byte(tokenRequest.RefreshToken),
-		jwe.WithKey(jwa.ECDH_ES, resources.PrivateEncryptionKey),
-	)
+// the refresh token.
+// The presented refresh token is consumed and the newly issued refresh token
+// is added to the same family.
+// If a refresh token is presented after it has already been consumed, the
+// whole family is revoked as the token has been leaked
+func issueFromRefreshToken(c *gin.Context, tokenRequest TokenRequest) *tokenGrant {
+	grantingRefreshToken, err := parseRefreshToken([]byte(tokenRequest.RefreshToken))
 	if err != nil {
 		c.Abort()
-		apiErrors.ErrRefreshTokenInvalid.Emit(c)
+		apiErrors.EmitOAuth(c, apiErrors.ErrRefreshTokenInvalid)
 		return nil
 	}
 
-	grantingRefreshToken, err := jwt.Parse(decryptedRefreshToken,
-		jwt.WithIssuer("user-management"),
-		jwt.WithVerify(true),
-		jwt.WithKey(resources.PublicSigningKey.Algorithm(), resources.PublicSigningKey),
-	)
-	if err != nil {
+	// a refresh token bound to a key may only be used together with a proof
+	// signed by the same key
+	boundThumbprint := dpop.ConfirmationThumbprint(grantingRefreshToken)
+	if boundThumbprint != "" && boundThumbprint != c.GetString(dpop.KeyThumbprint) {
 		c.Abort()
-		apiErrors.ErrRefreshTokenInvalid.Emit(c)
+		apiErrors.EmitOAuth(c, apiErrors.ErrDPoPKeyMismatch)
 		return nil
 	}
 
-	query, err := db.Queries.Raw("check-for-refresh-token")
+	// clients sending credentials need to authenticate themselves and may
+	// only use refresh tokens issued to them
+	var client *types.Client
+	if hasClientCredentials(tokenRequest.ClientCredentials) {
+		client = authenticateClient(c, tokenRequest.ClientCredentials)
+		if client == nil {
+			return nil
+		}
+		if clientID, _ := grantingRefreshToken.Get("azp"); clientID != client.ID {
+			c.Abort()
+			apiErrors.EmitOAuth(c, apiErrors.ErrInvalidGrant)
+			return nil
+		}
+	}
+
+	query, err := db.Queries.Raw("consume-refresh-token")
 	if err != nil {
 		c.Abort()
 		_ = c.Error(err)
 		return nil
 	}
 
-	var tokenAlive bool
-	err = pgxscan.Get(c, db.Pool, &tokenAlive, query, grantingRefreshToken.JwtID())
+	var family *string
+	err = pgxscan.Get(c, db.Pool, &family, query, grantingRefreshToken.JwtID())
 	if err != nil {
+		if pgxscan.NotFound(err) {
+			detectRefreshTokenReuse(c, grantingRefreshToken)
+			if c.IsAborted() {
+				return nil
+			}
+			c.Abort()
+			apiErrors.EmitOAuth(c, apiErrors.ErrRefreshTokenInvalid)
+			return nil
+		}
 		c.Abort()
 		_ = c.Error(err)
 		return nil
 	}
 
-	if !tokenAlive {
+	var user *types.User
+	user, err = utils.GetUser(types.InternalIdentifier(grantingRefreshToken.Subject()))
+	if err != nil {
+		if err == utils.ErrNoUser {
+			c.Abort()
+			apiErrors.EmitOAuth(c, apiErrors.ErrInvalidGrant)
+			return nil
+		}
 		c.Abort()
-		apiErrors.ErrRefreshTokenInvalid.Emit(c)
+		_ = c.Error(err)
 		return nil
 	}
 
-	query, err = db.Queries.Raw("revoke-refresh-token")
+	grant := &tokenGrant{
+		subject:             user,
+		scopes:              tokenScopes(grantingRefreshToken),
+		openIDScopes:        stringListClaim(grantingRefreshToken, "openid_scopes"),
+		authenticatedClient: client,
+	}
+	if authTime, set := grantingRefreshToken.Get("auth_time"); set {
+		if authTime, ok := authTime.(float64); ok {
+			grant.authTime = time.Unix(int64(authTime), 0)
+		}
+	}
+	if clientID, set := grantingRefreshToken.Get("azp"); set {
+		grant.clientID, _ = clientID.(string)
+	}
+	if upstreamClaims, set := grantingRefreshToken.Get("upstream_claims"); set {
+		grant.upstreamClaims, _ = upstreamClaims.(map[string]any)
+	}
+	if family != nil {
+		grant.family = *family
+	}
+	return grant
+}
+
+// detectRefreshTokenReuse checks if the refresh token has already been
+// consumed.
+// In that case the refresh token is presented a second time which may only
+// happen if it has been leaked. Therefore, every refresh token of the family
+// is revoked and a security event is logged.
+func detectRefreshTokenReuse(c *gin.Context, refreshToken jwt.Token) {
+	query, err := db.Queries.Raw("get-refresh-token")
 	if err != nil {
 		c.Abort()
 		_ = c.Error(err)
+		return
 	}
 
-	_, err = db.Pool.Exec(c, query, grantingRefreshToken.JwtID())
+	var storedToken types.RefreshToken
+	err = pgxscan.Get(c, db.Pool, &storedToken, query, refreshToken.JwtID())
 	if err != nil {
+		if pgxscan.NotFound(err) {
+			return
+		}
 		c.Abort()
 		_ = c.Error(err)
+		return
 	}
 
-	var user *types.User
-	user, err = utils.GetUser(types.InternalIdentifier(grantingRefreshToken.Subject()))
+	if storedToken.Active || storedToken.Family == nil {
+		return
+	}
+
+	query, err = db.Queries.Raw("revoke-refresh-token-family")
 	if err != nil {
-		if err == utils.ErrNoUser {
-			return nil
-		}
 		c.Abort()
 		_ = c.Error(err)
-		return nil
+		return
 	}
 
-	return user
+	_, err = db.Pool.Exec(c, query, *storedToken.Family)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
 
+	log.Warn().
+		Str("event", "refresh-token-reuse").
+		Str("family", *storedToken.Family).
+		Str("subject", refreshToken.Subject()).
+		Str("tokenID", refreshToken.JwtID()).
+		Str("clientIP", c.ClientIP()).
+		Msg("revoked refresh token family due to reuse of a consumed refresh token")
 }
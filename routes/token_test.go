@@ -0,0 +1,109 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"microservice/dpop"
+)
+
+// oauthErrorCode returns the OAuth 2.0 error code sent in the response
+func oauthErrorCode(t *testing.T, recorder *httptest.ResponseRecorder) string {
+	t.Helper()
+	var response struct {
+		Error string `json:"error"`
+	}
+	err := json.Unmarshal(recorder.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("unexpected response %q: %v", recorder.Body.String(), err)
+	}
+	return response.Error
+}
+
+// refreshTokenRequest creates a context for a refresh token grant. The
+// thumbprint is set as if the request contained a proof signed by the key
+func refreshTokenRequest(refreshToken string, thumbprint string) (*gin.Context, *httptest.ResponseRecorder) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/token", nil)
+	if thumbprint != "" {
+		c.Set(dpop.KeyThumbprint, thumbprint)
+	}
+	return c, recorder
+}
+
+// The refresh tokens are rejected before they are consumed, as consuming
+// them requires the database
+func TestIssueFromRefreshTokenRejectsInvalidTokens(t *testing.T) {
+	tests := map[string]string{
+		"malformed":   "not-a-token",
+		"accessToken": string(signToken(t, buildToken(t, TokenIssuer, time.Now().Add(time.Hour)))),
+		"expired":     string(encryptToken(t, buildToken(t, TokenIssuer, time.Now().Add(-time.Minute)))),
+	}
+	for name, refreshToken := range tests {
+		t.Run(name, func(t *testing.T) {
+			c, recorder := refreshTokenRequest(refreshToken, "")
+			grant := issueFromRefreshToken(c, TokenRequest{RefreshToken: refreshToken})
+			if grant != nil || !c.IsAborted() {
+				t.Fatal("invalid refresh token accepted")
+			}
+			if code := oauthErrorCode(t, recorder); code != "invalid_grant" {
+				t.Errorf("expected invalid_grant, got %q", code)
+			}
+		})
+	}
+}
+
+func TestScopeGranted(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions []string
+		scope       string
+		granted     bool
+	}{
+		{"contained", []string{"user-management:read", "example:write"}, "example:write", true},
+		{"missing", []string{"user-management:read"}, "example:write", false},
+		{"otherLevel", []string{"example:read"}, "example:write", false},
+		{"administrator", []string{"*:*"}, "example:write", true},
+		{"administratorWildcard", []string{"*:*"}, "*:*", true},
+		{"administratorWithoutLevel", []string{"*:*"}, "example", false},
+		{"administratorEmptyService", []string{"*:*"}, ":write", false},
+		{"serviceWildcard", []string{"example:*"}, "example:write", true},
+		{"otherServiceWildcard", []string{"other:*"}, "example:write", false},
+		{"serviceWildcardWithoutLevel", []string{"example:*"}, "example:", false},
+		{"noPermissions", nil, "example:read", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if granted := scopeGranted(test.permissions, test.scope); granted != test.granted {
+				t.Errorf("expected %v, got %v", test.granted, granted)
+			}
+		})
+	}
+}
+
+func TestIssueFromRefreshTokenRequiresBoundKey(t *testing.T) {
+	token := buildToken(t, TokenIssuer, time.Now().Add(time.Hour))
+	if err := token.Set("cnf", map[string]string{"jkt": "bound-thumbprint"}); err != nil {
+		t.Fatal(err)
+	}
+	refreshToken := string(encryptToken(t, token))
+
+	for name, thumbprint := range map[string]string{"withoutProof": "", "otherKey": "other-thumbprint"} {
+		t.Run(name, func(t *testing.T) {
+			c, recorder := refreshTokenRequest(refreshToken, thumbprint)
+			grant := issueFromRefreshToken(c, TokenRequest{RefreshToken: refreshToken})
+			if grant != nil || !c.IsAborted() {
+				t.Fatal("bound refresh token accepted without a proof of the bound key")
+			}
+			if code := oauthErrorCode(t, recorder); code != "invalid_dpop_proof" {
+				t.Errorf("expected invalid_dpop_proof, got %q", code)
+			}
+		})
+	}
+}
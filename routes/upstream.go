@@ -0,0 +1,124 @@
+package routes
+
+import (
+	"errors"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+
+	"microservice/internal/db"
+	"microservice/oidc"
+	"microservice/types"
+	"microservice/utils"
+)
+
+var errNoUpstreamIDToken = errors.New("upstream token response contains no id_token")
+
+// upstreamIDTokenKeyPrefix is used for the keys storing the ID token issued
+// by the external provider for a session. The ID token is required to end the
+// session at the external provider
+const upstreamIDTokenKeyPrefix = "upstream-id-token:"
+
+// upstreamSessionKeyPrefix is used for the keys storing the sessions which
+// have been started using a session at the external provider. They are
+// required to end the sessions if the external provider reports the end of
+// its session
+const upstreamSessionKeyPrefix = "upstream-session:"
+
+// upstreamLogin contains the result of a successful login at the external
+// provider
+type upstreamLogin struct {
+	user       *types.User
+	idToken    *gooidc.IDToken
+	rawIDToken string
+}
+
+// authenticateUpstream exchanges an authorization code issued by the external
+// provider and returns the user the code has been issued to together with the
+// verified ID token.
+// If the user logs in for the first time, the user is created. Otherwise,
+// the profile of the user is synchronized with the external provider
+func authenticateUpstream(c *gin.Context, code string, state string, params types.LoginParameters) (*upstreamLogin, error) {
+	token, err := oidc.ExternalProvider.Exchange(c, code, oauth2.VerifierOption(params.CodeVerifier), oauth2.SetAuthURLParam("state", state), oauth2.SetAuthURLParam("redirect_uri", params.RedirectUri))
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errNoUpstreamIDToken
+	}
+
+	idToken, err := oidc.TokenVerifier.Verify(c, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := utils.GetUser(types.ExternalIdentifier(idToken.Subject))
+	switch {
+	case errors.Is(err, utils.ErrNoUser):
+		user, err = utils.CreateUser(c, token, idToken)
+		if err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		// the login is not rejected if the profile can't be synchronized as
+		// the stored profile is still usable
+		syncedUser, err := utils.SyncUser(c, user, token, idToken)
+		if err != nil {
+			log.Warn().Err(err).Str("user", user.ID).Msg("unable to synchronize user profile")
+			break
+		}
+		user = syncedUser
+	}
+
+	return &upstreamLogin{user: user, idToken: idToken, rawIDToken: rawIDToken}, nil
+}
+
+// storeUpstreamIDToken keeps the ID token issued by the external provider
+// for the session until the refresh token issued for the session expires.
+// If no ID token is supplied, the expiry of an already stored ID token is
+// extended as the session has been continued
+func storeUpstreamIDToken(c *gin.Context, session string, rawIDToken string, expiresAt time.Time) error {
+	if rawIDToken == "" {
+		return db.Redis.ExpireAt(c, upstreamIDTokenKeyPrefix+session, expiresAt).Err()
+	}
+
+	// the ID token has already been verified during the login
+	idToken, err := jwt.ParseInsecure([]byte(rawIDToken))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Redis.TxPipelined(c, func(pipe redis.Pipeliner) error {
+		pipe.Set(c, upstreamIDTokenKeyPrefix+session, rawIDToken, time.Until(expiresAt))
+		if upstreamSession, _ := idToken.Get("sid"); upstreamSession != nil {
+			if upstreamSessionID, ok := upstreamSession.(string); ok && upstreamSessionID != "" {
+				pipe.SAdd(c, upstreamSessionKeyPrefix+upstreamSessionID, session)
+				pipe.ExpireAt(c, upstreamSessionKeyPrefix+upstreamSessionID, expiresAt)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// upstreamAuthTime returns the time the user authenticated at the external
+// provider. If the provider did not include the time in the ID token, the
+// current time is used
+func upstreamAuthTime(idToken *gooidc.IDToken) time.Time {
+	var claims struct {
+		AuthTime int64 `json:"auth_time"`
+	}
+	if err := idToken.Claims(&claims); err != nil || claims.AuthTime == 0 {
+		return time.Now()
+	}
+	return time.Unix(claims.AuthTime, 0)
+}
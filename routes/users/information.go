@@ -35,6 +35,13 @@ func Information(c *gin.Context) {
 		return
 	}
 
+	err = types.LoadPermissions(c, user)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
 	c.JSON(200, user)
 
 }
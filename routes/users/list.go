@@ -1,31 +1,123 @@
 package users
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/georgysavva/scany/v2/pgxscan"
 	"github.com/gin-gonic/gin"
-	"github.com/jackc/pgx/v5"
+	"github.com/google/uuid"
 
 	"microservice/internal/db"
+	apiErrors "microservice/internal/errors"
 	"microservice/types"
 )
 
+const (
+	// DefaultPageSize is used if no limit has been requested
+	DefaultPageSize = 50
+
+	// MaxPageSize limits the number of users returned on a single page
+	MaxPageSize = 200
+)
+
+// NextCursorHeader contains the cursor of the next page
+const NextCursorHeader = "X-Next-Cursor"
+
+// sortFields contains the fields the user list may be sorted by
+var sortFields = []string{"name", "username", "email"}
+
+var errInvalidCursor = errors.New("invalid cursor")
+var errInvalidSort = fmt.Errorf("sort needs to be one of %s, optionally prefixed with -", strings.Join(sortFields, ", "))
+var errInvalidPermission = errors.New("permission needs to be in the format service or service:level")
+
+// cursor identifies the last user of a page. It contains the sort order to
+// reject cursors which are used with another sort order
+type cursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   string `json:"i"`
+}
+
+// listParameters contains the query parameters supported while listing users
+type listParameters struct {
+	Limit         int    `form:"limit"`
+	Cursor        string `form:"cursor"`
+	Search        string `form:"q"`
+	Disabled      *bool  `form:"disabled"`
+	Administrator *bool  `form:"administrator"`
+	Permission    string `form:"permission"`
+	Sort          string `form:"sort"`
+}
+
+// List returns a page of the users matching the filters.
+// The cursor of the next page is returned in the X-Next-Cursor header and as
+// link with the relation next
 func List(c *gin.Context) {
-	query, err := db.Queries.Raw("get-users")
+	var parameters listParameters
+	err := c.ShouldBindQuery(&parameters)
+	if err != nil {
+		c.Abort()
+		res := apiErrors.ErrInvalidListParameters
+		res.Errors = []error{err}
+		res.Emit(c)
+		return
+	}
+
+	queryArguments, err := parameters.queryArguments()
+	if err != nil {
+		c.Abort()
+		res := apiErrors.ErrInvalidListParameters
+		res.Errors = []error{err}
+		res.Emit(c)
+		return
+	}
+
+	queryName := "get-users-page-ascending"
+	if strings.HasPrefix(parameters.Sort, "-") {
+		queryName = "get-users-page-descending"
+	}
+
+	query, err := db.Queries.Raw(queryName)
 	if err != nil {
 		c.Abort()
 		_ = c.Error(err)
 		return
 	}
 
-	var users []types.User
-	err = pgxscan.Select(c, db.Pool, &users, query)
+	// an additional user is requested to know if there is a next page
+	users := make([]types.User, 0)
+	err = pgxscan.Select(c, db.Pool, &users, query, append(queryArguments, parameters.Limit+1)...)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	if len(users) > parameters.Limit {
+		users = users[:parameters.Limit]
+		nextCursor := parameters.nextCursor(users[len(users)-1])
+		c.Header(NextCursorHeader, nextCursor)
+
+		nextPage := *c.Request.URL
+		query := nextPage.Query()
+		query.Set("cursor", nextCursor)
+		nextPage.RawQuery = query.Encode()
+		c.Header("Link", fmt.Sprintf(`<%s>; rel="next"`, nextPage.RequestURI()))
+	}
+
+	pageUsers := make([]*types.User, len(users))
+	for i := range users {
+		pageUsers[i] = &users[i]
+	}
+
+	err = types.LoadPermissions(c, pageUsers...)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			c.Status(http.StatusNoContent)
-			return
-		}
 		c.Abort()
 		_ = c.Error(err)
 		return
@@ -33,3 +125,78 @@ func List(c *gin.Context) {
 
 	c.JSON(http.StatusOK, users)
 }
+
+// queryArguments validates the parameters and returns the arguments of the
+// page query without the limit
+func (p *listParameters) queryArguments() ([]any, error) {
+	if p.Limit <= 0 {
+		p.Limit = DefaultPageSize
+	}
+	if p.Limit > MaxPageSize {
+		p.Limit = MaxPageSize
+	}
+
+	if p.Sort == "" {
+		p.Sort = "name"
+	}
+	sortField := strings.TrimPrefix(p.Sort, "-")
+	if !slices.Contains(sortFields, sortField) {
+		return nil, errInvalidSort
+	}
+
+	var search *string
+	if p.Search != "" {
+		pattern := "%" + escapeLikePattern(p.Search) + "%"
+		search = &pattern
+	}
+
+	var service, level *string
+	if p.Permission != "" {
+		serviceName, levelName, hasLevel := strings.Cut(p.Permission, ":")
+		if serviceName == "" || (hasLevel && levelName == "") {
+			return nil, errInvalidPermission
+		}
+		service = &serviceName
+		if hasLevel {
+			level = &levelName
+		}
+	}
+
+	var cursorKey, cursorID *string
+	if p.Cursor != "" {
+		rawCursor, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+
+		var c cursor
+		err = json.Unmarshal(rawCursor, &c)
+		if err != nil || c.Sort != p.Sort || uuid.Validate(c.ID) != nil {
+			return nil, errInvalidCursor
+		}
+		cursorKey, cursorID = &c.Key, &c.ID
+	}
+
+	return []any{search, p.Disabled, p.Administrator, service, level, sortField, cursorKey, cursorID}, nil
+}
+
+// nextCursor creates the cursor pointing behind the user
+func (p *listParameters) nextCursor(user types.User) string {
+	c := cursor{Sort: p.Sort, ID: user.ID}
+	switch strings.TrimPrefix(p.Sort, "-") {
+	case "username":
+		c.Key = user.Username
+	case "email":
+		c.Key = user.Email
+	default:
+		c.Key = user.Name
+	}
+
+	rawCursor, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(rawCursor)
+}
+
+// escapeLikePattern escapes the wildcards of a LIKE pattern
+func escapeLikePattern(search string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(search)
+}
@@ -0,0 +1,121 @@
+package users
+
+import (
+	"errors"
+	"testing"
+
+	"microservice/types"
+)
+
+func TestQueryArgumentsDefaults(t *testing.T) {
+	parameters := listParameters{}
+	arguments, err := parameters.queryArguments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parameters.Limit != DefaultPageSize || parameters.Sort != "name" {
+		t.Errorf("unexpected defaults %+v", parameters)
+	}
+	if arguments[5] != "name" || arguments[6] != (*string)(nil) || arguments[7] != (*string)(nil) {
+		t.Errorf("unexpected arguments %v", arguments)
+	}
+
+	parameters = listParameters{Limit: MaxPageSize + 1}
+	if _, err := parameters.queryArguments(); err != nil || parameters.Limit != MaxPageSize {
+		t.Errorf("limit not capped to %d: %d", MaxPageSize, parameters.Limit)
+	}
+}
+
+func TestQueryArgumentsFilters(t *testing.T) {
+	parameters := listParameters{Search: `50%_off\`, Permission: "example:write", Sort: "-email"}
+	arguments, err := parameters.queryArguments()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if search := arguments[0].(*string); search == nil || *search != `%50\%\_off\\%` {
+		t.Errorf("unexpected search pattern %v", arguments[0])
+	}
+	if service := arguments[3].(*string); service == nil || *service != "example" {
+		t.Errorf("unexpected service %v", arguments[3])
+	}
+	if level := arguments[4].(*string); level == nil || *level != "write" {
+		t.Errorf("unexpected level %v", arguments[4])
+	}
+	if arguments[5] != "email" {
+		t.Errorf("unexpected sort field %v", arguments[5])
+	}
+
+	parameters = listParameters{Permission: "example"}
+	arguments, err = parameters.queryArguments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level := arguments[4].(*string); level != nil {
+		t.Errorf("level set without being requested: %s", *level)
+	}
+}
+
+func TestQueryArgumentsRejectsInvalidParameters(t *testing.T) {
+	tests := map[string]struct {
+		parameters listParameters
+		expected   error
+	}{
+		"unknownSort":       {listParameters{Sort: "id"}, errInvalidSort},
+		"emptyService":      {listParameters{Permission: ":read"}, errInvalidPermission},
+		"emptyLevel":        {listParameters{Permission: "example:"}, errInvalidPermission},
+		"malformedCursor":   {listParameters{Cursor: "not base64!"}, errInvalidCursor},
+		"cursorWithoutJSON": {listParameters{Cursor: "bm90IGpzb24"}, errInvalidCursor},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := test.parameters.queryArguments(); !errors.Is(err, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestNextCursor(t *testing.T) {
+	user := types.User{
+		ID:       "4c0aa8d4-1e6d-4d56-9d84-5d6a0b4d5f0e",
+		Name:     "Jane Doe",
+		Username: "jdoe",
+		Email:    "jane@example.com",
+	}
+
+	tests := map[string]string{
+		"name":      "Jane Doe",
+		"-username": "jdoe",
+		"email":     "jane@example.com",
+	}
+	for sort, expectedKey := range tests {
+		t.Run(sort, func(t *testing.T) {
+			page := listParameters{Sort: sort}
+			nextPage := listParameters{Sort: sort, Cursor: page.nextCursor(user)}
+			arguments, err := nextPage.queryArguments()
+			if err != nil {
+				t.Fatalf("cursor rejected: %v", err)
+			}
+			if key := arguments[6].(*string); key == nil || *key != expectedKey {
+				t.Errorf("expected cursor key %q, got %v", expectedKey, arguments[6])
+			}
+			if id := arguments[7].(*string); id == nil || *id != user.ID {
+				t.Errorf("expected cursor id %q, got %v", user.ID, arguments[7])
+			}
+		})
+	}
+}
+
+// Cursors are only valid for the sort order they have been created for
+func TestNextCursorRejectedForOtherSort(t *testing.T) {
+	page := listParameters{Sort: "name"}
+	cursor := page.nextCursor(types.User{ID: "4c0aa8d4-1e6d-4d56-9d84-5d6a0b4d5f0e"})
+
+	for _, sort := range []string{"-name", "email"} {
+		nextPage := listParameters{Sort: sort, Cursor: cursor}
+		if _, err := nextPage.queryArguments(); !errors.Is(err, errInvalidCursor) {
+			t.Errorf("cursor accepted for sort %s: %v", sort, err)
+		}
+	}
+}
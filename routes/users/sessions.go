@@ -0,0 +1,135 @@
+package users
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/wisdom-oss/common-go/v2/middleware"
+	commonTypes "github.com/wisdom-oss/common-go/v2/types"
+
+	"microservice/internal/db"
+	apiErrors "microservice/internal/errors"
+	"microservice/types"
+)
+
+// Sessions lists the active sessions of a user
+func Sessions(c *gin.Context) {
+	userID := sessionOwner(c, commonTypes.ScopeRead)
+	if userID == "" {
+		return
+	}
+
+	query, err := db.Queries.Raw("get-user-sessions")
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	sessions := make([]types.Session, 0)
+	err = pgxscan.Select(c, db.Pool, &sessions, query, userID)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// DeleteSession ends a single session of a user by revoking every refresh
+// token issued for it
+func DeleteSession(c *gin.Context) {
+	userID := sessionOwner(c, commonTypes.ScopeDelete)
+	if userID == "" {
+		return
+	}
+
+	sessionID := c.Param("sessionID")
+	if err := uuid.Validate(sessionID); err != nil {
+		c.Abort()
+		apiErrors.ErrUnknownSession.Emit(c)
+		return
+	}
+
+	query, err := db.Queries.Raw("revoke-user-session")
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	result, err := db.Pool.Exec(c, query, userID, sessionID)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	if result.RowsAffected() == 0 {
+		c.Abort()
+		apiErrors.ErrUnknownSession.Emit(c)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// DeleteSessions ends every session of a user by revoking all of their
+// refresh tokens.
+// Access tokens which have already been issued stay valid until they expire
+func DeleteSessions(c *gin.Context) {
+	userID := sessionOwner(c, commonTypes.ScopeDelete)
+	if userID == "" {
+		return
+	}
+
+	query, err := db.Queries.Raw("revoke-user-refresh-tokens")
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	_, err = db.Pool.Exec(c, query, userID)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// sessionOwner returns the id of the user whose sessions are accessed.
+// Users may always access their own sessions using "me" as user id, while
+// accessing the sessions of other users requires the supplied scope.
+// If the sessions may not be accessed, the context is aborted and an empty
+// string is returned
+func sessionOwner(c *gin.Context, scope commonTypes.Scope) string {
+	userID := c.Param("userID")
+	if userID == "me" {
+		userID = c.GetString("subject")
+		if userID == "" {
+			c.Abort()
+			_ = c.Error(errors.New("no subject found in request context"))
+			return ""
+		}
+		return userID
+	}
+
+	middleware.RequireScope{}.Gin("user-management", scope)(c)
+	if c.IsAborted() {
+		return ""
+	}
+
+	if err := uuid.Validate(userID); err != nil {
+		c.Abort()
+		apiErrors.ErrUnknownUser.Emit(c)
+		return ""
+	}
+	return userID
+}
@@ -0,0 +1,176 @@
+package users
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/wisdom-oss/common-go/v2/middleware"
+	commonTypes "github.com/wisdom-oss/common-go/v2/types"
+
+	apiErrors "microservice/internal/errors"
+	"microservice/types"
+	"microservice/utils"
+)
+
+// userPatch contains the fields which may be changed using a merge patch.
+// Fields which are not part of the patch are nil
+type userPatch struct {
+	Name          *string
+	Username      *string
+	Email         *string
+	Disabled      *bool
+	Administrator *bool
+}
+
+// Update changes the properties of a user using a JSON merge patch as
+// defined in RFC 7396.
+// Changing the administrator status requires administrator privileges and
+// the last active administrator may neither be demoted nor disabled.
+// Disabling a user revokes all of their refresh tokens
+func Update(c *gin.Context) {
+	userID := c.Param("userID")
+	if err := uuid.Validate(userID); err != nil {
+		c.Abort()
+		apiErrors.ErrUnknownUser.Emit(c)
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	patch, err := parseUserPatch(body)
+	if err != nil {
+		c.Abort()
+		res := apiErrors.ErrInvalidUserPatch
+		res.Errors = []error{err}
+		res.Emit(c)
+		return
+	}
+
+	if fields := patch.upstreamManagedFields(); len(fields) > 0 {
+		c.Abort()
+		res := apiErrors.ErrUpstreamManagedField
+		res.Errors = []error{fmt.Errorf("fields managed upstream: %v", fields)}
+		res.Emit(c)
+		return
+	}
+
+	if patch.Administrator != nil {
+		middleware.RequireScope{}.Gin("user-management", commonTypes.ScopeAdmin)(c)
+		if c.IsAborted() {
+			return
+		}
+	}
+
+	updatedUser, err := utils.UpdateUser(c, userID, func(user *types.User) error {
+		patch.apply(user)
+		return nil
+	})
+	if err != nil {
+		c.Abort()
+		switch {
+		case errors.Is(err, utils.ErrNoUser):
+			apiErrors.ErrUnknownUser.Emit(c)
+		case errors.Is(err, utils.ErrLastAdministrator):
+			apiErrors.ErrLastAdministrator.Emit(c)
+		case errors.Is(err, utils.ErrUserConflict):
+			apiErrors.ErrUserConflict.Emit(c)
+		default:
+			_ = c.Error(err)
+		}
+		return
+	}
+
+	err = types.LoadPermissions(c, updatedUser)
+	if err != nil {
+		c.Abort()
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, updatedUser)
+}
+
+// parseUserPatch reads the merge patch. As none of the fields may be
+// removed, null values are rejected
+func parseUserPatch(body []byte) (*userPatch, error) {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(body, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	var patch userPatch
+	for field, value := range fields {
+		if string(value) == "null" {
+			return nil, fmt.Errorf("field %s may not be null", field)
+		}
+
+		var target any
+		switch field {
+		case "name":
+			target = &patch.Name
+		case "username":
+			target = &patch.Username
+		case "email":
+			target = &patch.Email
+		case "disabled":
+			target = &patch.Disabled
+		case "administrator":
+			target = &patch.Administrator
+		default:
+			return nil, fmt.Errorf("field %s may not be changed", field)
+		}
+
+		err = json.Unmarshal(value, target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", field, err)
+		}
+	}
+	return &patch, nil
+}
+
+// upstreamManagedFields returns the fields of the patch which are managed by
+// the external provider
+func (p userPatch) upstreamManagedFields() []string {
+	var fields []string
+	for field, set := range map[string]bool{
+		"name":     p.Name != nil,
+		"username": p.Username != nil,
+		"email":    p.Email != nil,
+	} {
+		if set && slices.Contains(utils.UpstreamAuthoritativeFields, field) {
+			fields = append(fields, field)
+		}
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// apply sets the fields contained in the patch on the user
+func (p userPatch) apply(user *types.User) {
+	if p.Name != nil {
+		user.Name = *p.Name
+	}
+	if p.Username != nil {
+		user.Username = *p.Username
+	}
+	if p.Email != nil {
+		user.Email = *p.Email
+	}
+	if p.Disabled != nil {
+		user.Disabled = *p.Disabled
+	}
+	if p.Administrator != nil {
+		user.Administrator = *p.Administrator
+	}
+}
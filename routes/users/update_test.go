@@ -0,0 +1,95 @@
+package users
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"microservice/types"
+	"microservice/utils"
+)
+
+func TestParseUserPatch(t *testing.T) {
+	patch, err := parseUserPatch([]byte(`{"name": "Jane Doe", "disabled": true}`))
+	if err != nil {
+		t.Fatalf("valid patch rejected: %v", err)
+	}
+
+	user := types.User{
+		Name:          "John Doe",
+		Username:      "jdoe",
+		Email:         "jdoe@example.com",
+		Administrator: true,
+	}
+	patch.apply(&user)
+
+	expected := types.User{
+		Name:          "Jane Doe",
+		Username:      "jdoe",
+		Email:         "jdoe@example.com",
+		Disabled:      true,
+		Administrator: true,
+	}
+	if !reflect.DeepEqual(user, expected) {
+		t.Errorf("expected %+v, got %+v", expected, user)
+	}
+}
+
+func TestParseUserPatchRejectsInvalidPatches(t *testing.T) {
+	tests := map[string]string{
+		"notAnObject":   `["name"]`,
+		"malformed":     `{"name": `,
+		"nullValue":     `{"email": null}`,
+		"unknownField":  `{"id": "4c0aa8d4-1e6d-4d56-9d84-5d6a0b4d5f0e"}`,
+		"externalField": `{"externalIdentifier": "upstream-subject"}`,
+		"wrongType":     `{"administrator": "true"}`,
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseUserPatch([]byte(body)); err == nil {
+				t.Error("invalid patch accepted")
+			}
+		})
+	}
+}
+
+func TestParseUserPatchKeepsOmittedFields(t *testing.T) {
+	patch, err := parseUserPatch([]byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := types.User{Name: "John Doe", Disabled: true, Administrator: true}
+	expected := user
+	patch.apply(&user)
+	if !reflect.DeepEqual(user, expected) {
+		t.Errorf("empty patch changed the user to %+v", user)
+	}
+}
+
+func TestUpstreamManagedFields(t *testing.T) {
+	previousFields := utils.UpstreamAuthoritativeFields
+	t.Cleanup(func() { utils.UpstreamAuthoritativeFields = previousFields })
+
+	patch, err := parseUserPatch([]byte(`{"email": "jane@example.com", "name": "Jane Doe", "disabled": false}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		authoritativeFields []string
+		expected            []string
+	}{
+		"default":  {utils.UpstreamProfileFields, []string{"email", "name"}},
+		"narrowed": {[]string{"username", "email"}, []string{"email"}},
+		"none":     {nil, nil},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			utils.UpstreamAuthoritativeFields = test.authoritativeFields
+			if fields := patch.upstreamManagedFields(); !slices.Equal(fields, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package types
+
+import "time"
+
+// AuthorizationCode contains the result of a login which has been initiated
+// by a client. It is stored under the authorization code returned to the
+// client until the client exchanges it for a token set
+type AuthorizationCode struct {
+	// ClientID and RedirectUri contain the client the code has been issued
+	// to and the uri the code has been sent to
+	ClientID    string `json:"clientID"`
+	RedirectUri string `json:"redirect_uri"`
+
+	// Scope and Nonce contain the values supplied while initiating the login
+	Scope string `json:"scope,omitempty"`
+	Nonce string `json:"nonce,omitempty"`
+
+	// CodeChallenge contains the PKCE code challenge supplied while initiating
+	// the login. The client needs to present the matching verifier
+	CodeChallenge string `json:"codeChallenge,omitempty"`
+
+	// UserID contains the internal identifier of the user who logged in
+	UserID string `json:"userID"`
+
+	// AuthTime contains the time at which the user authenticated at the
+	// external provider
+	AuthTime time.Time `json:"authTime"`
+
+	// UpstreamIDToken contains the ID token issued by the external provider
+	UpstreamIDToken string `json:"upstreamIDToken"`
+}
@@ -1,13 +1,12 @@
 package types
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"microservice/resources"
+	"microservice/internal/keys"
 	"strings"
 
-	"github.com/lestrrat-go/jwx/v2/jwa"
-	"github.com/lestrrat-go/jwx/v2/jwe"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 )
 
@@ -25,16 +24,35 @@ type Client struct {
 	Contact struct {
 		Name  string `json:"name" db:"contact_name"`
 		EMail string `json:"email" db:"contact_email"`
-	} `json:"contact"`
-	permissions map[string][]string `json:"-" db:"-"`
+	} `json:"contact" db:""`
+	// Scopes contains the scopes granted to the client. It is unset for
+	// clients created before the scopes have been stored alongside the client
+	Scopes []string `json:"scopes" db:"scopes"`
+	// JWKS contains the public keys registered by the client to authenticate
+	// using signed assertions
+	JWKS []byte `json:"-" db:"jwks"`
+	// JWKSUri points to the public keys of the client if they have not been
+	// registered directly
+	JWKSUri *string `json:"jwksUri,omitempty" db:"jwks_uri"`
+	// IDTokenSigningAlgorithm and AccessTokenSigningAlgorithm select the
+	// algorithms used to sign the tokens issued for the client. The default
+	// algorithm is used if they are unset
+	IDTokenSigningAlgorithm     *string `json:"idTokenSignedResponseAlg,omitempty" db:"id_token_signed_response_alg"`
+	AccessTokenSigningAlgorithm *string `json:"accessTokenSignedResponseAlg,omitempty" db:"access_token_signed_response_alg"`
+	// RedirectURIs contains the uris the client may receive authorization
+	// codes at
+	RedirectURIs []string            `json:"redirectUris,omitempty" db:"redirect_uris"`
+	permissions  map[string][]string `json:"-" db:"-"`
 }
 
 func (c Client) GetID() string {
 	return c.ID
 }
 
-func (c Client) Permissions() map[string][]string {
-	return c.permissions
+// Permissions returns the permissions read from the client secret or client
+// assertion. As they are not stored in the database, no error is returned
+func (c Client) Permissions(_ context.Context) (map[string][]string, error) {
+	return c.permissions, nil
 }
 
 func (c Client) IsActive() bool {
@@ -46,10 +64,7 @@ func (c Client) IsAdministrator() bool {
 }
 
 func (c *Client) ReadPermissions(clientID, clientSecret string) error {
-	decryptedClientSecret, err := jwe.Decrypt(
-		[]byte(clientSecret),
-		jwe.WithKey(jwa.ECDH_ES, resources.PrivateEncryptionKey),
-	)
+	decryptedClientSecret, err := keys.Decrypt([]byte(clientSecret))
 	if err != nil {
 		return err
 	}
@@ -58,7 +73,7 @@ func (c *Client) ReadPermissions(clientID, clientSecret string) error {
 	clientToken, err := jwt.Parse(decryptedClientSecret,
 		jwt.WithIssuer("user-management"),
 		jwt.WithVerify(true),
-		jwt.WithKey(resources.PublicSigningKey.Algorithm(), resources.PublicSigningKey),
+		jwt.WithKeySet(keys.VerificationKeys()),
 	)
 
 	if err != nil {
@@ -74,16 +89,31 @@ func (c *Client) ReadPermissions(clientID, clientSecret string) error {
 		return ErrNoScopesSet
 	}
 
-	scopes, ok := iface.([]string)
+	rawScopes, ok := iface.([]any)
 	if !ok {
 		return ErrScopesWrongFormat
 	}
 
+	scopes := make([]string, 0, len(rawScopes))
+	for _, rawScope := range rawScopes {
+		scope, ok := rawScope.(string)
+		if !ok {
+			return ErrScopesWrongFormat
+		}
+		scopes = append(scopes, scope)
+	}
+	return c.SetScopes(scopes)
+}
+
+// SetScopes sets the permissions of the client from a list of scopes in the
+// format service:level
+func (c *Client) SetScopes(scopes []string) error {
 	permissions := make(map[string][]string)
 	for _, scope := range scopes {
-		parts := strings.Split(scope, ":")
-		service := parts[0]
-		level := parts[1]
+		service, level, found := strings.Cut(scope, ":")
+		if !found || strings.Contains(level, ":") {
+			return ErrScopesWrongFormat
+		}
 
 		if permissions[service] == nil {
 			permissions[service] = make([]string, 0)
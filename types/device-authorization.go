@@ -0,0 +1,38 @@
+package types
+
+import "time"
+
+const (
+	DeviceAuthorizationPending  = "pending"
+	DeviceAuthorizationApproved = "approved"
+	DeviceAuthorizationDenied   = "denied"
+)
+
+// DeviceAuthorization contains the state of a device authorization request
+// as defined in RFC 8628.
+// It is stored in redis using the device code as key until the device
+// successfully retrieved a token set or the request expired
+type DeviceAuthorization struct {
+	UserCode string `json:"userCode"`
+	ClientID string `json:"clientID"`
+	Scope    string `json:"scope,omitempty"`
+
+	// ClientAuthenticated is set if the client authenticated itself while
+	// requesting the device code. It then needs to authenticate while
+	// exchanging the device code as well
+	ClientAuthenticated bool `json:"clientAuthenticated,omitempty"`
+
+	// Status is either DeviceAuthorizationPending,
+	// DeviceAuthorizationApproved or DeviceAuthorizationDenied
+	Status string `json:"status"`
+
+	// UserID contains the internal identifier of the user that approved the
+	// request
+	UserID   string    `json:"userID,omitempty"`
+	AuthTime time.Time `json:"authTime,omitempty"`
+
+	// UpstreamIDToken contains the ID token issued by the external provider
+	// during the approval. It is required to end the session at the external
+	// provider
+	UpstreamIDToken string `json:"upstreamIDToken,omitempty"`
+}
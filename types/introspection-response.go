@@ -0,0 +1,20 @@
+package types
+
+// IntrospectionResponse contains the information about a token as defined in
+// RFC 7662.
+// If the token is not active, only the Active field is sent to the client
+type IntrospectionResponse struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+
+	// Confirmation contains the thumbprint of the DPoP key the token is bound
+	// to
+	Confirmation map[string]string `json:"cnf,omitempty"`
+}
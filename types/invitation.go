@@ -0,0 +1,16 @@
+package types
+
+import "time"
+
+// Invitation grants permissions to a user before their first login. The
+// invitation is accepted during the first login of the user matching the
+// external identifier or the verified email address
+type Invitation struct {
+	ID                 string              `json:"id" db:"id"`
+	Email              *string             `json:"email" db:"email"`
+	ExternalIdentifier *string             `json:"externalIdentifier" db:"external_identifier"`
+	Permissions        map[string][]string `json:"permissions" db:"permissions"`
+	ExpiresAt          time.Time           `json:"expiresAt" db:"expires_at"`
+	CreatedAt          time.Time           `json:"createdAt" db:"created_at"`
+	CreatedBy          *string             `json:"createdBy" db:"created_by"`
+}
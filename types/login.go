@@ -1,6 +1,35 @@
 package types
 
 type LoginParameters struct {
+	// RedirectUri contains the uri the external provider redirects the user
+	// to after logging in
 	RedirectUri  string `json:"redirect_uri"`
 	CodeVerifier string `json:"codeVerifier"`
+
+	// ClientRedirectUri contains the uri of the client the user is sent back
+	// to together with the authorization code
+	ClientRedirectUri string `json:"clientRedirectUri,omitempty"`
+
+	// State contains the value supplied by the client which is returned to
+	// the client unchanged
+	State string `json:"state,omitempty"`
+
+	// CodeChallenge contains the PKCE code challenge supplied by the client
+	CodeChallenge string `json:"codeChallenge,omitempty"`
+
+	// ClientID contains the identifier of the client that initiated the login
+	// and is used as the audience of the ID token
+	ClientID string `json:"clientID,omitempty"`
+
+	// Scope contains the space-separated scopes requested while initiating
+	// the login
+	Scope string `json:"scope,omitempty"`
+
+	// Nonce contains the value supplied by the client to associate the ID
+	// token with the session of the client
+	Nonce string `json:"nonce,omitempty"`
+
+	// DeviceCode is set if the login has been initiated to approve a device
+	// authorization request
+	DeviceCode string `json:"deviceCode,omitempty"`
 }
@@ -0,0 +1,194 @@
+package types
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/gin-gonic/gin"
+
+	"microservice/internal/cache"
+	"microservice/internal/db"
+)
+
+// PermissionLoaderKey is the key under which the permission loader of a
+// request is stored in the gin context
+const PermissionLoaderKey = "permission-loader"
+
+// PermissionLoader reads the permissions of users and caches them.
+// A loader is created for every request to avoid loading the permissions of
+// a user multiple times while handling it, without serving outdated
+// permissions to later requests
+type PermissionLoader struct {
+	lock        sync.Mutex
+	permissions map[string]map[string][]string
+
+	// administratorPermissions contains every scope of every service, which
+	// is granted to administrators
+	administratorPermissions map[string][]string
+}
+
+// NewPermissionLoader creates an empty permission loader
+func NewPermissionLoader() *PermissionLoader {
+	return &PermissionLoader{permissions: make(map[string]map[string][]string)}
+}
+
+// PermissionLoaderMiddleware attaches a new permission loader to every
+// request
+func PermissionLoaderMiddleware(c *gin.Context) {
+	c.Set(PermissionLoaderKey, NewPermissionLoader())
+	c.Next()
+}
+
+// PermissionLoaderFrom returns the permission loader attached to the
+// context. If no loader is attached, a new loader is returned which only
+// caches the permissions for the current call
+func PermissionLoaderFrom(ctx context.Context) *PermissionLoader {
+	if loader, ok := ctx.Value(PermissionLoaderKey).(*PermissionLoader); ok {
+		return loader
+	}
+	return NewPermissionLoader()
+}
+
+// LoadPermissions loads the permissions of the users using the loader
+// attached to the context and sets them on the users
+func LoadPermissions(ctx context.Context, users ...*User) error {
+	return PermissionLoaderFrom(ctx).Load(ctx, users...)
+}
+
+// Permissions returns the permissions of the user
+func (l *PermissionLoader) Permissions(ctx context.Context, user *User) (map[string][]string, error) {
+	err := l.Load(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return user.permissions, nil
+}
+
+// Load reads the permissions of every user which have not been loaded yet in
+// a single query and sets them on the users
+func (l *PermissionLoader) Load(ctx context.Context, users ...*User) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var missingUserIDs []string
+	var requiresAdministratorPermissions bool
+	for _, user := range users {
+		if user.Administrator {
+			requiresAdministratorPermissions = requiresAdministratorPermissions || l.administratorPermissions == nil
+			continue
+		}
+		if _, loaded := l.permissions[user.ID]; !loaded {
+			missingUserIDs = append(missingUserIDs, user.ID)
+		}
+	}
+
+	if requiresAdministratorPermissions {
+		err := l.loadAdministratorPermissions(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(missingUserIDs) > 0 {
+		err := l.loadUserPermissions(ctx, missingUserIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, user := range users {
+		if user.Administrator {
+			user.permissions = l.administratorPermissions
+			continue
+		}
+		user.permissions = l.permissions[user.ID]
+	}
+	return nil
+}
+
+// Forget removes the cached permissions of the user, e.g. after they have
+// been changed
+func (l *PermissionLoader) Forget(userID string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	delete(l.permissions, userID)
+}
+
+func (l *PermissionLoader) loadAdministratorPermissions(ctx context.Context) error {
+	if cache.Get(ctx, cache.AdministratorPermissionsKey, &l.administratorPermissions) {
+		return nil
+	}
+	cacheVersion := cache.ReadVersion(ctx, cache.AdministratorPermissionsKey)
+
+	query, err := db.Queries.Raw("get-services")
+	if err != nil {
+		return err
+	}
+
+	var services []Service
+	err = pgxscan.Select(ctx, db.Pool, &services, query)
+	if err != nil {
+		return err
+	}
+
+	permissions := make(map[string][]string)
+	for _, service := range services {
+		permissions[service.Name] = append(permissions[service.Name], service.SupportedScopes...)
+	}
+	l.administratorPermissions = permissions
+	cache.Set(ctx, cacheVersion, permissions)
+	return nil
+}
+
+func (l *PermissionLoader) loadUserPermissions(ctx context.Context, userIDs []string) error {
+	keys := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		keys[i] = cache.PermissionsKey(userID)
+	}
+	cachedPermissions := cache.GetMany(ctx, keys)
+
+	var uncachedUserIDs, uncachedKeys []string
+	for i, userID := range userIDs {
+		var permissions map[string][]string
+		if err := json.Unmarshal(cachedPermissions[keys[i]], &permissions); err != nil || permissions == nil {
+			uncachedUserIDs = append(uncachedUserIDs, userID)
+			uncachedKeys = append(uncachedKeys, keys[i])
+			continue
+		}
+		l.permissions[userID] = permissions
+	}
+	if len(uncachedUserIDs) == 0 {
+		return nil
+	}
+	userIDs = uncachedUserIDs
+	cacheVersions := cache.Versions(ctx, uncachedKeys...)
+
+	query, err := db.Queries.Raw("get-users-permissions")
+	if err != nil {
+		return err
+	}
+
+	var permissionMappings []struct {
+		UserID string `db:"user_id"`
+		Name   string `db:"name"`
+		Level  string `db:"level"`
+	}
+	err = pgxscan.Select(ctx, db.Pool, &permissionMappings, query, userIDs)
+	if err != nil {
+		return err
+	}
+
+	// users without any permission are cached as well
+	for _, userID := range userIDs {
+		l.permissions[userID] = make(map[string][]string)
+	}
+	for _, mapping := range permissionMappings {
+		l.permissions[mapping.UserID][mapping.Name] = append(l.permissions[mapping.UserID][mapping.Name], mapping.Level)
+	}
+	for i, userID := range userIDs {
+		cache.Set(ctx, cacheVersions[i], l.permissions[userID])
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package types
+
+import "time"
+
+// RefreshToken represents the database entry of a refresh token issued by
+// the service
+type RefreshToken struct {
+	ID        string    `db:"id"`
+	Active    bool      `db:"active"`
+	ExpiresAt time.Time `db:"expires_at"`
+
+	// Family contains the identifier of the refresh token family the token
+	// belongs to. Tokens issued before the introduction of token families are
+	// not part of any family
+	Family *string `db:"family_id"`
+}
@@ -0,0 +1,15 @@
+package types
+
+import "time"
+
+// Session represents a login of a user. It contains every refresh token that
+// has been issued by rotating the refresh token issued during the login
+type Session struct {
+	ID         string    `json:"id" db:"id"`
+	ClientID   *string   `json:"clientID" db:"client_id"`
+	CreatedAt  time.Time `json:"createdAt" db:"created_at"`
+	LastUsedAt time.Time `json:"lastUsedAt" db:"last_used_at"`
+	IP         *string   `json:"ip" db:"ip"`
+	UserAgent  *string   `json:"userAgent" db:"user_agent"`
+	ExpiresAt  time.Time `json:"expiresAt" db:"expires_at"`
+}
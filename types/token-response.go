@@ -5,4 +5,10 @@ type TokenResponse struct {
 	ExpiresIn    int    `json:"expires_in"`
 	TokenType    string `json:"token_type"`
 	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+
+	// IssuedTokenType is only set if the token has been issued using a token
+	// exchange
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
 }
@@ -2,11 +2,9 @@ package types
 
 import (
 	"context"
+	"time"
 
-	"github.com/georgysavva/scany/v2/pgxscan"
 	"github.com/go-jose/go-jose/v4/json"
-
-	"microservice/internal/db"
 )
 
 type User struct {
@@ -17,51 +15,29 @@ type User struct {
 	Username           string `json:"username" db:"username"`
 	Disabled           bool   `json:"disabled" db:"disabled"`
 	Administrator      bool   `json:"administrator" db:"is_admin"`
+
+	// LastSyncedAt contains the time at which the profile has last been
+	// synchronized with the external provider
+	LastSyncedAt *time.Time `json:"lastSyncedAt,omitempty" db:"last_synced_at"`
+
+	// ScimManaged is set for users provisioned or changed using SCIM. Their
+	// profile is managed by the identity management system instead of the
+	// external provider
+	ScimManaged bool `json:"scimManaged" db:"scim_managed"`
+
+	// permissions contains the permissions set by the PermissionLoader. They
+	// need to be loaded before marshalling the user
+	permissions map[string][]string `db:"-"`
 }
 
 func (u User) GetID() string {
 	return u.ID
 }
 
-func (u User) Permissions() map[string][]string {
-	if u.Administrator {
-		query, err := db.Queries.Raw("get-services")
-		if err != nil {
-			panic(err)
-		}
-		var services []Service
-		err = pgxscan.Select(context.Background(), db.Pool, &services, query)
-		if err != nil {
-			panic(err)
-		}
-
-		permissions := make(map[string][]string)
-		for _, service := range services {
-			permissions[service.Name] = append(permissions[service.Name], service.SupportedScopes...)
-		}
-		return permissions
-	}
-	query, err := db.Queries.Raw("get-user-permissions")
-	if err != nil {
-		return nil
-	}
-
-	var permissionMappings []struct {
-		Name  string `db:"name"`
-		Level string `db:"level"`
-	}
-	err = pgxscan.Select(context.Background(), db.Pool, &permissionMappings, query, u.GetID())
-	if err != nil {
-		panic(err)
-	}
-
-	permissions := make(map[string][]string)
-
-	for _, mapping := range permissionMappings {
-		permissions[mapping.Name] = append(permissions[mapping.Name], mapping.Level)
-	}
-
-	return permissions
+// Permissions returns the permissions of the user. They are loaded using the
+// permission loader attached to the context
+func (u User) Permissions(ctx context.Context) (map[string][]string, error) {
+	return PermissionLoaderFrom(ctx).Permissions(ctx, &u)
 }
 
 func (u User) IsAdministrator() bool {
@@ -81,6 +57,8 @@ func (u User) MarshalJSON() ([]byte, error) {
 		Username           string              `json:"username" db:"username"`
 		Disabled           bool                `json:"disabled" db:"disabled"`
 		Administrator      bool                `json:"administrator" db:"is_admin"`
+		LastSyncedAt       *time.Time          `json:"lastSyncedAt,omitempty" db:"last_synced_at"`
+		ScimManaged        bool                `json:"scimManaged" db:"scim_managed"`
 		Permissions        map[string][]string `json:"permissions"`
 	}
 	o := output{
@@ -91,7 +69,9 @@ func (u User) MarshalJSON() ([]byte, error) {
 		Username:           u.Username,
 		Disabled:           u.Disabled,
 		Administrator:      u.Administrator,
-		Permissions:        u.Permissions(),
+		LastSyncedAt:       u.LastSyncedAt,
+		ScimManaged:        u.ScimManaged,
+		Permissions:        u.permissions,
 	}
 	return json.Marshal(o)
 }
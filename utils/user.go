@@ -2,14 +2,18 @@ package utils
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
-	"net/http"
 	"reflect"
+	"slices"
+	"strings"
 
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
 	"github.com/georgysavva/scany/v2/pgxscan"
-	"github.com/go-jose/go-jose/v4/json"
+	"github.com/jackc/pgx/v5/pgconn"
 	"golang.org/x/oauth2"
 
+	"microservice/internal/cache"
 	"microservice/internal/db"
 	oidc2 "microservice/oidc"
 	"microservice/types"
@@ -17,21 +21,39 @@ import (
 
 var ErrNoUser = errors.New("no user with this id")
 
-// GetUser retrieves a User object from the database
+// ErrLastAdministrator is returned if a change would demote, disable or
+// delete the last active administrator
+var ErrLastAdministrator = errors.New("the last active administrator may neither be demoted nor disabled")
+
+// ErrUserConflict is returned if a change would assign a username or an
+// external identifier which is already used by another user
+var ErrUserConflict = errors.New("the username or external identifier is already used")
+
+// uniqueViolation is the error code reported by PostgreSQL if a unique
+// constraint is violated
+const uniqueViolation = "23505"
+
+// cachedUser is used to store users in the cache without the permissions
+// added by the custom marshalling of types.User
+type cachedUser types.User
+
+// GetUser retrieves a User object from the cache or the database
 func GetUser[T types.InternalIdentifier | types.ExternalIdentifier](id T) (*types.User, error) {
 	externalIDType := reflect.TypeOf(types.ExternalIdentifier(""))
 	internalIDType := reflect.TypeOf(types.InternalIdentifier(""))
 	parameterType := reflect.TypeOf(id)
 
-	var rawQuery string
+	var rawQuery, cacheKey string
 	var err error
 	if parameterType.AssignableTo(externalIDType) {
+		cacheKey = cache.ExternalUserKey(string(id))
 		rawQuery, err = db.Queries.Raw("get-user-by-external-id")
 		if err != nil {
 			return nil, err
 		}
 	}
 	if parameterType.AssignableTo(internalIDType) {
+		cacheKey = cache.UserKey(string(id))
 		rawQuery, err = db.Queries.Raw("get-user-by-internal-id")
 		if err != nil {
 			return nil, err
@@ -39,6 +61,16 @@ func GetUser[T types.InternalIdentifier | types.ExternalIdentifier](id T) (*type
 
 	}
 
+	var cachedEntry cachedUser
+	if cache.Get(context.Background(), cacheKey, &cachedEntry) {
+		user := types.User(cachedEntry)
+		return &user, nil
+	}
+
+	// the user is only cached under the requested key, as invalidations of
+	// the other key may have been missed while loading the user
+	cacheVersion := cache.ReadVersion(context.Background(), cacheKey)
+
 	var user types.User
 	err = pgxscan.Get(context.Background(), db.Pool, &user, rawQuery, string(id))
 	if err != nil {
@@ -47,41 +79,346 @@ func GetUser[T types.InternalIdentifier | types.ExternalIdentifier](id T) (*type
 		}
 		return nil, err
 	}
+
+	cache.Set(context.Background(), cacheVersion, cachedUser(user))
 	return &user, nil
 }
 
-func CreateUser(subject string, token *oauth2.Token) (*types.User, error) {
-	req, err := http.NewRequest("GET", oidc2.OidcProvider.UserInfoEndpoint(), nil)
+// UpstreamAuthoritativeFields contains the profile fields which are managed
+// by the external provider. They are synchronized on every login and may not
+// be changed locally.
+// Every profile field is managed upstream by default
+var UpstreamAuthoritativeFields = slices.Clone(UpstreamProfileFields)
+
+// UpstreamProfileFields contains the profile fields which may be managed by
+// the external provider
+var UpstreamProfileFields = []string{"name", "username", "email"}
+
+// upstreamProfile contains the profile of a user as reported by the external
+// provider
+type upstreamProfile struct {
+	Name          string    `json:"name"`
+	Username      string    `json:"preferred_username"`
+	Email         string    `json:"email"`
+	EmailVerified claimBool `json:"email_verified"`
+}
+
+// claimBool is a boolean claim. Some providers send booleans as strings, which
+// are accepted as well
+type claimBool bool
+
+func (b *claimBool) UnmarshalJSON(data []byte) error {
+	var value any
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case bool:
+		*b = claimBool(value)
+	case string:
+		*b = claimBool(strings.EqualFold(value, "true"))
+	default:
+		*b = false
+	}
+	return nil
+}
+
+// readUpstreamProfile reads the profile of the user from the ID token and
+// the userinfo endpoint of the external provider. The claims returned by the
+// userinfo endpoint take precedence
+func readUpstreamProfile(ctx context.Context, token *oauth2.Token, idToken *gooidc.IDToken) (upstreamProfile, error) {
+	var profile upstreamProfile
+	err := idToken.Claims(&profile)
+	if err != nil {
+		return profile, err
+	}
+
+	userInfo, err := oidc2.OidcProvider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+	if err != nil {
+		return profile, err
+	}
+
+	var userInfoProfile upstreamProfile
+	err = userInfo.Claims(&userInfoProfile)
+	if err != nil {
+		return profile, err
+	}
+
+	for _, field := range []struct{ target, value *string }{
+		{&profile.Name, &userInfoProfile.Name},
+		{&profile.Username, &userInfoProfile.Username},
+	} {
+		if *field.value != "" {
+			*field.target = *field.value
+		}
+	}
+
+	// the verification status belongs to the email address it is reported
+	// with
+	if userInfoProfile.Email != "" {
+		profile.Email = userInfoProfile.Email
+		profile.EmailVerified = userInfoProfile.EmailVerified
+	}
+	return profile, nil
+}
+
+// CreateUser creates the user logging in for the first time using the
+// profile reported by the external provider.
+// Pending invitations for the external identifier or the email address are
+// accepted and grant their permissions to the user. Invitations are only
+// matched by the email address if the provider reports it as verified
+func CreateUser(ctx context.Context, token *oauth2.Token, idToken *gooidc.IDToken) (*types.User, error) {
+	profile, err := readUpstreamProfile(ctx, token, idToken)
 	if err != nil {
 		return nil, err
 	}
-	token.SetAuthHeader(req)
-	client := http.Client{}
-	res, err := client.Do(req)
+
+	tx, err := db.Pool.Begin(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback(ctx)
 
-	var desiredUserInformation struct {
-		Username string `json:"preferred_username"`
-		Name     string `json:"name"`
-		Email    string `json:"email"`
+	query, err := db.Queries.Raw("create-user")
+	if err != nil {
+		return nil, err
 	}
 
-	err = json.NewDecoder(res.Body).Decode(&desiredUserInformation)
+	var user types.User
+	err = pgxscan.Get(ctx, tx, &user, query, idToken.Subject, profile.Name, profile.Username, profile.Email)
 	if err != nil {
 		return nil, err
 	}
 
-	query, err := db.Queries.Raw("create-user")
+	query, err = db.Queries.Raw("accept-invitations")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, query, user.ID, idToken.Subject, profile.Email, profile.Email != "" && bool(profile.EmailVerified))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the accepted invitations granted permissions to the user
+	types.PermissionLoaderFrom(ctx).Forget(user.ID)
+	return &user, nil
+}
+
+// SyncUser updates the fields of the user managed by the external provider
+// to match the profile reported during the login.
+// Claims which are missing in the profile do not clear the stored values.
+// Users managed using SCIM are not synchronized, as the identity management
+// system is authoritative for their profile
+func SyncUser(ctx context.Context, user *types.User, token *oauth2.Token, idToken *gooidc.IDToken) (*types.User, error) {
+	if user.ScimManaged {
+		return user, nil
+	}
+
+	profile, err := readUpstreamProfile(ctx, token, idToken)
+	if err != nil {
+		return nil, err
+	}
+
+	name, username, email := user.Name, user.Username, user.Email
+	for _, field := range []struct {
+		name   string
+		target *string
+		value  string
+	}{
+		{"name", &name, profile.Name},
+		{"username", &username, profile.Username},
+		{"email", &email, profile.Email},
+	} {
+		if field.value != "" && slices.Contains(UpstreamAuthoritativeFields, field.name) {
+			*field.target = field.value
+		}
+	}
+
+	query, err := db.Queries.Raw("sync-user-profile")
+	if err != nil {
+		return nil, err
+	}
+
+	var syncedUser types.User
+	err = pgxscan.Get(ctx, db.Pool, &syncedUser, query, user.ID, name, username, email)
+	if err != nil {
+		return nil, err
+	}
+	return &syncedUser, nil
+}
+
+// UpdateUser applies the change to the user and stores the result in a
+// single transaction.
+// The last active administrator may neither be demoted nor disabled and
+// disabling a user revokes all of their refresh tokens.
+// Errors returned by the change are passed through unchanged
+func UpdateUser(ctx context.Context, userID string, change func(user *types.User) error) (*types.User, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// the active administrators are locked before the user to prevent two
+	// concurrent changes from demoting the last two administrators
+	query, err := db.Queries.Raw("lock-active-administrators")
+	if err != nil {
+		return nil, err
+	}
+
+	var activeAdministrators []string
+	err = pgxscan.Select(ctx, tx, &activeAdministrators, query)
+	if err != nil {
+		return nil, err
+	}
+
+	query, err = db.Queries.Raw("lock-user")
+	if err != nil {
+		return nil, err
+	}
+
+	var user types.User
+	err = pgxscan.Get(ctx, tx, &user, query, userID)
+	if err != nil {
+		if pgxscan.NotFound(err) {
+			return nil, ErrNoUser
+		}
+		return nil, err
+	}
+
+	previousUser := user
+	err = change(&user)
+	if err != nil {
+		return nil, err
+	}
+
+	if removesLastAdministrator(previousUser, user, len(activeAdministrators)) {
+		return nil, ErrLastAdministrator
+	}
+
+	query, err = db.Queries.Raw("update-user")
+	if err != nil {
+		return nil, err
+	}
+
+	var updatedUser types.User
+	err = pgxscan.Get(ctx, tx, &updatedUser, query, userID, user.Name, user.Username, user.Email, user.Disabled, user.Administrator, user.ExternalIdentifier, user.ScimManaged)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return nil, ErrUserConflict
+		}
+		return nil, err
+	}
+
+	if updatedUser.Disabled && !previousUser.Disabled {
+		query, err = db.Queries.Raw("revoke-user-refresh-tokens")
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec(ctx, query, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit(ctx)
 	if err != nil {
 		return nil, err
 	}
+	types.PermissionLoaderFrom(ctx).Forget(userID)
+	return &updatedUser, nil
+}
+
+// isActiveAdministrator reports if the user is an administrator which has not
+// been disabled
+func isActiveAdministrator(user types.User) bool {
+	return user.Administrator && !user.Disabled
+}
+
+// removesLastAdministrator reports if the change of the user demotes or
+// disables the last active administrator
+func removesLastAdministrator(previousUser, user types.User, activeAdministrators int) bool {
+	return isActiveAdministrator(previousUser) && !isActiveAdministrator(user) && activeAdministrators <= 1
+}
+
+// DeleteUser deletes the user in a single transaction.
+// The last active administrator may not be deleted
+func DeleteUser(ctx context.Context, userID string) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// the active administrators are locked before the user to prevent two
+	// concurrent deletions from removing the last two administrators
+	query, err := db.Queries.Raw("lock-active-administrators")
+	if err != nil {
+		return err
+	}
+
+	var activeAdministrators []string
+	err = pgxscan.Select(ctx, tx, &activeAdministrators, query)
+	if err != nil {
+		return err
+	}
 
-	_, err = db.Pool.Exec(context.Background(), query, subject, desiredUserInformation.Name, desiredUserInformation.Username, desiredUserInformation.Email)
+	query, err = db.Queries.Raw("lock-user")
+	if err != nil {
+		return err
+	}
+
+	var user types.User
+	err = pgxscan.Get(ctx, tx, &user, query, userID)
+	if err != nil {
+		if pgxscan.NotFound(err) {
+			return ErrNoUser
+		}
+		return err
+	}
+
+	if isActiveAdministrator(user) && len(activeAdministrators) <= 1 {
+		return ErrLastAdministrator
+	}
+
+	query, err = db.Queries.Raw("delete-user")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ProvisionUser creates the user before their first login, e.g. when they
+// are provisioned by an identity management system. The user is marked as
+// managed using SCIM
+func ProvisionUser(ctx context.Context, user types.User) (*types.User, error) {
+	query, err := db.Queries.Raw("create-provisioned-user")
 	if err != nil {
 		return nil, err
 	}
 
-	return GetUser(types.ExternalIdentifier(subject))
+	var createdUser types.User
+	err = pgxscan.Get(ctx, db.Pool, &createdUser, query, user.ExternalIdentifier, user.Name, user.Username, user.Email, user.Disabled)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return nil, ErrUserConflict
+		}
+		return nil, err
+	}
+	return &createdUser, nil
 }
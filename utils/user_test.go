@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"testing"
+
+	"microservice/types"
+)
+
+func TestRemovesLastAdministrator(t *testing.T) {
+	administrator := types.User{Administrator: true}
+	disabledAdministrator := types.User{Administrator: true, Disabled: true}
+	user := types.User{}
+
+	tests := []struct {
+		name                 string
+		previousUser, user   types.User
+		activeAdministrators int
+		removes              bool
+	}{
+		{"demoteLast", administrator, user, 1, true},
+		{"disableLast", administrator, disabledAdministrator, 1, true},
+		{"demoteOneOfTwo", administrator, user, 2, false},
+		{"keepLast", administrator, administrator, 1, false},
+		{"changeUser", user, user, 1, false},
+		{"promoteUser", user, administrator, 1, false},
+		{"demoteDisabled", disabledAdministrator, user, 1, false},
+		{"enableDisabled", disabledAdministrator, administrator, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			removes := removesLastAdministrator(test.previousUser, test.user, test.activeAdministrators)
+			if removes != test.removes {
+				t.Errorf("expected %v, got %v", test.removes, removes)
+			}
+		})
+	}
+}